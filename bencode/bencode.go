@@ -2,6 +2,7 @@ package bencode
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 )
 
@@ -47,15 +48,18 @@ func DecodeString(r io.Reader) (string, error) {
 		return "", ErrInvalidStringFormat
 	}
 
-	buf := make([]byte, num)
-
-	if n, err := io.ReadAtLeast(br, buf, num); err != nil {
+	// 长度前缀来自输入数据，不能据此一次性分配内存，按实际读到的数据逐步增长
+	buf := new(bytes.Buffer)
+	buf.Grow(min(num, MAX_STRING_PREALLOC))
+	if n, err := io.CopyN(buf, br, int64(num)); err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	} else if err != nil {
 		return "", err
-	} else if n != num { // 读取长度不匹配
+	} else if int(n) != num { // 读取长度不匹配
 		return "", ErrStringLength
 	}
 
-	return string(buf), nil
+	return buf.String(), nil
 }
 
 // 编码写入一个整数
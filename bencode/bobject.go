@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"sort"
 )
 
 type BObject struct {
@@ -95,13 +96,18 @@ func (o *BObject) Bencode(w io.Writer) (int, error) {
 		if err != nil {
 			return 0, nil
 		}
-		for k, v := range dict {
+		keys := make([]string, 0, len(dict))
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // 字典的键必须按字典序排列
+		for _, k := range keys {
 			n, err := EncodeString(bw, k)
 			if err != nil {
 				return 0, nil
 			}
 			wLen += n
-			n, err = v.Bencode(bw)
+			n, err = dict[k].Bencode(bw)
 			if err != nil {
 				return 0, nil
 			}
@@ -162,7 +168,11 @@ func Parse(r io.Reader) (*BObject, error) {
 		br.ReadByte() // 读取 "l"
 		var val []*BObject
 		for {
-			if p, _ := br.Peek(1); p[0] == 'e' {
+			p, err := br.Peek(1)
+			if err != nil { // 缺少结尾的 "e"
+				return nil, io.ErrUnexpectedEOF
+			}
+			if p[0] == 'e' {
 				br.ReadByte() // 读取 "e"
 				break
 			}
@@ -177,7 +187,11 @@ func Parse(r io.Reader) (*BObject, error) {
 		br.ReadByte() // 读取 "d"
 		dict := make(map[string]*BObject)
 		for {
-			if p, _ := br.Peek(1); p[0] == 'e' {
+			p, err := br.Peek(1)
+			if err != nil { // 缺少结尾的 "e"
+				return nil, io.ErrUnexpectedEOF
+			}
+			if p[0] == 'e' {
 				br.ReadByte() // 读取 "e"
 				break
 			}
@@ -246,6 +246,21 @@ func TestParse(t *testing.T) {
 			input:   "5:abc",
 			wantErr: io.ErrUnexpectedEOF,
 		},
+		{
+			name:    "Truncated list",
+			input:   "li1e",
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "Truncated dict",
+			input:   "d1:ai1e",
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "Huge string length",
+			input:   "99999999999999:abc",
+			wantErr: io.ErrUnexpectedEOF,
+		},
 		{
 			name:  "Unordered dict keys",
 			input: "d1:zi2e1:ai1ee",
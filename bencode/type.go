@@ -7,6 +7,8 @@ type allowedTypes interface {
 	string | int | []*BObject | map[string]*BObject
 }
 
+const MAX_STRING_PREALLOC = 64 * 1024 // 解码字符串时预分配的最大内存
+
 const (
 	BSTR BType = iota
 	BINT
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Akimio521/torrent-go/dht"
+)
+
+type nodeJSON struct { // 节点信息（JSON 输出）
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type peersJSON struct { // peers 子命令的输出
+	InfoHash string   `json:"info_hash"`
+	Peers    []string `json:"peers"`
+	Nodes    int      `json:"routing_table_nodes"`
+	Elapsed  string   `json:"elapsed"`
+}
+
+type pingJSON struct { // ping 子命令的输出
+	Addr string `json:"addr"`
+	ID   string `json:"id"`
+	RTT  string `json:"rtt"`
+}
+
+type sampleJSON struct { // sample 子命令的输出
+	Addr     string     `json:"addr"`
+	Interval int        `json:"interval"`
+	Num      int        `json:"num"`
+	Samples  []string   `json:"samples"`
+	Nodes    []nodeJSON `json:"nodes"`
+}
+
+func main() {
+	port := flag.Uint("port", 0, "UDP port to listen on (0 for a random port)")
+	bootstrap := flag.String("bootstrap", strings.Join(dht.DEFAULT_BOOTSTRAP_NODES, ","), "Comma separated DHT bootstrap nodes")
+	stateFile := flag.String("state", "", "File to persist the DHT node id and routing table")
+	announce := flag.Uint("announce", 0, "Port to announce for `peers` (0 to only look up)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <command> <arg>\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  peers <infohash>  find peers of a torrent through the DHT")
+		fmt.Fprintln(flag.CommandLine.Output(), "  ping <addr>       ping a DHT node")
+		fmt.Fprintln(flag.CommandLine.Output(), "  sample <addr>     request infohash samples from a DHT node (BEP 51)")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		fmt.Println("listen udp error:", err.Error())
+		os.Exit(1)
+	}
+	node := dht.NewNode(conn, dht.Config{
+		BootstrapNodes: strings.Split(*bootstrap, ","),
+		StateFile:      *stateFile,
+	})
+	go node.Serve()
+	defer node.Close()
+
+	var out any
+	switch cmd, arg := flag.Arg(0), flag.Arg(1); cmd {
+	case "peers":
+		out, err = findPeers(node, arg, uint16(*announce))
+	case "ping":
+		out, err = ping(node, arg)
+	case "sample":
+		out, err = sample(node, arg)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Println("error:", err.Error())
+		node.Close()
+		os.Exit(1)
+	}
+	b, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(b))
+}
+
+// 加入 DHT 网络并查找拥有 infohash 的 Peer
+func findPeers(node *dht.Node, infohash string, port uint16) (*peersJSON, error) {
+	b, err := hex.DecodeString(infohash)
+	if err != nil || len(b) != dht.ID_LEN {
+		return nil, fmt.Errorf("invalid info hash %q", infohash)
+	}
+	var hash [dht.ID_LEN]byte
+	copy(hash[:], b)
+
+	start := time.Now()
+	if err = node.Bootstrap(); err != nil {
+		return nil, err
+	}
+	peers, err := node.FindPeers(hash, port)
+	if err != nil {
+		return nil, err
+	}
+	out := &peersJSON{
+		InfoHash: hex.EncodeToString(hash[:]),
+		Peers:    make([]string, 0, len(peers)),
+		Nodes:    node.NumNodes(),
+		Elapsed:  time.Since(start).String(),
+	}
+	for _, p := range peers {
+		out.Peers = append(out.Peers, p.GetConnAddr())
+	}
+	return out, nil
+}
+
+// 向 addr 发送 ping
+func ping(node *dht.Node, addr string) (*pingJSON, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	id, err := node.Ping(udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &pingJSON{Addr: udpAddr.String(), ID: id.String(), RTT: time.Since(start).String()}, nil
+}
+
+// 向 addr 请求 info_hash 样本
+func sample(node *dht.Node, addr string) (*sampleJSON, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	res, err := node.SampleInfohashes(udpAddr, dht.RandomID())
+	if err != nil {
+		return nil, err
+	}
+	out := &sampleJSON{
+		Addr:     udpAddr.String(),
+		Interval: int(res.Interval / time.Second),
+		Num:      res.Num,
+		Samples:  make([]string, 0, len(res.Samples)),
+		Nodes:    make([]nodeJSON, 0, len(res.Nodes)),
+	}
+	for _, s := range res.Samples {
+		out.Samples = append(out.Samples, s.String())
+	}
+	for _, n := range res.Nodes {
+		out.Nodes = append(out.Nodes, nodeJSON{ID: n.ID.String(), Addr: n.Addr.String()})
+	}
+	return out, nil
+}
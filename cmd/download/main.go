@@ -1,14 +1,21 @@
 package main
 
 import (
-	"crypto/rand"
 	"flag"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Akimio521/torrent-go/dht"
+	"github.com/Akimio521/torrent-go/lsd"
+	"github.com/Akimio521/torrent-go/proxy"
 	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/Akimio521/torrent-go/utp"
 )
 
 const (
@@ -21,13 +28,46 @@ const (
 func main() {
 	filePath := flag.String("file", "", "Path to the torrent file")
 	port := flag.Uint("port", 6881, "Port to listen on")
+	enableDHT := flag.Bool("dht", true, "Find peers through the mainline DHT")
+	bootstrap := flag.String("bootstrap", strings.Join(dht.DEFAULT_BOOTSTRAP_NODES, ","), "Comma separated DHT bootstrap nodes")
+	dhtState := flag.String("dht-state", "", "File to persist the DHT node id and routing table")
+	enableLSD := flag.Bool("lsd", true, "Find peers on the local network (BEP 14)")
+	seed := flag.Bool("seed", false, "Keep seeding after the download completes")
+	slots := flag.Int("slots", torrent.DEFAULT_UPLOAD_SLOTS, "Number of upload slots")
+	encryption := flag.String("encryption", "prefer", "Connection encryption policy: disabled, prefer or require")
+	enableUTP := flag.Bool("utp", true, "Accept and make peer connections over uTP (BEP 29)")
+	preferUTP := flag.Bool("prefer-utp", false, "Try uTP before TCP when connecting to peers")
+	sequential := flag.Bool("sequential", false, "Download pieces in order instead of rarest first")
+	maxConns := flag.Int("conns", torrent.DEFAULT_TORRENT_CONNS, "Maximum number of peer connections")
+	maxHalfOpen := flag.Int("half-open", torrent.DEFAULT_MAX_HALF_OPEN, "Maximum number of concurrent connection attempts")
+	upLimit := flag.Int("up-limit", 0, "Global upload limit in KiB/s (0 for unlimited)")
+	downLimit := flag.Int("down-limit", 0, "Global download limit in KiB/s (0 for unlimited)")
+	peerIdPrefix := flag.String("peer-id-prefix", torrent.DEFAULT_PEER_ID_PREFIX, "Prefix of the generated peer ID")
+	proxyURL := flag.String("proxy", "", "Proxy for peer and tracker connections (socks5://[user:pass@]host:port or http://[user:pass@]host:port)")
+	proxyOnly := flag.Bool("proxy-only", false, "Refuse direct connections and incoming peers (disables uTP, DHT and LSD)")
 	flag.Parse()
 	if *filePath == "" {
 		fmt.Println("Error: Torrent file path is required.")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *proxyURL != "" {
+		u, err := url.Parse(*proxyURL)
+		if err != nil {
+			fmt.Println("parse proxy error:", err.Error())
+			os.Exit(1)
+		}
+		if torrent.DefaultConnManager.Proxy, err = proxy.FromURL(u); err != nil {
+			fmt.Println("proxy error:", err.Error())
+			os.Exit(1)
+		}
+	}
+	torrent.DefaultConnManager.ProxyOnly = *proxyOnly
+	if *proxyOnly { // 直接收发的 UDP 与连入的连接都不经过代理
+		*enableUTP, *enableDHT, *enableLSD = false, false, false
+	}
 	var tf *torrent.TorrentFile
+	var err error
 	{
 		file, err := os.Open(*filePath)
 		if err != nil {
@@ -43,14 +83,108 @@ func main() {
 
 	}
 
-	var peerId [torrent.PEER_ID_LEN]byte // 随机生成 Peer ID
-	_, _ = rand.Read(peerId[:])
+	peerId := torrent.NewPeerId(*peerIdPrefix) // Azureus 风格的 Peer ID
+
+	var sock *utp.Socket // uTP 与 DHT 共享同一个 UDP 端口
+	if *enableUTP || *enableDHT {
+		if sock, err = utp.Listen("udp", fmt.Sprintf(":%d", *port)); err != nil {
+			fmt.Println("listen udp error:", err.Error())
+		} else {
+			if !*enableUTP { // 只用于 DHT 时没有人 Accept
+				sock.RejectIncoming()
+			}
+			go sock.Serve()
+			defer sock.Close()
+		}
+	}
+
+	var finders []torrent.PeerFinder
+	if *enableDHT && sock != nil && !tf.IsPrivate() { // 私有种子只使用自身的 Tracker
+		node, err := startDHT(sock.PacketConn(), strings.Split(*bootstrap, ","), *dhtState)
+		if err != nil {
+			fmt.Println("start dht error:", err.Error())
+		} else {
+			defer node.Close()
+			finders = append(finders, node)
+		}
+	}
+
+	if *enableLSD && !tf.IsPrivate() { // 局域网中发现的 Peer 在任务开始后加入，Tracker 与 DHT 都没有 Peer 时也能下载
+		service, err := lsd.NewService(lsd.Config{Port: uint16(*port)})
+		if err != nil {
+			fmt.Println("start lsd error:", err.Error())
+		} else {
+			go service.Serve()
+			defer service.Close()
+			finders = append(finders, service)
+		}
+	}
 
-	task, err := tf.GetTask(peerId, uint16(*port))
+	task, err := tf.GetTask(peerId, uint16(*port), finders...)
 	if err != nil {
 		fmt.Println("get task error:", err.Error())
 		os.Exit(1)
 	}
+	// 下载的 Piece 直接写入文件，已有的数据校验后用于续传与做种
+	file, err := os.OpenFile(task.FileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("fail to open file: " + task.FileName)
+		os.Exit(1)
+	}
+	defer file.Close()
+	// 设置文件大小（预分配空间）
+	if err = file.Truncate(int64(task.FileLen)); err != nil {
+		fmt.Printf("fail to allocate disk space: %v\n", err)
+		os.Exit(1)
+	}
+	task.Storage = file
+	task.UploadSlots = *slots
+	task.MaxConns = *maxConns
+	torrent.DefaultConnManager.MaxHalfOpen = *maxHalfOpen
+	if *upLimit > 0 {
+		torrent.DefaultConnManager.UploadLimit = torrent.NewRateLimiter(*upLimit * 1024)
+	}
+	if *downLimit > 0 {
+		torrent.DefaultConnManager.DownloadLimit = torrent.NewRateLimiter(*downLimit * 1024)
+	}
+	if *sequential {
+		task.Strategy = torrent.Sequential{}
+	}
+	switch *encryption {
+	case "disabled":
+		task.Encryption = torrent.EncryptionDisabled
+	case "prefer":
+		task.Encryption = torrent.EncryptionPrefer
+	case "require":
+		task.Encryption = torrent.EncryptionRequire
+	default:
+		fmt.Println("unknown encryption policy:", *encryption)
+		os.Exit(1)
+	}
+	if num, err := task.CheckStorage(); err != nil {
+		fmt.Println("check file error:", err.Error())
+		os.Exit(1)
+	} else if num > 0 {
+		fmt.Printf("%d/%d pieces already downloaded\n", num, len(task.PieceSHA))
+	}
+	if ln, err := listenTCP(*port, *proxyOnly); err != nil { // 接受其他 Peer 的连接
+		fmt.Println("listen tcp error:", err.Error())
+	} else if ln != nil {
+		listener := torrent.NewListener(ln)
+		listener.Register(task)
+		go listener.Serve()
+		defer listener.Close()
+	}
+	if *enableUTP && sock != nil {
+		listener := torrent.NewListener(sock)
+		listener.Register(task)
+		go listener.Serve()
+		task.UTP = sock
+		if *preferUTP {
+			task.Transport = torrent.PreferUTP
+		}
+	}
+
 	speedTracker := NewSpeedTracker()
 	totalBytes := task.FileLen
 
@@ -89,12 +223,15 @@ func main() {
 					fmt.Print("\033[H\033[2J") // 清屏
 					fmt.Printf("\r[%-100s] %3d%% %8d/%d %s\n",
 						generateProgressBar(bytePercentage),
+						bytePercentage,
 						currentPieces,
-						totalBytes,
 						len(task.PieceSHA),
 						formatSpeed(speedKB),
 					)
 					fmt.Println(currentBytes)
+					if ctx.InEndgame() {
+						fmt.Printf("%sEndgame%s\n", colorYellow, colorReset)
+					}
 					for _, err := range errBuffer {
 						fmt.Println(err)
 					}
@@ -104,26 +241,25 @@ func main() {
 			}
 		}
 	}()
-	file, err := os.Create(task.FileName)
-	if err != nil {
-		fmt.Println("fail to create file: " + task.FileName)
-		os.Exit(1)
+	for range ctx.GetResult() { // Piece 已由任务写入文件
 	}
-	defer file.Close()
-
-	// 设置文件大小（预分配空间）
-	if err = file.Truncate(int64(task.FileLen)); err != nil {
-		fmt.Printf("fail to allocate disk space: %v\n", err)
-		os.Exit(1)
+	if *seed {
+		fmt.Println("Seeding, press Ctrl+C to exit")
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
 	}
-	for res := range ctx.GetResult() {
-		begin, _ := task.GetPieceBounds(res.Index)
-		// 直接将下载片段写入硬盘对应位置
-		if _, err := file.WriteAt(res.Data, int64(begin)); err != nil {
-			fmt.Printf("fail to write piece %d: %v\n", res.Index, err)
-			os.Exit(1)
-		}
+}
+
+// 在 UDP 连接上启动 DHT 节点并加入网络，stateFile 不为空时持久化路由表
+func startDHT(conn net.PacketConn, bootstrap []string, stateFile string) (*dht.Node, error) {
+	node := dht.NewNode(conn, dht.Config{BootstrapNodes: bootstrap, StateFile: stateFile})
+	go node.Serve()
+	if err := node.Bootstrap(); err != nil {
+		node.Close()
+		return nil, err
 	}
+	return node, nil
 }
 
 func generateProgressBar(p int) string {
@@ -192,3 +328,11 @@ func formatSpeed(speedKB float64) string {
 		return fmt.Sprintf("%s%.2f KB/s%s", colorRed, speedKB, colorReset)
 	}
 }
+
+// 监听 TCP 端口（只通过代理连接时不接受其他 Peer 的连接，返回 nil）
+func listenTCP(port uint, proxyOnly bool) (net.Listener, error) {
+	if proxyOnly {
+		return nil, nil
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", port))
+}
@@ -37,6 +37,7 @@ func main() {
 	fmt.Println(torrent.Announce)
 	fmt.Println(torrent.AnnounceList)
 	fmt.Println(torrent.Info.PiceLength)
+	fmt.Println("private:", torrent.IsPrivate())
 	if b, err := json.MarshalIndent(torrent.Info.Files, "", "  "); b != nil && err == nil {
 		fmt.Println(string(b))
 	}
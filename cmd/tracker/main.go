@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Akimio521/torrent-go/tracker/server"
+)
+
+func main() {
+	httpAddr := flag.String("http", ":6969", "Address to serve HTTP announce/scrape on (empty to disable)")
+	udpAddr := flag.String("udp", ":6969", "Address to serve UDP announce/scrape on (empty to disable)")
+	interval := flag.Duration("interval", server.DEFAULT_INTERVAL, "Announce interval sent to clients")
+	allowPath := flag.String("allow", "", "Path to a file of hex info hashes to allow (one per line)")
+	flag.Parse()
+
+	allowList, err := readAllowList(*allowPath)
+	if err != nil {
+		fmt.Println("read allow list error:", err.Error())
+		os.Exit(1)
+	}
+
+	if *httpAddr == "" && *udpAddr == "" {
+		fmt.Println("Error: at least one of -http and -udp is required.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// HTTP 与 UDP 共享同一个 Swarm 注册表（间隔在此统一限制，注册表的过期时间与告知客户端的间隔一致）
+	*interval = server.ClampInterval(*interval)
+	registry := server.NewRegistry(*interval, allowList)
+	cfg := server.Config{Registry: registry} // 间隔与白名单由注册表决定
+
+	go func() { // 定期清理过期的 Peer
+		for range time.Tick(*interval) {
+			registry.Expire()
+		}
+	}()
+
+	errChan := make(chan error, 2)
+	if *udpAddr != "" {
+		conn, err := net.ListenPacket("udp", *udpAddr)
+		if err != nil {
+			fmt.Println("listen udp error:", err.Error())
+			os.Exit(1)
+		}
+		s, err := server.NewUDPServer(conn, cfg)
+		if err != nil {
+			fmt.Println("create udp tracker error:", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("UDP tracker listening on %s\n", conn.LocalAddr())
+		go func() { errChan <- s.Serve() }()
+	}
+	if *httpAddr != "" {
+		tracker, err := server.NewTracker(cfg)
+		if err != nil {
+			fmt.Println("create http tracker error:", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("HTTP tracker listening on %s\n", *httpAddr)
+		go func() { errChan <- http.ListenAndServe(*httpAddr, tracker) }()
+	}
+	if err := <-errChan; err != nil {
+		fmt.Println("serve error:", err.Error())
+		os.Exit(1)
+	}
+}
+
+// 读取 info_hash 白名单，每行一个十六进制编码的哈希，# 开头的行为注释
+func readAllowList(path string) ([][sha1.Size]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var list [][sha1.Size]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil || len(b) != sha1.Size {
+			return nil, fmt.Errorf("invalid info hash %q", line)
+		}
+		var h [sha1.Size]byte
+		copy(h[:], b)
+		list = append(list, h)
+	}
+	return list, scanner.Err()
+}
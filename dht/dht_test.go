@@ -0,0 +1,171 @@
+package dht_test
+
+import (
+	"crypto/sha1"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/Akimio521/torrent-go/dht"
+	"github.com/stretchr/testify/require"
+)
+
+// 在回环地址上启动 num 个节点，除第一个节点外都以第一个节点为引导节点
+func startNodes(t *testing.T, num int) []*dht.Node {
+	nodes := make([]*dht.Node, 0, num)
+	for i := 0; i < num; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		cfg := dht.Config{}
+		if i > 0 {
+			cfg.BootstrapNodes = []string{nodes[0].Addr().String()}
+		}
+		node := dht.NewNode(conn, cfg)
+		go node.Serve()
+		t.Cleanup(func() { node.Close() })
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes[1:] {
+		require.NoError(t, node.Bootstrap())
+	}
+	return nodes
+}
+
+func TestKRPCRoundTrip(t *testing.T) {
+	id := dht.RandomID()
+	msg := &dht.Msg{
+		T: "aa",
+		Y: dht.Y_QUERY,
+		Q: dht.Q_ANNOUNCE_PEER,
+		A: &dht.MsgArgs{ID: id, InfoHash: dht.RandomID(), Port: 6881, Token: "token"},
+	}
+	b, err := msg.Encode()
+	require.NoError(t, err)
+	decoded, err := dht.DecodeMsg(b)
+	require.NoError(t, err)
+	require.Equal(t, msg, decoded)
+
+	b, err = (&dht.Msg{T: "bb", Y: dht.Y_ERROR, E: &dht.KRPCError{Code: dht.ERR_PROTOCOL, Msg: "bad token"}}).Encode()
+	require.NoError(t, err)
+	require.Equal(t, "d1:eli203e9:bad tokene1:t2:bb1:y1:ee", string(b))
+
+	_, err = dht.DecodeMsg([]byte("d1:t2:aa1:y1:q1:q4:ping1:ad2:id3:abcee"))
+	require.ErrorIs(t, err, dht.ErrInvalidNodeID)
+	_, err = dht.DecodeMsg([]byte("d1:t2:aa1:y1:q"))
+	require.Error(t, err)
+}
+
+func TestPrefixLen(t *testing.T) {
+	var a, b dht.NodeID
+	require.Equal(t, dht.ID_BITS, a.PrefixLen(b))
+	b[0] = 0x10
+	require.Equal(t, 3, a.PrefixLen(b))
+	b[0], b[2] = 0, 0x01
+	require.Equal(t, 23, a.PrefixLen(b))
+}
+
+func TestPingAndFindNode(t *testing.T) {
+	nodes := startNodes(t, 3)
+	addr := nodes[1].Addr().(*net.UDPAddr)
+
+	id, err := nodes[0].Ping(addr)
+	require.NoError(t, err)
+	require.Equal(t, nodes[1].ID(), id)
+
+	found := nodes[2].Lookup(nodes[1].ID())
+	require.NotEmpty(t, found)
+	require.Equal(t, nodes[1].ID(), found[0].ID)
+}
+
+func TestAnnounceAndGetPeers(t *testing.T) {
+	nodes := startNodes(t, 8)
+	infoHash := sha1.Sum([]byte("torrent-go"))
+
+	peers, err := nodes[3].FindPeers(infoHash, 7000)
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	peers, err = nodes[7].FindPeers(infoHash, 0)
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, "127.0.0.1:7000", peers[0].GetConnAddr())
+}
+
+func TestAnnounceBadToken(t *testing.T) {
+	nodes := startNodes(t, 2)
+	err := nodes[1].AnnouncePeer(nodes[0].Addr().(*net.UDPAddr), dht.RandomID(), 7000, "bogus")
+	var krpcErr *dht.KRPCError
+	require.ErrorAs(t, err, &krpcErr)
+	require.Equal(t, dht.ERR_PROTOCOL, krpcErr.Code)
+}
+
+func TestSecureID(t *testing.T) {
+	vectors := []struct {
+		ip     string
+		prefix []byte
+		r      byte
+	}{
+		{"124.31.75.21", []byte{0x5f, 0xbf, 0xbf}, 0x01},
+		{"21.75.31.124", []byte{0x5a, 0x3c, 0xe9}, 0x56},
+		{"65.23.51.170", []byte{0xa5, 0xd4, 0x32}, 0x16},
+		{"84.124.73.14", []byte{0x1b, 0x03, 0x21}, 0x41},
+		{"43.213.53.83", []byte{0xe5, 0x6f, 0x6c}, 0x5a},
+	}
+	for _, v := range vectors {
+		ip := net.ParseIP(v.ip)
+		id := dht.RandomID()
+		copy(id[:], v.prefix)
+		id[dht.ID_LEN-1] = v.r
+		require.True(t, dht.IsSecureID(id, ip), v.ip)
+
+		id[0] ^= 0xff
+		require.False(t, dht.IsSecureID(id, ip), v.ip)
+
+		require.True(t, dht.IsSecureID(dht.GenerateSecureID(ip), ip), v.ip)
+	}
+	require.True(t, dht.IsSecureID(dht.RandomID(), net.ParseIP("192.168.1.1")))
+	require.True(t, dht.IsSecureID(dht.GenerateSecureID(net.ParseIP("2001:db8::1")), net.ParseIP("2001:db8::1")))
+}
+
+func TestStatePersistence(t *testing.T) {
+	nodes := startNodes(t, 4)
+	stateFile := filepath.Join(t.TempDir(), "dht.dat")
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	node := dht.NewNode(conn, dht.Config{
+		BootstrapNodes: []string{nodes[0].Addr().String()},
+		StateFile:      stateFile,
+	})
+	go node.Serve()
+	require.NoError(t, node.Bootstrap())
+	id := node.ID()
+	require.NoError(t, node.Close())
+
+	// 重启后沿用原来的节点 ID，且无需引导节点即可通过保存的节点加入网络
+	conn, err = net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	node = dht.NewNode(conn, dht.Config{StateFile: stateFile})
+	go node.Serve()
+	t.Cleanup(func() { node.Close() })
+	require.Equal(t, id, node.ID())
+	require.NoError(t, node.Bootstrap())
+	require.Equal(t, len(nodes), node.NumNodes())
+}
+
+func TestSampleInfohashes(t *testing.T) {
+	nodes := startNodes(t, 2)
+	infoHash := sha1.Sum([]byte("torrent-go"))
+	_, err := nodes[1].FindPeers(infoHash, 7000)
+	require.NoError(t, err)
+
+	res, err := nodes[1].SampleInfohashes(nodes[0].Addr().(*net.UDPAddr), dht.RandomID())
+	require.NoError(t, err)
+	require.Equal(t, dht.SAMPLE_INTERVAL, res.Interval)
+	require.Equal(t, 1, res.Num)
+	require.Equal(t, []dht.NodeID{dht.NodeID(infoHash)}, res.Samples)
+	require.NotEmpty(t, res.Nodes)
+
+	_, err = dht.DecodeMsg([]byte("d1:rd2:id20:aaaaaaaaaaaaaaaaaaaa8:intervali60e3:numi1e7:samples3:abce1:t2:aa1:y1:re"))
+	require.ErrorIs(t, err, dht.ErrMalformedMsg)
+}
@@ -0,0 +1,94 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"net"
+)
+
+type NodeID [ID_LEN]byte // 节点 ID（与种子的 info_hash 位于同一空间）
+
+// 生成随机的节点 ID
+func RandomID() NodeID {
+	var id NodeID
+	rand.Read(id[:])
+	return id
+}
+
+// 十六进制表示
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// 与 other 的异或距离
+func (id NodeID) Distance(other NodeID) NodeID {
+	var d NodeID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// 与 other 的公共前缀位数（完全相同时为 ID_BITS）
+func (id NodeID) PrefixLen(other NodeID) int {
+	for i := range id {
+		if x := id[i] ^ other[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return ID_BITS
+}
+
+// 判断 a 是否比 b 更接近 target
+func closer(target, a, b NodeID) bool {
+	da, db := target.Distance(a), target.Distance(b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+type NodeInfo struct { // DHT 节点信息
+	ID   NodeID       // 节点 ID
+	Addr *net.UDPAddr // 节点地址
+}
+
+// 将节点列表编码为紧凑格式，分别返回 IPv4 与 IPv6 的节点字符串
+func encodeNodes(nodes []NodeInfo) (string, string) {
+	nodes4 := make([]byte, 0, len(nodes)*NODE_INFO_LEN)
+	nodes6 := make([]byte, 0)
+	for _, n := range nodes {
+		if ip4 := n.Addr.IP.To4(); ip4 != nil {
+			nodes4 = append(nodes4, n.ID[:]...)
+			nodes4 = append(nodes4, ip4...)
+			nodes4 = binary.BigEndian.AppendUint16(nodes4, uint16(n.Addr.Port))
+		} else {
+			nodes6 = append(nodes6, n.ID[:]...)
+			nodes6 = append(nodes6, n.Addr.IP.To16()...)
+			nodes6 = binary.BigEndian.AppendUint16(nodes6, uint16(n.Addr.Port))
+		}
+	}
+	return string(nodes4), string(nodes6)
+}
+
+// 解析紧凑格式的节点信息，ipLen 为 net.IPv4len 或 net.IPv6len
+func decodeNodes(s string, ipLen int) ([]NodeInfo, error) {
+	size := ID_LEN + ipLen + 2
+	if len(s)%size != 0 {
+		return nil, ErrMalformedMsg
+	}
+	nodes := make([]NodeInfo, 0, len(s)/size)
+	for i := 0; i < len(s); i += size {
+		var n NodeInfo
+		copy(n.ID[:], s[i:i+ID_LEN])
+		ip := make(net.IP, ipLen)
+		copy(ip, s[i+ID_LEN:i+ID_LEN+ipLen])
+		port := binary.BigEndian.Uint16([]byte(s[i+ID_LEN+ipLen : i+size]))
+		if port == 0 { // 端口为 0 的节点无法联系
+			continue
+		}
+		n.Addr = &net.UDPAddr{IP: ip, Port: int(port)}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
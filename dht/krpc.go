@@ -0,0 +1,322 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+
+	"github.com/Akimio521/torrent-go/bencode"
+)
+
+type Msg struct { // KRPC 消息
+	T  string       // 事务 ID
+	Y  string       // 消息类型（q/r/e）
+	Q  string       // 查询方法（仅查询消息）
+	A  *MsgArgs     // 查询参数（仅查询消息）
+	R  *MsgReturn   // 响应内容（仅响应消息）
+	E  *KRPCError   // 错误内容（仅错误消息）
+	IP *net.UDPAddr // 响应者看到的请求者地址（BEP 42）
+}
+
+type MsgArgs struct { // 查询参数
+	ID          NodeID // 查询者的节点 ID
+	Target      NodeID // find_node 的目标 ID
+	InfoHash    NodeID // get_peers/announce_peer 的 info_hash
+	Port        int    // announce_peer 的端口
+	ImpliedPort bool   // announce_peer 是否使用来源端口
+	Token       string // announce_peer 的 token
+}
+
+type MsgReturn struct { // 响应内容
+	ID     NodeID   // 响应者的节点 ID
+	Nodes  string   // 紧凑格式的 IPv4 节点信息
+	Nodes6 string   // 紧凑格式的 IPv6 节点信息（BEP 32）
+	Values []string // 紧凑格式的 Peer 信息
+	Token  string   // get_peers 返回的 token
+
+	Interval int    // sample_infohashes：建议的再次查询间隔（秒）
+	Num      int    // sample_infohashes：对方保存的 info_hash 总数
+	Samples  string // sample_infohashes：拼接在一起的 info_hash 样本
+}
+
+type KRPCError struct { // KRPC 错误
+	Code int    // 错误码
+	Msg  string // 错误信息
+}
+
+func (e *KRPCError) Error() string {
+	return "krpc error " + strconv.Itoa(e.Code) + ": " + e.Msg
+}
+
+// 编码消息
+func (m *Msg) Encode() ([]byte, error) {
+	dict := map[string]*bencode.BObject{
+		"t": bencode.GetBObject(m.T),
+		"y": bencode.GetBObject(m.Y),
+	}
+	switch m.Y {
+	case Y_QUERY:
+		if m.A == nil {
+			return nil, ErrMalformedMsg
+		}
+		dict["q"] = bencode.GetBObject(m.Q)
+		dict["a"] = m.A.bobject(m.Q)
+	case Y_RESPONSE:
+		if m.R == nil {
+			return nil, ErrMalformedMsg
+		}
+		dict["r"] = m.R.bobject()
+	case Y_ERROR:
+		if m.E == nil {
+			return nil, ErrMalformedMsg
+		}
+		dict["e"] = bencode.GetBObject([]*bencode.BObject{
+			bencode.GetBObject(m.E.Code),
+			bencode.GetBObject(m.E.Msg),
+		})
+	default:
+		return nil, ErrMalformedMsg
+	}
+	if m.IP != nil {
+		dict["ip"] = bencode.GetBObject(encodeAddr(m.IP))
+	}
+	buf := new(bytes.Buffer)
+	if _, err := bencode.GetBObject(dict).Bencode(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 根据查询方法编码需要的参数
+func (a *MsgArgs) bobject(q string) *bencode.BObject {
+	dict := map[string]*bencode.BObject{
+		"id": bencode.GetBObject(string(a.ID[:])),
+	}
+	switch q {
+	case Q_FIND_NODE, Q_SAMPLE_INFOHASHES:
+		dict["target"] = bencode.GetBObject(string(a.Target[:]))
+	case Q_GET_PEERS:
+		dict["info_hash"] = bencode.GetBObject(string(a.InfoHash[:]))
+	case Q_ANNOUNCE_PEER:
+		dict["info_hash"] = bencode.GetBObject(string(a.InfoHash[:]))
+		dict["port"] = bencode.GetBObject(a.Port)
+		dict["token"] = bencode.GetBObject(a.Token)
+		if a.ImpliedPort {
+			dict["implied_port"] = bencode.GetBObject(1)
+		}
+	}
+	return bencode.GetBObject(dict)
+}
+
+// 编码非空的响应字段
+func (r *MsgReturn) bobject() *bencode.BObject {
+	dict := map[string]*bencode.BObject{
+		"id": bencode.GetBObject(string(r.ID[:])),
+	}
+	if r.Nodes != "" {
+		dict["nodes"] = bencode.GetBObject(r.Nodes)
+	}
+	if r.Nodes6 != "" {
+		dict["nodes6"] = bencode.GetBObject(r.Nodes6)
+	}
+	if r.Token != "" {
+		dict["token"] = bencode.GetBObject(r.Token)
+	}
+	if len(r.Values) > 0 {
+		values := make([]*bencode.BObject, 0, len(r.Values))
+		for _, v := range r.Values {
+			values = append(values, bencode.GetBObject(v))
+		}
+		dict["values"] = bencode.GetBObject(values)
+	}
+	if r.Interval > 0 || r.Num > 0 || r.Samples != "" {
+		dict["interval"] = bencode.GetBObject(r.Interval)
+		dict["num"] = bencode.GetBObject(r.Num)
+		dict["samples"] = bencode.GetBObject(r.Samples)
+	}
+	return bencode.GetBObject(dict)
+}
+
+// 解码消息
+func DecodeMsg(b []byte) (*Msg, error) {
+	obj, err := bencode.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	var dict map[string]*bencode.BObject
+	if err = bencode.GetValue(obj, &dict); err != nil {
+		return nil, ErrMalformedMsg
+	}
+	m := new(Msg)
+	var ok bool
+	if m.T, ok = dictString(dict, "t"); !ok {
+		return nil, ErrMalformedMsg
+	}
+	if m.Y, ok = dictString(dict, "y"); !ok {
+		return nil, ErrMalformedMsg
+	}
+
+	switch m.Y {
+	case Y_QUERY:
+		if m.Q, ok = dictString(dict, "q"); !ok {
+			return nil, ErrMalformedMsg
+		}
+		a, ok := dictDict(dict, "a")
+		if !ok {
+			return nil, ErrMalformedMsg
+		}
+		if m.A, err = decodeArgs(m.Q, a); err != nil {
+			return nil, err
+		}
+	case Y_RESPONSE:
+		r, ok := dictDict(dict, "r")
+		if !ok {
+			return nil, ErrMalformedMsg
+		}
+		if m.R, err = decodeReturn(r); err != nil {
+			return nil, err
+		}
+	case Y_ERROR:
+		var list []*bencode.BObject
+		if e, ok := dict["e"]; !ok || bencode.GetValue(e, &list) != nil || len(list) < 2 {
+			return nil, ErrMalformedMsg
+		}
+		m.E = new(KRPCError)
+		if bencode.GetValue(list[0], &m.E.Code) != nil || bencode.GetValue(list[1], &m.E.Msg) != nil {
+			return nil, ErrMalformedMsg
+		}
+	default:
+		return nil, ErrMalformedMsg
+	}
+	if ip, ok := dictString(dict, "ip"); ok {
+		m.IP = decodeAddr(ip)
+	}
+	return m, nil
+}
+
+// 将 UDP 地址编码为紧凑格式（IP + 端口）
+func encodeAddr(addr *net.UDPAddr) string {
+	b := addr.IP.To4()
+	if b == nil {
+		b = addr.IP.To16()
+	}
+	return string(binary.BigEndian.AppendUint16(append([]byte{}, b...), uint16(addr.Port)))
+}
+
+// 解析紧凑格式的 UDP 地址，格式错误时返回 nil
+func decodeAddr(s string) *net.UDPAddr {
+	if len(s) != net.IPv4len+2 && len(s) != net.IPv6len+2 {
+		return nil
+	}
+	ip := make(net.IP, len(s)-2)
+	copy(ip, s)
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16([]byte(s[len(s)-2:])))}
+}
+
+// 解码查询参数，并校验对应方法必需的字段
+func decodeArgs(q string, dict map[string]*bencode.BObject) (*MsgArgs, error) {
+	a := new(MsgArgs)
+	if !dictID(dict, "id", &a.ID) {
+		return nil, ErrInvalidNodeID
+	}
+	switch q {
+	case Q_FIND_NODE, Q_SAMPLE_INFOHASHES:
+		if !dictID(dict, "target", &a.Target) {
+			return nil, ErrMalformedMsg
+		}
+	case Q_GET_PEERS:
+		if !dictID(dict, "info_hash", &a.InfoHash) {
+			return nil, ErrMalformedMsg
+		}
+	case Q_ANNOUNCE_PEER:
+		if !dictID(dict, "info_hash", &a.InfoHash) {
+			return nil, ErrMalformedMsg
+		}
+		var ok bool
+		if a.Token, ok = dictString(dict, "token"); !ok {
+			return nil, ErrMalformedMsg
+		}
+		implied, _ := dictInt(dict, "implied_port")
+		a.ImpliedPort = implied != 0
+		if a.Port, ok = dictInt(dict, "port"); !ok && !a.ImpliedPort {
+			return nil, ErrMalformedMsg
+		}
+	}
+	return a, nil
+}
+
+// 解码响应内容
+func decodeReturn(dict map[string]*bencode.BObject) (*MsgReturn, error) {
+	r := new(MsgReturn)
+	if !dictID(dict, "id", &r.ID) {
+		return nil, ErrInvalidNodeID
+	}
+	r.Nodes, _ = dictString(dict, "nodes")
+	r.Nodes6, _ = dictString(dict, "nodes6")
+	r.Token, _ = dictString(dict, "token")
+	r.Interval, _ = dictInt(dict, "interval")
+	r.Num, _ = dictInt(dict, "num")
+	if r.Samples, _ = dictString(dict, "samples"); len(r.Samples)%ID_LEN != 0 {
+		return nil, ErrMalformedMsg
+	}
+	var list []*bencode.BObject
+	if v, ok := dict["values"]; ok && bencode.GetValue(v, &list) == nil {
+		for _, o := range list {
+			var s string
+			if bencode.GetValue(o, &s) == nil {
+				r.Values = append(r.Values, s)
+			}
+		}
+	}
+	return r, nil
+}
+
+// 读取字典中的字符串
+func dictString(dict map[string]*bencode.BObject, key string) (string, bool) {
+	o, ok := dict[key]
+	if !ok {
+		return "", false
+	}
+	var s string
+	if err := bencode.GetValue(o, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// 读取字典中的整数
+func dictInt(dict map[string]*bencode.BObject, key string) (int, bool) {
+	o, ok := dict[key]
+	if !ok {
+		return 0, false
+	}
+	var i int
+	if err := bencode.GetValue(o, &i); err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// 读取字典中的字典
+func dictDict(dict map[string]*bencode.BObject, key string) (map[string]*bencode.BObject, bool) {
+	o, ok := dict[key]
+	if !ok {
+		return nil, false
+	}
+	var d map[string]*bencode.BObject
+	if err := bencode.GetValue(o, &d); err != nil {
+		return nil, false
+	}
+	return d, true
+}
+
+// 读取字典中长度为 ID_LEN 的字符串
+func dictID(dict map[string]*bencode.BObject, key string, id *NodeID) bool {
+	s, ok := dictString(dict, key)
+	if !ok || len(s) != ID_LEN {
+		return false
+	}
+	copy(id[:], s)
+	return true
+}
@@ -0,0 +1,175 @@
+package dht
+
+import (
+	"crypto/sha1"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type lookupResult struct { // 迭代查找的结果
+	nodes  []NodeInfo         // 响应过的最近节点（按距离排序）
+	tokens map[string]string  // 节点地址 -> get_peers 返回的 token
+	peers  []torrent.PeerInfo // get_peers 查找到的 Peer（已去重）
+}
+
+type candidate struct { // 迭代查找中的候选节点
+	NodeInfo
+	queried   bool
+	responded bool
+}
+
+// 向距离 target 越来越近的节点迭代发送 find_node（或 get_peers），直到最近的 K 个节点都已查询；
+// 除路由表外还会从 seeds 开始查找
+func (n *Node) lookup(target NodeID, getPeers bool, seeds ...NodeInfo) *lookupResult {
+	res := &lookupResult{tokens: make(map[string]string)}
+	self := n.ID()
+	seen := make(map[NodeID]*candidate)
+	var shortlist []*candidate
+	add := func(nodes []NodeInfo) {
+		for _, ni := range nodes {
+			if ni.ID == self {
+				continue
+			}
+			if _, ok := seen[ni.ID]; ok {
+				continue
+			}
+			c := &candidate{NodeInfo: ni}
+			seen[ni.ID] = c
+			shortlist = append(shortlist, c)
+		}
+		sort.Slice(shortlist, func(i, j int) bool { return closer(target, shortlist[i].ID, shortlist[j].ID) })
+	}
+	add(n.rt().closest(target, K))
+	add(seeds)
+
+	peerSeen := make(map[string]struct{})
+	var mu sync.Mutex
+	for {
+		// 选出最近的 K 个节点中尚未查询的节点
+		var batch []*candidate
+		for i := 0; i < len(shortlist) && i < K && len(batch) < ALPHA; i++ {
+			if !shortlist[i].queried {
+				shortlist[i].queried = true
+				batch = append(batch, shortlist[i])
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		var found []NodeInfo
+		for _, c := range batch {
+			wg.Add(1)
+			go func(c *candidate) {
+				defer wg.Done()
+				var nodes []NodeInfo
+				if getPeers {
+					r, err := n.GetPeers(c.Addr, target)
+					if err != nil {
+						n.rt().failed(c.ID)
+						return
+					}
+					nodes = r.Nodes
+					mu.Lock()
+					res.tokens[c.Addr.String()] = r.Token
+					for _, p := range r.Peers {
+						if _, ok := peerSeen[p.GetConnAddr()]; !ok {
+							peerSeen[p.GetConnAddr()] = struct{}{}
+							res.peers = append(res.peers, p)
+						}
+					}
+					mu.Unlock()
+				} else {
+					var err error
+					if nodes, err = n.FindNode(c.Addr, target); err != nil {
+						n.rt().failed(c.ID)
+						return
+					}
+				}
+				mu.Lock()
+				c.responded = true
+				found = append(found, nodes...)
+				mu.Unlock()
+			}(c)
+		}
+		wg.Wait()
+		add(found)
+	}
+
+	for _, c := range shortlist {
+		if c.responded {
+			res.nodes = append(res.nodes, c.NodeInfo)
+			if len(res.nodes) == K {
+				break
+			}
+		}
+	}
+	return res
+}
+
+// 迭代查找距离 target 最近的节点
+func (n *Node) Lookup(target NodeID) []NodeInfo {
+	return n.lookup(target, false).nodes
+}
+
+// 迭代查找拥有 infoSHA 的 Peer，并向最近的节点宣告本机正在通过 port 下载
+func (n *Node) FindPeers(infoSHA [sha1.Size]byte, port uint16) ([]torrent.PeerInfo, error) {
+	if n.rt().size() == 0 {
+		return nil, ErrNoNodes
+	}
+	res := n.lookup(NodeID(infoSHA), true)
+	if port != 0 {
+		var wg sync.WaitGroup
+		for _, ni := range res.nodes {
+			token, ok := res.tokens[ni.Addr.String()]
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			go func(addr *net.UDPAddr, token string) {
+				defer wg.Done()
+				n.AnnouncePeer(addr, NodeID(infoSHA), port, token)
+			}(ni.Addr, token)
+		}
+		wg.Wait()
+	}
+	return res.peers, nil
+}
+
+// 通过引导节点（以及上次保存的节点）加入 DHT 网络：向引导节点查询自身附近的节点，再做一次迭代查找
+func (n *Node) Bootstrap() error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var seeds []NodeInfo
+	for _, host := range n.cfg.BootstrapNodes {
+		addr, err := net.ResolveUDPAddr("udp", host)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(addr *net.UDPAddr) {
+			defer wg.Done()
+			nodes, err := n.FindNode(addr, n.ID())
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			seeds = append(seeds, nodes...)
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	// 引导节点返回的节点与上次保存的节点都未经验证，只作为查找的起点，响应后才会加入路由表
+	seeds = append(seeds, n.saved...)
+	n.lookup(n.ID(), false, seeds...)
+	if n.rt().size() == 0 {
+		return ErrBootstrapFaild
+	}
+	return nil
+}
+
+var _ torrent.PeerFinder = (*Node)(nil)
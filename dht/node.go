@@ -0,0 +1,392 @@
+package dht
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type Config struct { // DHT 节点配置
+	ID             NodeID   // 节点 ID（为零值时优先使用持久化的 ID，否则根据外部 IP 生成）
+	BootstrapNodes []string // 引导节点地址（host:port）
+	StateFile      string   // 持久化节点 ID 与路由表的文件路径（为空时不持久化）
+	ExternalIP     net.IP   // 本机外部 IP（为空时从其他节点的响应中得知）
+}
+
+type Node struct { // Mainline DHT（BEP 5）节点
+	conn   net.PacketConn
+	cfg    Config
+	table  atomic.Pointer[routingTable] // 路由表（节点 ID 变化时整体替换）
+	peers  *peerStore
+	tokens *tokenManager
+	saved  []NodeInfo // 从持久化文件恢复的节点（启动时重新验证）
+
+	ipMu       sync.Mutex
+	externalIP net.IP                         // 本机外部 IP
+	votes      map[string]map[string]struct{} // 外部 IP -> 报告该 IP 的节点
+
+	mu      sync.Mutex
+	nextTx  uint16                  // 下一个事务 ID
+	pending map[string]*transaction // 等待响应的查询
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type transaction struct { // 等待响应的查询
+	addr *net.UDPAddr
+	resp chan *Msg
+}
+
+// 在 conn 上新建 DHT 节点，需要调用 Serve 开始处理消息
+func NewNode(conn net.PacketConn, cfg Config) *Node {
+	n := &Node{
+		conn:       conn,
+		cfg:        cfg,
+		peers:      newPeerStore(),
+		tokens:     newTokenManager(),
+		externalIP: cfg.ExternalIP,
+		votes:      make(map[string]map[string]struct{}),
+		pending:    make(map[string]*transaction),
+		closed:     make(chan struct{}),
+	}
+	id := cfg.ID
+	if cfg.StateFile != "" {
+		if state, err := loadState(cfg.StateFile); err == nil {
+			if n.externalIP == nil {
+				n.externalIP = state.externalIP
+			}
+			if id == (NodeID{}) && (n.externalIP == nil || IsSecureID(state.id, n.externalIP)) {
+				id = state.id
+			}
+			n.saved = state.nodes
+		}
+	}
+	if id == (NodeID{}) {
+		if n.externalIP != nil {
+			id = GenerateSecureID(n.externalIP)
+		} else {
+			id = RandomID()
+		}
+	}
+	n.table.Store(newRoutingTable(id))
+	return n
+}
+
+// 节点 ID
+func (n *Node) ID() NodeID {
+	return n.rt().self
+}
+
+// 当前的路由表
+func (n *Node) rt() *routingTable {
+	return n.table.Load()
+}
+
+// 本机外部 IP（尚未得知时为 nil）
+func (n *Node) ExternalIP() net.IP {
+	n.ipMu.Lock()
+	defer n.ipMu.Unlock()
+	return n.externalIP
+}
+
+// 节点监听地址
+func (n *Node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// 路由表中的节点数量
+func (n *Node) NumNodes() int {
+	return n.rt().size()
+}
+
+// 关闭节点（设置了 StateFile 时会保存路由表）
+func (n *Node) Close() error {
+	n.once.Do(func() {
+		close(n.closed)
+		if n.cfg.StateFile != "" {
+			n.Save(n.cfg.StateFile)
+		}
+	})
+	return n.conn.Close()
+}
+
+// 处理收到的消息并定期刷新 K 桶，直到节点被关闭
+func (n *Node) Serve() error {
+	go n.refreshRoutine()
+	buf := make([]byte, MAX_PACKET_SIZE)
+	for {
+		size, addr, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-n.closed:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		msg, err := DecodeMsg(buf[:size])
+		if err != nil {
+			continue
+		}
+		switch msg.Y {
+		case Y_QUERY:
+			n.handleQuery(msg, udpAddr)
+		case Y_RESPONSE, Y_ERROR:
+			n.handleResponse(msg, udpAddr)
+		}
+	}
+}
+
+// 定期对长时间没有变化的 K 桶做一次随机查找，并保存路由表
+func (n *Node) refreshRoutine() {
+	ticker := time.NewTicker(REFRESH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.closed:
+			return
+		case now := <-ticker.C:
+			rt := n.rt()
+			for _, i := range rt.staleBuckets(now, REFRESH_INTERVAL) {
+				n.lookup(rt.randomIDInBucket(i), false)
+			}
+			if n.cfg.StateFile != "" {
+				n.Save(n.cfg.StateFile)
+			}
+		}
+	}
+}
+
+// 发送消息
+func (n *Node) send(msg *Msg, addr *net.UDPAddr) error {
+	b, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteTo(b, addr)
+	return err
+}
+
+// 向 addr 发送查询并等待响应，响应者会被加入路由表
+func (n *Node) query(addr *net.UDPAddr, q string, args *MsgArgs) (*Msg, error) {
+	args.ID = n.ID()
+	n.mu.Lock()
+	n.nextTx++
+	tx := make([]byte, 2)
+	binary.BigEndian.PutUint16(tx, n.nextTx)
+	t := &transaction{addr: addr, resp: make(chan *Msg, 1)}
+	n.pending[string(tx)] = t
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, string(tx))
+		n.mu.Unlock()
+	}()
+
+	if err := n.send(&Msg{T: string(tx), Y: Y_QUERY, Q: q, A: args}, addr); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(QUERY_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case msg := <-t.resp:
+		if msg.Y == Y_ERROR {
+			return nil, msg.E
+		}
+		n.rt().insert(NodeInfo{ID: msg.R.ID, Addr: addr}, time.Now())
+		if msg.IP != nil {
+			n.voteExternalIP(msg.IP.IP, addr.IP)
+		}
+		return msg, nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-n.closed:
+		return nil, ErrClosed
+	}
+}
+
+// 将响应交给等待中的查询
+func (n *Node) handleResponse(msg *Msg, addr *net.UDPAddr) {
+	n.mu.Lock()
+	t, ok := n.pending[msg.T]
+	n.mu.Unlock()
+	if !ok || !t.addr.IP.Equal(addr.IP) || t.addr.Port != addr.Port {
+		return
+	}
+	select {
+	case t.resp <- msg:
+	default:
+	}
+}
+
+// 处理收到的查询
+func (n *Node) handleQuery(msg *Msg, addr *net.UDPAddr) {
+	now := time.Now()
+	n.rt().insert(NodeInfo{ID: msg.A.ID, Addr: addr}, now)
+
+	r := &MsgReturn{ID: n.ID()}
+	switch msg.Q {
+	case Q_PING:
+	case Q_FIND_NODE:
+		r.Nodes, r.Nodes6 = encodeNodes(n.rt().closest(msg.A.Target, K))
+	case Q_GET_PEERS:
+		r.Token = n.tokens.token(addr.IP, now)
+		if peers := n.peers.get(msg.A.InfoHash, now); len(peers) > 0 {
+			r.Values = encodeValues(peers)
+		} else {
+			r.Nodes, r.Nodes6 = encodeNodes(n.rt().closest(msg.A.InfoHash, K))
+		}
+	case Q_ANNOUNCE_PEER:
+		if !n.tokens.valid(msg.A.Token, addr.IP, now) {
+			n.sendError(msg.T, ERR_PROTOCOL, ErrBadToken.Error(), addr)
+			return
+		}
+		port := msg.A.Port
+		if msg.A.ImpliedPort {
+			port = addr.Port
+		}
+		if port <= 0 || port > 0xFFFF {
+			n.sendError(msg.T, ERR_PROTOCOL, "invalid port", addr)
+			return
+		}
+		n.peers.add(msg.A.InfoHash, torrent.PeerInfo{IP: addr.IP, Port: uint16(port)}, now)
+	case Q_SAMPLE_INFOHASHES:
+		r.Nodes, r.Nodes6 = encodeNodes(n.rt().closest(msg.A.Target, K))
+		samples, num := n.peers.sample(MAX_SAMPLES, now)
+		b := make([]byte, 0, len(samples)*ID_LEN)
+		for _, s := range samples {
+			b = append(b, s[:]...)
+		}
+		r.Interval, r.Num, r.Samples = int(SAMPLE_INTERVAL/time.Second), num, string(b)
+	default:
+		n.sendError(msg.T, ERR_METHOD, "method unknown", addr)
+		return
+	}
+	n.send(&Msg{T: msg.T, Y: Y_RESPONSE, R: r, IP: addr}, addr)
+}
+
+// 发送错误消息
+func (n *Node) sendError(tx string, code int, reason string, addr *net.UDPAddr) {
+	n.send(&Msg{T: tx, Y: Y_ERROR, E: &KRPCError{Code: code, Msg: reason}}, addr)
+}
+
+// 向 addr 发送 ping，返回对方的节点 ID
+func (n *Node) Ping(addr *net.UDPAddr) (NodeID, error) {
+	msg, err := n.query(addr, Q_PING, &MsgArgs{})
+	if err != nil {
+		return NodeID{}, err
+	}
+	return msg.R.ID, nil
+}
+
+// 向 addr 查询距离 target 最近的节点
+func (n *Node) FindNode(addr *net.UDPAddr, target NodeID) ([]NodeInfo, error) {
+	msg, err := n.query(addr, Q_FIND_NODE, &MsgArgs{Target: target})
+	if err != nil {
+		return nil, err
+	}
+	return msg.R.nodes(), nil
+}
+
+type GetPeersResult struct { // get_peers 的响应
+	Peers []torrent.PeerInfo // 对方已知的 Peer
+	Nodes []NodeInfo         // 更接近 info_hash 的节点
+	Token string             // 用于 announce_peer 的 token
+}
+
+// 向 addr 查询拥有 infoHash 的 Peer
+func (n *Node) GetPeers(addr *net.UDPAddr, infoHash NodeID) (*GetPeersResult, error) {
+	msg, err := n.query(addr, Q_GET_PEERS, &MsgArgs{InfoHash: infoHash})
+	if err != nil {
+		return nil, err
+	}
+	return &GetPeersResult{
+		Peers: decodeValues(msg.R.Values),
+		Nodes: msg.R.nodes(),
+		Token: msg.R.Token,
+	}, nil
+}
+
+// 向 addr 宣告本机正在通过 port 下载 infoHash，token 来自之前的 get_peers 响应
+func (n *Node) AnnouncePeer(addr *net.UDPAddr, infoHash NodeID, port uint16, token string) error {
+	_, err := n.query(addr, Q_ANNOUNCE_PEER, &MsgArgs{InfoHash: infoHash, Port: int(port), Token: token})
+	return err
+}
+
+type SampleResult struct { // sample_infohashes 的响应（BEP 51）
+	Interval time.Duration // 建议的再次查询间隔
+	Num      int           // 对方保存的 info_hash 总数
+	Samples  []NodeID      // info_hash 样本
+	Nodes    []NodeInfo    // 更接近 target 的节点
+}
+
+// 向 addr 查询其保存的 info_hash 样本，以及距离 target 最近的节点
+func (n *Node) SampleInfohashes(addr *net.UDPAddr, target NodeID) (*SampleResult, error) {
+	msg, err := n.query(addr, Q_SAMPLE_INFOHASHES, &MsgArgs{Target: target})
+	if err != nil {
+		return nil, err
+	}
+	res := &SampleResult{
+		Interval: time.Duration(msg.R.Interval) * time.Second,
+		Num:      msg.R.Num,
+		Samples:  make([]NodeID, 0, len(msg.R.Samples)/ID_LEN),
+		Nodes:    msg.R.nodes(),
+	}
+	for i := 0; i+ID_LEN <= len(msg.R.Samples); i += ID_LEN {
+		var id NodeID
+		copy(id[:], msg.R.Samples[i:i+ID_LEN])
+		res.Samples = append(res.Samples, id)
+	}
+	return res, nil
+}
+
+// 响应中的所有节点（忽略格式错误的部分）
+func (r *MsgReturn) nodes() []NodeInfo {
+	nodes, _ := decodeNodes(r.Nodes, net.IPv4len)
+	nodes6, _ := decodeNodes(r.Nodes6, net.IPv6len)
+	return append(nodes, nodes6...)
+}
+
+// 将 Peer 编码为 values 列表
+func encodeValues(peers []torrent.PeerInfo) []string {
+	values := make([]string, 0, len(peers))
+	for _, p := range peers {
+		v4, v6 := torrent.EncodePeerInfos([]torrent.PeerInfo{p})
+		values = append(values, v4+v6)
+	}
+	return values
+}
+
+// 解析 values 列表中的 Peer（IPv4 与 IPv6 混合）
+func decodeValues(values []string) []torrent.PeerInfo {
+	peers := make([]torrent.PeerInfo, 0, len(values))
+	for _, v := range values {
+		tr := new(torrent.TrackerResponse)
+		switch len(v) {
+		case torrent.PEER_V4_LEN:
+			tr.Peers = v
+		case torrent.PEER_V6_LEN:
+			tr.Peers6 = v
+		default:
+			continue
+		}
+		if ps, err := tr.ParsePeerInfos(); err == nil {
+			for i := range ps {
+				ps[i].Source = torrent.SourceDHT
+			}
+			peers = append(peers, ps...)
+		}
+	}
+	return peers
+}
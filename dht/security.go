@@ -0,0 +1,77 @@
+package dht
+
+import (
+	"hash/crc32"
+	"net"
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+	v4Mask     = []byte{0x03, 0x0f, 0x3f, 0xff}
+	v6Mask     = []byte{0x01, 0x03, 0x07, 0x0f, 0x1f, 0x3f, 0x7f, 0xff}
+	localNets  = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "127.0.0.0/8", "::1/128", "fc00::/7", "fe80::/10")
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// 是否为局域网/回环地址（BEP 42 对这些地址不做限制）
+func isLocalIP(ip net.IP) bool {
+	for _, n := range localNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 计算 IP 与随机数 r 对应的 CRC32-C 前缀（BEP 42）
+func secureCRC(ip net.IP, r byte) uint32 {
+	var masked []byte
+	if ip4 := ip.To4(); ip4 != nil {
+		masked = make([]byte, len(v4Mask))
+		for i := range v4Mask {
+			masked[i] = ip4[i] & v4Mask[i]
+		}
+	} else {
+		ip6 := ip.To16()
+		masked = make([]byte, len(v6Mask))
+		for i := range v6Mask {
+			masked[i] = ip6[i] & v6Mask[i]
+		}
+	}
+	masked[0] |= (r & 0x07) << 5
+	return crc32.Checksum(masked, castagnoli)
+}
+
+// 根据外部 IP 生成符合 BEP 42 的节点 ID：前 21 位由 IP 决定，最后一个字节为随机数 r
+func GenerateSecureID(ip net.IP) NodeID {
+	id := RandomID()
+	r := id[ID_LEN-1]
+	crc := secureCRC(ip, r)
+	id[0] = byte(crc >> 24)
+	id[1] = byte(crc >> 16)
+	id[2] = byte(crc>>8)&0xf8 | id[2]&0x07
+	return id
+}
+
+// 检查节点 ID 是否符合 BEP 42（局域网地址始终视为符合）
+func IsSecureID(id NodeID, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if isLocalIP(ip) {
+		return true
+	}
+	crc := secureCRC(ip, id[ID_LEN-1])
+	return id[0] == byte(crc>>24) && id[1] == byte(crc>>16) && id[2]&0xf8 == byte(crc>>8)&0xf8
+}
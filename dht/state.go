@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Akimio521/torrent-go/bencode"
+)
+
+type stateFile struct { // 持久化文件内容（字段按键名的字典序排列）
+	ID     string `bencode:"id"`     // 节点 ID
+	IP     string `bencode:"ip"`     // 已知的外部 IP
+	Nodes  string `bencode:"nodes"`  // 紧凑格式的 IPv4 节点信息
+	Nodes6 string `bencode:"nodes6"` // 紧凑格式的 IPv6 节点信息
+}
+
+type nodeState struct { // 从持久化文件中恢复的状态
+	id         NodeID
+	externalIP net.IP
+	nodes      []NodeInfo
+}
+
+// 读取持久化文件
+func loadState(path string) (*nodeState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sf := new(stateFile)
+	if err = bencode.Unmarshal(bytes.NewReader(b), sf); err != nil {
+		return nil, ErrInvalidState
+	}
+	if len(sf.ID) != ID_LEN {
+		return nil, ErrInvalidState
+	}
+	state := new(nodeState)
+	copy(state.id[:], sf.ID)
+	if len(sf.IP) == net.IPv4len || len(sf.IP) == net.IPv6len {
+		state.externalIP = net.IP(sf.IP)
+	}
+	nodes, _ := decodeNodes(sf.Nodes, net.IPv4len)
+	nodes6, _ := decodeNodes(sf.Nodes6, net.IPv6len)
+	state.nodes = append(nodes, nodes6...)
+	return state, nil
+}
+
+// 将节点 ID、外部 IP 与路由表中的节点保存到 path（先写临时文件再重命名）
+func (n *Node) Save(path string) error {
+	id := n.ID()
+	sf := &stateFile{ID: string(id[:])}
+	if ip := n.ExternalIP(); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			sf.IP = string(ip4)
+		} else {
+			sf.IP = string(ip.To16())
+		}
+	}
+	sf.Nodes, sf.Nodes6 = encodeNodes(n.rt().nodes())
+
+	buf := new(bytes.Buffer)
+	if _, err := bencode.Marshal(buf, sf); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// 记录 from 报告的本机外部 IP，得到足够多的一致报告后采纳；
+// 若当前节点 ID 不符合 BEP 42 且未在配置中指定，则根据新的外部 IP 重新生成
+func (n *Node) voteExternalIP(ip, from net.IP) {
+	if ip == nil || isLocalIP(ip) {
+		return
+	}
+	n.ipMu.Lock()
+	key := ip.String()
+	voters, ok := n.votes[key]
+	if !ok {
+		voters = make(map[string]struct{})
+		n.votes[key] = voters
+	}
+	voters[from.String()] = struct{}{}
+	if len(voters) < EXTERNAL_IP_VOTES || ip.Equal(n.externalIP) {
+		n.ipMu.Unlock()
+		return
+	}
+	n.externalIP = ip
+	n.votes = make(map[string]map[string]struct{})
+	n.ipMu.Unlock()
+
+	if n.cfg.ID == (NodeID{}) && !IsSecureID(n.ID(), ip) {
+		n.setID(GenerateSecureID(ip))
+	}
+}
+
+// 更换节点 ID，并将原路由表中的节点重新插入新的路由表
+func (n *Node) setID(id NodeID) {
+	old := n.rt()
+	rt := newRoutingTable(id)
+	now := time.Now()
+	for _, ni := range old.nodes() {
+		rt.insert(ni, now)
+	}
+	n.table.Store(rt)
+}
@@ -0,0 +1,138 @@
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type peerStore struct { // 通过 announce_peer 得知的 Peer
+	mu    sync.Mutex
+	peers map[NodeID]map[string]*storedPeer // info_hash -> 连接地址 -> Peer
+}
+
+type storedPeer struct {
+	torrent.PeerInfo
+	added time.Time
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{peers: make(map[NodeID]map[string]*storedPeer)}
+}
+
+// 保存一个 Peer，超出容量时替换最早的 Peer
+func (ps *peerStore) add(infoHash NodeID, peer torrent.PeerInfo, now time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	m, ok := ps.peers[infoHash]
+	if !ok {
+		m = make(map[string]*storedPeer)
+		ps.peers[infoHash] = m
+	}
+	addr := peer.GetConnAddr()
+	if _, ok := m[addr]; !ok && len(m) >= MAX_PEERS_PER {
+		var oldest string
+		for k, p := range m {
+			if oldest == "" || p.added.Before(m[oldest].added) {
+				oldest = k
+			}
+		}
+		delete(m, oldest)
+	}
+	m[addr] = &storedPeer{PeerInfo: peer, added: now}
+}
+
+// 获取未过期的 Peer
+func (ps *peerStore) get(infoHash NodeID, now time.Time) []torrent.PeerInfo {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	m := ps.peers[infoHash]
+	peers := make([]torrent.PeerInfo, 0, len(m))
+	for k, p := range m {
+		if now.Sub(p.added) > PEER_TTL {
+			delete(m, k)
+			continue
+		}
+		peers = append(peers, p.PeerInfo)
+	}
+	if len(m) == 0 {
+		delete(ps.peers, infoHash)
+	}
+	return peers
+}
+
+// 随机选出最多 n 个仍有未过期 Peer 的 info_hash，并返回这样的 info_hash 总数
+func (ps *peerStore) sample(n int, now time.Time) ([]NodeID, int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	hashes := make([]NodeID, 0, len(ps.peers))
+	for infoHash, m := range ps.peers {
+		for k, p := range m {
+			if now.Sub(p.added) > PEER_TTL {
+				delete(m, k)
+			}
+		}
+		if len(m) == 0 {
+			delete(ps.peers, infoHash)
+			continue
+		}
+		hashes = append(hashes, infoHash)
+	}
+	mrand.Shuffle(len(hashes), func(i, j int) { hashes[i], hashes[j] = hashes[j], hashes[i] })
+	if len(hashes) > n {
+		return hashes[:n], len(hashes)
+	}
+	return hashes, len(hashes)
+}
+
+type tokenManager struct { // announce_peer 的 token：sha1(密钥 + 请求者 IP)，密钥定期轮换
+	mu       sync.Mutex
+	secret   [20]byte
+	previous [20]byte
+	rotated  time.Time
+}
+
+func newTokenManager() *tokenManager {
+	tm := &tokenManager{rotated: time.Now()}
+	rand.Read(tm.secret[:])
+	tm.previous = tm.secret
+	return tm
+}
+
+// 按需轮换密钥
+func (tm *tokenManager) rotate(now time.Time) {
+	if now.Sub(tm.rotated) < TOKEN_ROTATE {
+		return
+	}
+	tm.previous = tm.secret
+	rand.Read(tm.secret[:])
+	tm.rotated = now
+}
+
+func tokenFor(secret [20]byte, ip net.IP) string {
+	h := sha1.New()
+	h.Write(secret[:])
+	h.Write(ip.To16())
+	return string(h.Sum(nil)[:8])
+}
+
+// 为 IP 生成 token
+func (tm *tokenManager) token(ip net.IP, now time.Time) string {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.rotate(now)
+	return tokenFor(tm.secret, ip)
+}
+
+// 校验 token，接受当前及上一个密钥生成的值
+func (tm *tokenManager) valid(token string, ip net.IP, now time.Time) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.rotate(now)
+	return token == tokenFor(tm.secret, ip) || token == tokenFor(tm.previous, ip)
+}
@@ -0,0 +1,174 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type entry struct { // 路由表中的节点
+	NodeInfo
+	lastSeen time.Time // 最近一次收到该节点消息的时间
+	failures int       // 连续无响应次数
+	secure   bool      // 节点 ID 是否符合 BEP 42
+}
+
+// 是否为坏节点（可被替换）
+func (e *entry) bad(now time.Time) bool {
+	return e.failures >= MAX_FAILURES || now.Sub(e.lastSeen) > NODE_STALE_AFTER*2
+}
+
+type bucket struct { // K 桶，按最近活动时间从旧到新排列
+	entries     []*entry
+	lastChanged time.Time // 最近一次变化的时间（用于刷新）
+}
+
+type routingTable struct { // Kademlia 路由表，第 i 个 K 桶存放与自身公共前缀为 i 位的节点
+	rwm     sync.RWMutex
+	self    NodeID
+	buckets [ID_BITS]bucket
+}
+
+func newRoutingTable(self NodeID) *routingTable {
+	return &routingTable{self: self}
+}
+
+// 节点所在的 K 桶下标
+func (rt *routingTable) bucketIndex(id NodeID) int {
+	i := rt.self.PrefixLen(id)
+	if i >= ID_BITS {
+		i = ID_BITS - 1
+	}
+	return i
+}
+
+// 记录一个有活动的节点：已存在则刷新，K 桶未满则加入，否则尝试替换坏节点；返回是否在表中
+func (rt *routingTable) insert(info NodeInfo, now time.Time) bool {
+	if info.ID == rt.self || info.Addr == nil || info.Addr.Port == 0 {
+		return false
+	}
+	rt.rwm.Lock()
+	defer rt.rwm.Unlock()
+
+	b := &rt.buckets[rt.bucketIndex(info.ID)]
+	for i, e := range b.entries {
+		if e.ID == info.ID {
+			e.Addr = info.Addr
+			e.lastSeen = now
+			e.failures = 0
+			b.entries = append(append(b.entries[:i], b.entries[i+1:]...), e) // 移到末尾
+			b.lastChanged = now
+			return true
+		}
+	}
+	newEntry := &entry{NodeInfo: info, lastSeen: now, secure: IsSecureID(info.ID, info.Addr.IP)}
+	if len(b.entries) < K {
+		b.entries = append(b.entries, newEntry)
+		b.lastChanged = now
+		return true
+	}
+	// K 桶已满：优先替换坏节点，其次用符合 BEP 42 的节点替换不符合的节点
+	replace := -1
+	for i, e := range b.entries {
+		if e.bad(now) {
+			replace = i
+			break
+		}
+		if replace < 0 && newEntry.secure && !e.secure {
+			replace = i
+		}
+	}
+	if replace < 0 {
+		return false
+	}
+	b.entries = append(append(b.entries[:replace], b.entries[replace+1:]...), newEntry)
+	b.lastChanged = now
+	return true
+}
+
+// 记录一次查询失败
+func (rt *routingTable) failed(id NodeID) {
+	rt.rwm.Lock()
+	defer rt.rwm.Unlock()
+	b := &rt.buckets[rt.bucketIndex(id)]
+	for _, e := range b.entries {
+		if e.ID == id {
+			e.failures++
+			return
+		}
+	}
+}
+
+// 返回距离 target 最近的 n 个非坏节点
+func (rt *routingTable) closest(target NodeID, n int) []NodeInfo {
+	rt.rwm.RLock()
+	defer rt.rwm.RUnlock()
+	now := time.Now()
+	nodes := make([]NodeInfo, 0, K*4)
+	for i := range rt.buckets {
+		for _, e := range rt.buckets[i].entries {
+			if !e.bad(now) {
+				nodes = append(nodes, e.NodeInfo)
+			}
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return closer(target, nodes[i].ID, nodes[j].ID) })
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// 返回超过 interval 没有变化的非空 K 桶下标
+func (rt *routingTable) staleBuckets(now time.Time, interval time.Duration) []int {
+	rt.rwm.RLock()
+	defer rt.rwm.RUnlock()
+	var idx []int
+	for i := range rt.buckets {
+		if len(rt.buckets[i].entries) > 0 && now.Sub(rt.buckets[i].lastChanged) > interval {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// 路由表中所有的非坏节点
+func (rt *routingTable) nodes() []NodeInfo {
+	rt.rwm.RLock()
+	defer rt.rwm.RUnlock()
+	now := time.Now()
+	var nodes []NodeInfo
+	for i := range rt.buckets {
+		for _, e := range rt.buckets[i].entries {
+			if !e.bad(now) {
+				nodes = append(nodes, e.NodeInfo)
+			}
+		}
+	}
+	return nodes
+}
+
+// 路由表中的节点总数
+func (rt *routingTable) size() int {
+	rt.rwm.RLock()
+	defer rt.rwm.RUnlock()
+	n := 0
+	for i := range rt.buckets {
+		n += len(rt.buckets[i].entries)
+	}
+	return n
+}
+
+// 生成一个落在第 i 个 K 桶中的随机 ID
+func (rt *routingTable) randomIDInBucket(i int) NodeID {
+	id := RandomID()
+	for bit := 0; bit <= i && bit < ID_BITS; bit++ {
+		mask := byte(0x80 >> (bit % 8))
+		if bit < i { // 前 i 位与自身相同
+			id[bit/8] = id[bit/8]&^mask | rt.self[bit/8]&mask
+		} else { // 第 i 位与自身不同
+			id[bit/8] = id[bit/8]&^mask | ^rt.self[bit/8]&mask
+		}
+	}
+	return id
+}
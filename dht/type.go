@@ -0,0 +1,67 @@
+package dht
+
+import (
+	"crypto/sha1"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	ID_LEN            = sha1.Size                // 节点 ID 长度
+	ID_BITS           = ID_LEN * 8               // 节点 ID 位数（即 K 桶数量）
+	K                 = 8                        // 每个 K 桶的容量
+	ALPHA             = 3                        // 迭代查找的并发度
+	NODE_INFO_LEN     = ID_LEN + net.IPv4len + 2 // 紧凑节点信息长度（IPv4）
+	NODE6_INFO_LEN    = ID_LEN + net.IPv6len + 2 // 紧凑节点信息长度（IPv6）
+	MAX_PACKET_SIZE   = 65536                    // UDP 包的最大长度
+	MAX_FAILURES      = 3                        // 节点连续无响应多少次后视为坏节点
+	MAX_PEERS_PER     = 100                      // 每个种子最多保存的 Peer 数量
+	MAX_SAMPLES       = 20                       // sample_infohashes 每次最多返回的 info_hash 数量
+	EXTERNAL_IP_VOTES = 3                        // 多少个不同节点报告同一外部 IP 后采纳（BEP 42）
+
+	QUERY_TIMEOUT    = 2 * time.Second  // 单次查询的超时时间
+	NODE_STALE_AFTER = 15 * time.Minute // 节点多久没有活动后视为可疑
+	PEER_TTL         = 30 * time.Minute // 通过 announce_peer 保存的 Peer 的存活时间
+	TOKEN_ROTATE     = 5 * time.Minute  // token 密钥的轮换间隔
+	REFRESH_INTERVAL = 15 * time.Minute // K 桶刷新间隔
+	SAMPLE_INTERVAL  = 6 * time.Hour    // 建议对方再次发送 sample_infohashes 的间隔（BEP 51 上限）
+)
+
+const ( // KRPC 消息类型
+	Y_QUERY    = "q"
+	Y_RESPONSE = "r"
+	Y_ERROR    = "e"
+)
+
+const ( // KRPC 查询方法
+	Q_PING              = "ping"
+	Q_FIND_NODE         = "find_node"
+	Q_GET_PEERS         = "get_peers"
+	Q_ANNOUNCE_PEER     = "announce_peer"
+	Q_SAMPLE_INFOHASHES = "sample_infohashes" // BEP 51
+)
+
+const ( // KRPC 错误码
+	ERR_GENERIC  = 201 // 一般错误
+	ERR_SERVER   = 202 // 服务端错误
+	ERR_PROTOCOL = 203 // 协议错误（如参数非法、token 错误）
+	ERR_METHOD   = 204 // 未知方法
+)
+
+var DEFAULT_BOOTSTRAP_NODES = []string{ // 公共的引导节点
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+var (
+	ErrMalformedMsg   = errors.New("malformed krpc message") // KRPC 消息格式错误
+	ErrInvalidNodeID  = errors.New("invalid node id")        // 节点 ID 非法
+	ErrTimeout        = errors.New("query timeout")          // 查询超时
+	ErrClosed         = errors.New("dht node closed")        // 节点已关闭
+	ErrNoNodes        = errors.New("routing table is empty") // 路由表中没有节点
+	ErrBootstrapFaild = errors.New("bootstrap failed")       // 引导失败
+	ErrBadToken       = errors.New("bad token")              // token 校验失败
+	ErrInvalidState   = errors.New("invalid dht state file") // 持久化文件格式错误
+)
@@ -0,0 +1,69 @@
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+type Announce struct { // BT-SEARCH 宣告消息（BEP 14）
+	Host      string            // 多播地址
+	Port      uint16            // 宣告者的 BitTorrent 监听端口
+	InfoHashs [][sha1.Size]byte // 宣告的种子
+	Cookie    string            // 用于过滤自身宣告的随机值
+}
+
+// 编码宣告消息
+func (a *Announce) Encode() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(SEARCH_LINE + "\r\n")
+	buf.WriteString("Host: " + a.Host + "\r\n")
+	buf.WriteString("Port: " + strconv.Itoa(int(a.Port)) + "\r\n")
+	for _, h := range a.InfoHashs {
+		buf.WriteString("Infohash: " + hex.EncodeToString(h[:]) + "\r\n")
+	}
+	if a.Cookie != "" {
+		buf.WriteString("cookie: " + a.Cookie + "\r\n")
+	}
+	buf.WriteString("\r\n\r\n")
+	return buf.Bytes()
+}
+
+// 解析宣告消息，忽略格式错误的 Infohash
+func ParseAnnounce(b []byte) (*Announce, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	line, err := r.ReadLine()
+	if err != nil || line != SEARCH_LINE {
+		return nil, ErrMalformedAnnounce
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil, ErrMalformedAnnounce
+	}
+	port, err := strconv.ParseUint(strings.TrimSpace(header.Get("Port")), 10, 16)
+	if err != nil || port == 0 {
+		return nil, ErrMalformedAnnounce
+	}
+	a := &Announce{
+		Host:   header.Get("Host"),
+		Port:   uint16(port),
+		Cookie: header.Get("Cookie"),
+	}
+	for _, v := range header.Values("Infohash") {
+		raw, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(raw) != sha1.Size {
+			continue
+		}
+		var h [sha1.Size]byte
+		copy(h[:], raw)
+		a.InfoHashs = append(a.InfoHashs, h)
+	}
+	if len(a.InfoHashs) == 0 {
+		return nil, ErrMalformedAnnounce
+	}
+	return a, nil
+}
@@ -0,0 +1,67 @@
+package lsd_test
+
+import (
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/lsd"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounceRoundTrip(t *testing.T) {
+	a := &lsd.Announce{
+		Host:      lsd.LSD_ADDR4,
+		Port:      6881,
+		InfoHashs: [][sha1.Size]byte{sha1.Sum([]byte("a")), sha1.Sum([]byte("b"))},
+		Cookie:    "abcdef",
+	}
+	b := a.Encode()
+	require.Equal(t, "BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 6881\r\n", string(b[:62]))
+	parsed, err := lsd.ParseAnnounce(b)
+	require.NoError(t, err)
+	require.Equal(t, a, parsed)
+
+	_, err = lsd.ParseAnnounce([]byte("BT-SEARCH * HTTP/1.1\r\nHost: x\r\nPort: 6881\r\nInfohash: zz\r\n\r\n\r\n"))
+	require.ErrorIs(t, err, lsd.ErrMalformedAnnounce)
+	_, err = lsd.ParseAnnounce([]byte("M-SEARCH * HTTP/1.1\r\n\r\n"))
+	require.ErrorIs(t, err, lsd.ErrMalformedAnnounce)
+}
+
+func TestDiscovery(t *testing.T) {
+	groups := []string{"239.192.152.143:16771"}
+	infoHash := sha1.Sum([]byte("torrent-go"))
+
+	found := make(chan torrent.PeerInfo, 4)
+	services := make([]*lsd.Service, 0, 2)
+	for _, port := range []uint16{7001, 7002} {
+		s, err := lsd.NewService(lsd.Config{Port: port, Groups: groups})
+		if err != nil {
+			t.Skipf("multicast unavailable: %s", err.Error())
+		}
+		go s.Serve()
+		t.Cleanup(func() { s.Close() })
+		services = append(services, s)
+	}
+
+	// 第一个服务只能收到第二个服务的宣告（自身的宣告通过 cookie 过滤）
+	services[0].Watch(infoHash, func(p torrent.PeerInfo) { found <- p })
+	services[1].Watch(infoHash, func(torrent.PeerInfo) {})
+	select {
+	case p := <-found:
+		require.Equal(t, uint16(7002), p.Port)
+		require.Equal(t, torrent.SourceLSD, p.Source)
+	case <-time.After(2 * time.Second):
+		t.Skip("multicast loopback unavailable")
+	}
+	peers, err := services[0].FindPeers(infoHash, 7001) // 已发现的 Peer 也可以通过 FindPeers 获取
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, uint16(7002), peers[0].Port)
+	select {
+	case p := <-found:
+		t.Fatalf("unexpected announce from port %d", p.Port)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
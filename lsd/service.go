@@ -0,0 +1,243 @@
+package lsd
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type Config struct { // LSD 服务配置
+	Port      uint16         // 本地 BitTorrent 监听端口
+	Groups    []string       // 多播地址（为空时使用 LSD_ADDR4 与 LSD_ADDR6）
+	Interface *net.Interface // 加入多播组使用的网卡（为 nil 时由系统选择）
+}
+
+type group struct { // 已加入的多播组
+	addr *net.UDPAddr // 多播地址
+	recv *net.UDPConn // 接收多播消息
+	send *net.UDPConn // 发送宣告（使用普通端口，使对端看到本机的单播地址）
+}
+
+type watch struct { // 正在宣告的种子
+	onPeer       func(torrent.PeerInfo)      // 发现局域网 Peer 时的回调（可以为 nil）
+	peers        map[string]torrent.PeerInfo // 已发现的局域网 Peer（以地址为键）
+	lastAnnounce time.Time                   // 上次宣告的时间
+}
+
+type Service struct { // 本地 Peer 发现服务（BEP 14）
+	cfg    Config
+	cookie string   // 本机宣告携带的随机值，用于过滤自身的宣告
+	groups []*group // 已加入的多播组
+
+	mu      sync.Mutex
+	watched map[[sha1.Size]byte]*watch
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// 加入多播组并新建服务，至少成功加入一个多播组；需要调用 Serve 开始收发宣告
+func NewService(cfg Config) (*Service, error) {
+	if len(cfg.Groups) == 0 {
+		cfg.Groups = []string{LSD_ADDR4, LSD_ADDR6}
+	}
+	cookie := make([]byte, COOKIE_LEN)
+	rand.Read(cookie)
+	s := &Service{
+		cfg:     cfg,
+		cookie:  hex.EncodeToString(cookie),
+		watched: make(map[[sha1.Size]byte]*watch),
+		closed:  make(chan struct{}),
+	}
+	var lastErr error
+	for _, host := range cfg.Groups {
+		g, err := joinGroup(host, cfg.Interface)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.groups = append(s.groups, g)
+	}
+	if len(s.groups) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, ErrNoGroup
+	}
+	return s, nil
+}
+
+// 加入 host 对应的多播组
+func joinGroup(host string, ifi *net.Interface) (*group, error) {
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
+	}
+	recv, err := net.ListenMulticastUDP(network, ifi, addr)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.ListenUDP(network, nil)
+	if err != nil {
+		recv.Close()
+		return nil, err
+	}
+	return &group{addr: addr, recv: recv, send: send}, nil
+}
+
+// 开始宣告 infoHash，发现拥有该种子的局域网 Peer 时调用 onPeer（实现 torrent.PeerWatcher）
+func (s *Service) Watch(infoHash [sha1.Size]byte, onPeer func(torrent.PeerInfo)) {
+	s.mu.Lock()
+	s.watch(infoHash).onPeer = onPeer
+	s.mu.Unlock()
+	s.announce(time.Now(), infoHash)
+}
+
+// 开始宣告 infoHash（不改变已设置的回调），返回已经发现的局域网 Peer（实现 torrent.PeerFinder，port 使用 Config.Port）
+func (s *Service) FindPeers(infoHash [sha1.Size]byte, port uint16) ([]torrent.PeerInfo, error) {
+	s.mu.Lock()
+	w := s.watch(infoHash)
+	peers := make([]torrent.PeerInfo, 0, len(w.peers))
+	for _, p := range w.peers {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+	s.announce(time.Now(), infoHash)
+	return peers, nil
+}
+
+// 获取或新建 infoHash 的宣告（调用者需持有锁）
+func (s *Service) watch(infoHash [sha1.Size]byte) *watch {
+	w, ok := s.watched[infoHash]
+	if !ok {
+		w = &watch{peers: make(map[string]torrent.PeerInfo)}
+		s.watched[infoHash] = w
+	}
+	return w
+}
+
+// 停止宣告 infoHash
+func (s *Service) Unwatch(infoHash [sha1.Size]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watched, infoHash)
+}
+
+// 接收局域网中的宣告并定期宣告正在下载的种子，直到服务被关闭
+func (s *Service) Serve() error {
+	for _, g := range s.groups {
+		go s.readRoutine(g)
+	}
+	ticker := time.NewTicker(ANNOUNCE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return nil
+		case now := <-ticker.C:
+			s.mu.Lock()
+			hashes := make([][sha1.Size]byte, 0, len(s.watched))
+			for h := range s.watched {
+				hashes = append(hashes, h)
+			}
+			s.mu.Unlock()
+			s.announce(now, hashes...)
+		}
+	}
+}
+
+// 关闭服务
+func (s *Service) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		for _, g := range s.groups {
+			g.recv.Close()
+			g.send.Close()
+		}
+	})
+	return nil
+}
+
+// 向所有多播组宣告距上次宣告超过 MIN_ANNOUNCE_INTERVAL 的种子，超过包长度限制时拆分为多条消息
+func (s *Service) announce(now time.Time, hashes ...[sha1.Size]byte) {
+	s.mu.Lock()
+	due := make([][sha1.Size]byte, 0, len(hashes))
+	for _, h := range hashes {
+		if w, ok := s.watched[h]; ok && now.Sub(w.lastAnnounce) >= MIN_ANNOUNCE_INTERVAL {
+			w.lastAnnounce = now
+			due = append(due, h)
+		}
+	}
+	s.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	for _, g := range s.groups {
+		a := &Announce{Host: g.addr.String(), Port: s.cfg.Port, Cookie: s.cookie}
+		for _, h := range due {
+			a.InfoHashs = append(a.InfoHashs, h)
+			if len(a.InfoHashs) > 1 && len(a.Encode()) > MAX_PACKET_SIZE {
+				a.InfoHashs = a.InfoHashs[:len(a.InfoHashs)-1]
+				g.send.WriteToUDP(a.Encode(), g.addr)
+				a.InfoHashs = [][sha1.Size]byte{h}
+			}
+		}
+		g.send.WriteToUDP(a.Encode(), g.addr)
+	}
+}
+
+// 读取多播组中的宣告
+func (s *Service) readRoutine(g *group) {
+	buf := make([]byte, MAX_PACKET_SIZE*2)
+	for {
+		size, from, err := g.recv.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		s.handle(buf[:size], from)
+	}
+}
+
+// 处理一条宣告：忽略自身的宣告，记录正在下载的种子的 Peer 并交给回调，
+// 并在允许时回复一次宣告，让对方也能尽快发现本机
+func (s *Service) handle(b []byte, from *net.UDPAddr) {
+	a, err := ParseAnnounce(b)
+	if err != nil || a.Cookie == s.cookie {
+		return
+	}
+	peer := torrent.PeerInfo{IP: from.IP, Port: a.Port, Source: torrent.SourceLSD}
+	var callbacks []func(torrent.PeerInfo)
+	var hashes [][sha1.Size]byte
+	s.mu.Lock()
+	for _, h := range a.InfoHashs {
+		if w, ok := s.watched[h]; ok {
+			w.peers[peer.GetConnAddr()] = peer
+			if w.onPeer != nil {
+				callbacks = append(callbacks, w.onPeer)
+			}
+			hashes = append(hashes, h)
+		}
+	}
+	s.mu.Unlock()
+	for _, onPeer := range callbacks {
+		onPeer(peer)
+	}
+	s.announce(time.Now(), hashes...)
+}
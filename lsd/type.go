@@ -0,0 +1,22 @@
+package lsd
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	LSD_ADDR4             = "239.192.152.143:6771"   // IPv4 多播地址
+	LSD_ADDR6             = "[ff15::efc0:988f]:6771" // IPv6 多播地址
+	ANNOUNCE_INTERVAL     = 5 * time.Minute          // 定期宣告的间隔
+	MIN_ANNOUNCE_INTERVAL = 1 * time.Minute          // 同一个种子两次宣告的最小间隔
+	MAX_PACKET_SIZE       = 1400                     // 宣告消息的最大长度
+	COOKIE_LEN            = 8                        // cookie 长度（十六进制编码前）
+	SEARCH_LINE           = "BT-SEARCH * HTTP/1.1"   // 宣告消息的请求行
+)
+
+var (
+	ErrMalformedAnnounce = errors.New("malformed lsd announce")    // 宣告消息格式错误
+	ErrNoGroup           = errors.New("no multicast group joined") // 没有成功加入任何多播组
+	ErrClosed            = errors.New("lsd service closed")        // 服务已关闭
+)
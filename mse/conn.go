@@ -0,0 +1,66 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rc4"
+	"io"
+	"net"
+	"sync"
+)
+
+type Conn struct { // 完成 MSE 握手后的连接（选择明文时不加密）
+	net.Conn
+	r      io.Reader    // 读取（包含握手时已缓冲的数据）
+	enc    *rc4.Cipher  // 发送方向的密钥流（为 nil 时不加密）
+	wmu    sync.Mutex   // 保证密钥流与写入的顺序一致
+	Method CryptoMethod // 协商的加密方式
+}
+
+type cipherReader struct { // 解密读取的数据
+	r   io.Reader
+	dec *rc4.Cipher
+}
+
+func (cr *cipherReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.dec.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// 新建连接：先读取 prefix（已解密的数据），再从 br 读取并用 dec 解密，写入时用 enc 加密
+func newConn(conn net.Conn, br *bufio.Reader, prefix []byte, enc, dec *rc4.Cipher, method CryptoMethod) *Conn {
+	var r io.Reader = br
+	if dec != nil {
+		r = &cipherReader{br, dec}
+	}
+	if len(prefix) > 0 {
+		r = io.MultiReader(bytes.NewReader(prefix), r)
+	}
+	return &Conn{Conn: conn, r: r, enc: enc, Method: method}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(p)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	buf := make([]byte, len(p))
+	c.enc.XORKeyStream(buf, p)
+	return c.Conn.Write(buf)
+}
+
+// 检查连入的连接是否以明文 BitTorrent 握手开头，返回的连接仍能读到已检查的数据
+func Detect(conn net.Conn) (*Conn, bool, error) {
+	br := bufio.NewReader(conn)
+	head, err := br.Peek(len(PROTOCOL_HEADER))
+	if err != nil {
+		return nil, false, err
+	}
+	return newConn(conn, br, nil, nil, nil, CryptoPlaintext), string(head) == PROTOCOL_HEADER, nil
+}
@@ -0,0 +1,239 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"time"
+)
+
+type dhKey struct { // DH 密钥对
+	private *big.Int
+	public  []byte // 公钥（KEY_LEN 字节，大端序）
+}
+
+// 生成 DH 密钥对
+func newDHKey() (*dhKey, error) {
+	buf := make([]byte, PRIVATE_KEY_LEN)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	private := new(big.Int).SetBytes(buf)
+	public := new(big.Int).Exp(dhGenerator, private, dhPrime)
+	return &dhKey{private, public.FillBytes(make([]byte, KEY_LEN))}, nil
+}
+
+// 根据对端公钥计算共享密钥 S
+func (k *dhKey) secret(remote []byte) []byte {
+	s := new(big.Int).Exp(new(big.Int).SetBytes(remote), k.private, dhPrime)
+	return s.FillBytes(make([]byte, KEY_LEN))
+}
+
+// SHA-1(parts...)
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// 由共享密钥与 SKEY 生成 RC4 密钥流（丢弃前 RC4_DISCARD 字节）
+func newCipher(name string, s, skey []byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(hash([]byte(name), s, skey))
+	discard := make([]byte, RC4_DISCARD)
+	c.XORKeyStream(discard, discard)
+	return c
+}
+
+// 随机长度（0 ~ MAX_PAD_LEN）的填充
+func randomPad() []byte {
+	pad := make([]byte, mrand.Intn(MAX_PAD_LEN+1))
+	rand.Read(pad)
+	return pad
+}
+
+// 从 r 读取直到遇到 marker，marker 之前最多允许 limit 字节
+func syncTo(r *bufio.Reader, marker []byte, limit int) error {
+	buf := make([]byte, 0, limit+len(marker))
+	for len(buf) < cap(buf) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b)
+		if bytes.HasSuffix(buf, marker) {
+			return nil
+		}
+	}
+	return ErrSyncNotFound
+}
+
+// 读取并解密 n 字节
+func readDecrypt(r io.Reader, dec *rc4.Cipher, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(buf, buf)
+	return buf, nil
+}
+
+// 读取并解密 2 字节长度及其后的内容（长度不超过 limit）
+func readDecryptBlock(r io.Reader, dec *rc4.Cipher, limit int) ([]byte, error) {
+	lenBuf, err := readDecrypt(r, dec, 2)
+	if err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(lenBuf))
+	if n > limit {
+		return nil, ErrInvalidPad
+	}
+	return readDecrypt(r, dec, n)
+}
+
+// 作为发起方完成 MSE 握手，skey 为种子的 info_hash，provide 为本地支持的加密方式
+func Initiate(conn net.Conn, skey []byte, provide CryptoMethod) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIME))
+	defer conn.SetDeadline(time.Time{})
+
+	key, err := newDHKey()
+	if err != nil {
+		return nil, err
+	}
+	// 1. A -> B: Ya, PadA
+	if _, err = conn.Write(append(append([]byte(nil), key.public...), randomPad()...)); err != nil {
+		return nil, err
+	}
+	// 2. B -> A: Yb, PadB
+	br := bufio.NewReader(conn)
+	yb := make([]byte, KEY_LEN)
+	if _, err = io.ReadFull(br, yb); err != nil {
+		return nil, err
+	}
+	s := key.secret(yb)
+	enc, dec := newCipher("keyA", s, skey), newCipher("keyB", s, skey)
+
+	// 3. A -> B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S), ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+	req2, req3 := hash([]byte("req2"), skey), hash([]byte("req3"), s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	payload := make([]byte, VC_LEN+4+2+2) // PadC 与 IA 均为空
+	binary.BigEndian.PutUint32(payload[VC_LEN:], uint32(provide))
+	enc.XORKeyStream(payload, payload)
+	msg := append(hash([]byte("req1"), s), req2...)
+	if _, err = conn.Write(append(msg, payload...)); err != nil {
+		return nil, err
+	}
+
+	// 4. B -> A: ENCRYPT(VC, crypto_select, len(padD), padD), ENCRYPT2(Payload Stream)
+	vc := make([]byte, VC_LEN)
+	dec.XORKeyStream(vc, vc)
+	if err = syncTo(br, vc, MAX_PAD_LEN); err != nil { // 跳过 PadB
+		return nil, err
+	}
+	selectBuf, err := readDecrypt(br, dec, 4)
+	if err != nil {
+		return nil, err
+	}
+	method := CryptoMethod(binary.BigEndian.Uint32(selectBuf))
+	if method&provide == 0 || (method != CryptoPlaintext && method != CryptoRC4) {
+		return nil, ErrInvalidSelected
+	}
+	if _, err = readDecryptBlock(br, dec, MAX_PAD_LEN); err != nil { // PadD
+		return nil, err
+	}
+	if method == CryptoPlaintext {
+		return newConn(conn, br, nil, nil, nil, method), nil
+	}
+	return newConn(conn, br, nil, enc, dec, method), nil
+}
+
+// 作为接收方完成 MSE 握手：skeys 为可接受的 SKEY（info_hash），choose 根据对端提供的加密方式做出选择（返回 0 表示拒绝）
+func Receive(conn net.Conn, skeys [][]byte, choose func(skey []byte, provide CryptoMethod) CryptoMethod) (*Conn, []byte, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIME))
+	defer conn.SetDeadline(time.Time{})
+
+	// 1. A -> B: Ya, PadA
+	br := bufio.NewReader(conn)
+	ya := make([]byte, KEY_LEN)
+	if _, err := io.ReadFull(br, ya); err != nil {
+		return nil, nil, err
+	}
+	key, err := newDHKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	// 2. B -> A: Yb, PadB
+	if _, err = conn.Write(append(append([]byte(nil), key.public...), randomPad()...)); err != nil {
+		return nil, nil, err
+	}
+	s := key.secret(ya)
+
+	// 3. A -> B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S), ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+	if err = syncTo(br, hash([]byte("req1"), s), MAX_PAD_LEN); err != nil { // 跳过 PadA
+		return nil, nil, err
+	}
+	req2 := make([]byte, sha1.Size)
+	if _, err = io.ReadFull(br, req2); err != nil {
+		return nil, nil, err
+	}
+	req3 := hash([]byte("req3"), s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	var skey []byte
+	for _, k := range skeys {
+		if bytes.Equal(hash([]byte("req2"), k), req2) {
+			skey = k
+			break
+		}
+	}
+	if skey == nil {
+		return nil, nil, ErrUnknownSKEY
+	}
+	enc, dec := newCipher("keyB", s, skey), newCipher("keyA", s, skey)
+	vc, err := readDecrypt(br, dec, VC_LEN)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(vc, make([]byte, VC_LEN)) {
+		return nil, nil, ErrInvalidVC
+	}
+	provideBuf, err := readDecrypt(br, dec, 4)
+	if err != nil {
+		return nil, nil, err
+	}
+	provide := CryptoMethod(binary.BigEndian.Uint32(provideBuf))
+	if _, err = readDecryptBlock(br, dec, MAX_PAD_LEN); err != nil { // PadC
+		return nil, nil, err
+	}
+	ia, err := readDecryptBlock(br, dec, 1<<16-1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 4. B -> A: ENCRYPT(VC, crypto_select, len(padD), padD), ENCRYPT2(Payload Stream)
+	method := choose(skey, provide)
+	if method == 0 || method&provide == 0 {
+		return nil, nil, ErrNoCommonMethod
+	}
+	payload := make([]byte, VC_LEN+4+2) // PadD 为空
+	binary.BigEndian.PutUint32(payload[VC_LEN:], uint32(method))
+	enc.XORKeyStream(payload, payload)
+	if _, err = conn.Write(payload); err != nil {
+		return nil, nil, err
+	}
+	if method == CryptoPlaintext {
+		return newConn(conn, br, ia, nil, nil, method), skey, nil
+	}
+	return newConn(conn, br, ia, enc, dec, method), skey, nil
+}
@@ -0,0 +1,116 @@
+package mse_test
+
+import (
+	"crypto/sha1"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Akimio521/torrent-go/mse"
+	"github.com/stretchr/testify/require"
+)
+
+// 建立一对 TCP 连接
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	b := <-accepted
+	require.NotNil(t, b)
+	t.Cleanup(func() { a.Close(); b.Close() })
+	return a, b
+}
+
+type result struct {
+	conn *mse.Conn
+	skey []byte
+	err  error
+}
+
+// 在 a 上发起、在 b 上接收握手
+func handshake(t *testing.T, skey []byte, provide mse.CryptoMethod, skeys [][]byte, choose func([]byte, mse.CryptoMethod) mse.CryptoMethod) (result, result) {
+	a, b := connPair(t)
+	done := make(chan result, 1)
+	go func() {
+		conn, skey, err := mse.Receive(b, skeys, choose)
+		done <- result{conn, skey, err}
+		if err != nil {
+			b.Close()
+		}
+	}()
+	conn, err := mse.Initiate(a, skey, provide)
+	return result{conn, skey, err}, <-done
+}
+
+func TestHandshake(t *testing.T) {
+	hashA, hashB := sha1.Sum([]byte("a")), sha1.Sum([]byte("b"))
+	skeys := [][]byte{hashA[:], hashB[:]}
+	preferRC4 := func(skey []byte, provide mse.CryptoMethod) mse.CryptoMethod {
+		if provide&mse.CryptoRC4 != 0 {
+			return mse.CryptoRC4
+		}
+		return mse.CryptoPlaintext
+	}
+
+	for _, method := range []mse.CryptoMethod{mse.CryptoRC4, mse.CryptoPlaintext} {
+		initiator, res := handshake(t, hashB[:], method, skeys, preferRC4)
+		require.NoError(t, initiator.err)
+		require.NoError(t, res.err)
+		require.Equal(t, hashB[:], res.skey)
+		require.Equal(t, method, initiator.conn.Method)
+		require.Equal(t, method, res.conn.Method)
+
+		// 双向传输
+		go initiator.conn.Write([]byte("\x13BitTorrent protocol"))
+		buf := make([]byte, 20)
+		_, err := io.ReadFull(res.conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "\x13BitTorrent protocol", string(buf))
+		go res.conn.Write([]byte("pong"))
+		_, err = io.ReadFull(initiator.conn, buf[:4])
+		require.NoError(t, err)
+		require.Equal(t, "pong", string(buf[:4]))
+	}
+
+	// 同时提供两种方式时由接收方选择
+	initiator, res := handshake(t, hashA[:], mse.CryptoRC4|mse.CryptoPlaintext, skeys, preferRC4)
+	require.NoError(t, initiator.err)
+	require.NoError(t, res.err)
+	require.Equal(t, mse.CryptoRC4, initiator.conn.Method)
+
+	// 未知的 SKEY
+	unknown := sha1.Sum([]byte("c"))
+	initiator, res = handshake(t, unknown[:], mse.CryptoRC4, skeys, preferRC4)
+	require.Error(t, initiator.err)
+	require.ErrorIs(t, res.err, mse.ErrUnknownSKEY)
+
+	// 没有共同的加密方式
+	initiator, res = handshake(t, hashA[:], mse.CryptoPlaintext, skeys, func([]byte, mse.CryptoMethod) mse.CryptoMethod { return 0 })
+	require.Error(t, initiator.err)
+	require.ErrorIs(t, res.err, mse.ErrNoCommonMethod)
+}
+
+func TestDetect(t *testing.T) {
+	a, b := connPair(t)
+	go a.Write([]byte("\x13BitTorrent protocol and more"))
+	conn, plaintext, err := mse.Detect(b)
+	require.NoError(t, err)
+	require.True(t, plaintext)
+	buf := make([]byte, 29)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "\x13BitTorrent protocol and more", string(buf))
+
+	a, b = connPair(t)
+	go a.Write(make([]byte, 96))
+	_, plaintext, err = mse.Detect(b)
+	require.NoError(t, err)
+	require.False(t, plaintext)
+}
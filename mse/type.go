@@ -0,0 +1,40 @@
+package mse
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+const (
+	KEY_LEN         = 96                        // DH 公钥长度（768 位）
+	PRIVATE_KEY_LEN = 20                        // DH 私钥长度（160 位）
+	VC_LEN          = 8                         // 验证常量长度
+	MAX_PAD_LEN     = 512                       // 填充的最大长度
+	RC4_DISCARD     = 1024                      // RC4 丢弃的密钥流长度
+	HANDSHAKE_TIME  = 10 * time.Second          // 完成 MSE 握手的超时时间
+	PROTOCOL_HEADER = "\x13BitTorrent protocol" // 明文 BitTorrent 握手的开头
+)
+
+type CryptoMethod uint32 // 加密方式（crypto_provide / crypto_select 中的位）
+
+const (
+	CryptoPlaintext CryptoMethod = 0x01 // 握手之后不加密
+	CryptoRC4       CryptoMethod = 0x02 // RC4 加密
+)
+
+var (
+	// DH 素数 P（768 位）
+	dhPrime, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	// DH 生成元 G
+	dhGenerator = big.NewInt(2)
+)
+
+var (
+	ErrSyncNotFound    = errors.New("mse sync marker not found")   // 在填充之后没有找到同步标记
+	ErrUnknownSKEY     = errors.New("mse unknown skey")            // 连入的对端请求的 SKEY（info_hash）未知
+	ErrInvalidVC       = errors.New("mse invalid verification")    // 验证常量错误
+	ErrInvalidPad      = errors.New("mse pad too long")            // 填充长度超过 MAX_PAD_LEN
+	ErrNoCommonMethod  = errors.New("mse no common crypto method") // 没有双方都支持的加密方式
+	ErrInvalidSelected = errors.New("mse invalid crypto select")   // 对端选择了本地未提供的加密方式
+)
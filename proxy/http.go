@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type HTTPConnect struct { // 通过 HTTP CONNECT 隧道连接的代理（只支持 TCP）
+	Addr     string // 代理地址（host:port）
+	Username string // 用户名（为空时不认证）
+	Password string // 密码
+}
+
+func (h *HTTPConnect) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		return nil, ErrUDPNotSupported
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNetwork, network)
+	}
+	conn, err := Direct.DialContext(ctx, "tcp", h.Addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(HANDSHAKE_TIMEOUT)
+	}
+	conn.SetDeadline(deadline)
+	br, err := h.connect(conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 { // 代理在响应之后立即转发的数据
+		return &bufferedConn{conn, br}, nil
+	}
+	return conn, nil
+}
+
+// 发送 CONNECT 请求并读取响应
+func (h *HTTPConnect) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if h.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+		req.Header.Set(HTTP_PROXY_AUTH_HEAD, "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, ErrAuthFailed
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%w: %s", ErrRefused, resp.Status)
+	}
+	return br, nil
+}
+
+type bufferedConn struct { // 先读取缓冲区中剩余数据的连接
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// 按 URL 创建代理：socks5://[user:pass@]host:port 或 http://[user:pass@]host:port
+func FromURL(u *url.URL) (Dialer, error) {
+	password, _ := u.User.Password()
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return &SOCKS5{Addr: u.Host, Username: u.User.Username(), Password: password}, nil
+	case "http":
+		return &HTTPConnect{Addr: u.Host, Username: u.User.Username(), Password: password}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownScheme, u.Scheme)
+}
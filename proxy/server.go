@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+type Request struct { // 代理收到的请求
+	Network string // "tcp" 或 "udp"
+	Addr    string // 目标地址（UDP 为每个数据报的目标地址）
+}
+
+type Server struct { // 简单的进程内代理：在同一端口上接受 SOCKS5（CONNECT 与 UDP ASSOCIATE）与 HTTP CONNECT，用于测试
+	Username string // 要求的用户名（为空时不认证）
+	Password string // 要求的密码
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// 接受并处理连接，直到 ln 被关闭
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// 已处理的请求的副本
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) record(network, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{network, addr})
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == SOCKS5_VERSION {
+		s.handleSOCKS5(conn, br)
+	} else {
+		s.handleHTTP(conn, br)
+	}
+}
+
+func (s *Server) handleSOCKS5(conn net.Conn, br *bufio.Reader) {
+	// 认证方式
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return
+	}
+	want := byte(socks5AuthNone)
+	if s.Username != "" {
+		want = socks5AuthPassword
+	}
+	accepted := false
+	for _, m := range methods {
+		accepted = accepted || m == want
+	}
+	if !accepted {
+		conn.Write([]byte{SOCKS5_VERSION, socks5AuthNoAccept})
+		return
+	}
+	conn.Write([]byte{SOCKS5_VERSION, want})
+	if want == socks5AuthPassword {
+		user, pass, err := readCredentials(br)
+		if err != nil {
+			return
+		}
+		if user != s.Username || pass != s.Password {
+			conn.Write([]byte{SOCKS5_AUTH_VERSION, 1})
+			return
+		}
+		conn.Write([]byte{SOCKS5_AUTH_VERSION, 0})
+	}
+
+	// 命令
+	req := make([]byte, 3)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return
+	}
+	addr, err := readAddr(br)
+	if err != nil {
+		reply(conn, socks5AddrNotSupported, nil)
+		return
+	}
+	switch req[1] {
+	case socks5CmdConnect:
+		s.record("tcp", addr)
+		target, err := net.Dial("tcp", addr)
+		if err != nil {
+			reply(conn, socks5ConnRefused, nil)
+			return
+		}
+		defer target.Close()
+		reply(conn, socks5Succeeded, target.LocalAddr())
+		pipe(conn, br, target)
+	case socks5CmdUDPAssociate:
+		host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+		relay, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			reply(conn, socks5GeneralFailure, nil)
+			return
+		}
+		defer relay.Close()
+		reply(conn, socks5Succeeded, relay.LocalAddr())
+		go s.relayUDP(relay)
+		io.Copy(io.Discard, br) // 控制连接关闭时结束中继
+	default:
+		reply(conn, socks5CmdNotSupported, nil)
+	}
+}
+
+// 读取用户名/密码认证请求（RFC 1929）
+func readCredentials(r io.Reader) (string, string, error) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", "", err
+	}
+	user := make([]byte, b[1])
+	if _, err := io.ReadFull(r, user); err != nil {
+		return "", "", err
+	}
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return "", "", err
+	}
+	pass := make([]byte, b[0])
+	if _, err := io.ReadFull(r, pass); err != nil {
+		return "", "", err
+	}
+	return string(user), string(pass), nil
+}
+
+// 回复 SOCKS5 命令，bound 为 nil 时回复 0.0.0.0:0
+func reply(conn net.Conn, code byte, bound net.Addr) {
+	addr := "0.0.0.0:0"
+	if bound != nil {
+		addr = bound.String()
+	}
+	buf, _ := appendAddr([]byte{SOCKS5_VERSION, code, 0}, addr)
+	conn.Write(buf)
+}
+
+// 转发 UDP 数据报：来自客户端的数据报去除数据报头后发送给目标，来自其他地址的数据报添加数据报头后发送给客户端
+func (s *Server) relayUDP(relay net.PacketConn) {
+	buf := make([]byte, MAX_UDP_PACKET)
+	var client net.Addr
+	for {
+		n, from, err := relay.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			client = from
+			payload, addr, err := parseUDPHeader(buf[:n])
+			if err != nil {
+				continue
+			}
+			target, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				continue
+			}
+			s.record("udp", addr)
+			relay.WriteTo(payload, target)
+			continue
+		}
+		packet, err := appendAddr([]byte{0, 0, 0}, from.String())
+		if err != nil {
+			continue
+		}
+		relay.WriteTo(append(packet, buf[:n]...), client)
+	}
+}
+
+func (s *Server) handleHTTP(conn net.Conn, br *bufio.Reader) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	if s.Username != "" {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(s.Username+":"+s.Password))
+		if req.Header.Get(HTTP_PROXY_AUTH_HEAD) != want {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+	}
+	s.record("tcp", req.Host)
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	pipe(conn, br, target)
+}
+
+// 双向转发，任一方向结束时返回
+func pipe(conn net.Conn, br *bufio.Reader, target net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, br)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type SOCKS5 struct { // SOCKS5 代理（RFC 1928），支持 CONNECT 与 UDP ASSOCIATE
+	Addr     string // 代理地址（host:port）
+	Username string // 用户名（为空时不认证）
+	Password string // 密码
+}
+
+func (s *SOCKS5) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		conn, _, err := s.request(ctx, socks5CmdConnect, addr)
+		return conn, err
+	case "udp", "udp4", "udp6":
+		return s.associate(ctx, addr)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNetwork, network)
+}
+
+// 连接代理并完成认证，发送命令 cmd，返回控制连接与代理绑定的地址
+func (s *SOCKS5) request(ctx context.Context, cmd byte, addr string) (net.Conn, string, error) {
+	conn, err := Direct.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return nil, "", err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(HANDSHAKE_TIMEOUT)
+	}
+	conn.SetDeadline(deadline)
+	bound, err := s.handshake(conn, cmd, addr)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, bound, nil
+}
+
+func (s *SOCKS5) handshake(conn net.Conn, cmd byte, addr string) (string, error) {
+	// 协商认证方式
+	method := byte(socks5AuthNone)
+	if s.Username != "" {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{SOCKS5_VERSION, 1, method}); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", err
+	}
+	if buf[0] != SOCKS5_VERSION {
+		return "", ErrBadVersion
+	}
+	if buf[1] != method {
+		return "", ErrNoAcceptableAuth
+	}
+	if method == socks5AuthPassword {
+		if len(s.Username) > 255 || len(s.Password) > 255 {
+			return "", ErrAuthFailed
+		}
+		req := []byte{SOCKS5_AUTH_VERSION, byte(len(s.Username))}
+		req = append(req, s.Username...)
+		req = append(req, byte(len(s.Password)))
+		req = append(req, s.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return "", err
+		}
+		if buf[1] != 0 {
+			return "", ErrAuthFailed
+		}
+	}
+
+	// 发送命令
+	req, err := appendAddr([]byte{SOCKS5_VERSION, cmd, 0}, addr)
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", err
+	}
+	if head[0] != SOCKS5_VERSION {
+		return "", ErrBadVersion
+	}
+	if head[1] != socks5Succeeded {
+		return "", fmt.Errorf("%w: code %d", ErrRefused, head[1])
+	}
+	return readAddr(conn)
+}
+
+// 通过 UDP ASSOCIATE 建立到 addr 的 UDP 连接，控制连接在 UDP 连接关闭时关闭
+func (s *SOCKS5) associate(ctx context.Context, addr string) (net.Conn, error) {
+	header, err := appendAddr([]byte{0, 0, 0}, addr) // RSV、FRAG 与目标地址
+	if err != nil {
+		return nil, err
+	}
+	ctrl, bound, err := s.request(ctx, socks5CmdUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	host, port, _ := net.SplitHostPort(bound)
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() { // 代理没有指定中继地址时使用代理的地址
+		host, _, _ = net.SplitHostPort(ctrl.RemoteAddr().String())
+	}
+	relay, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	c := &udpConn{Conn: relay, ctrl: ctrl, header: header, remote: udpAddr(addr)}
+	go c.watch()
+	return c, nil
+}
+
+type udpConn struct { // 通过 SOCKS5 中继的 UDP 连接，读写时添加与去除 SOCKS5 UDP 数据报头
+	net.Conn          // 到中继地址的 UDP 连接
+	ctrl     net.Conn // 控制连接（关闭后代理结束中继）
+	header   []byte   // 发送的数据报头（包括目标地址）
+	remote   net.Addr // 目标地址
+	once     sync.Once
+}
+
+// 控制连接断开时关闭 UDP 连接
+func (c *udpConn) watch() {
+	io.Copy(io.Discard, c.ctrl)
+	c.Close()
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	buf := make([]byte, 0, len(c.header)+len(b))
+	buf = append(append(buf, c.header...), b...)
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	buf := make([]byte, SOCKS5_MAX_UDP_HEAD+len(b))
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		payload, _, err := parseUDPHeader(buf[:n])
+		if err != nil { // 忽略格式错误的数据报
+			continue
+		}
+		return copy(b, payload), nil
+	}
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *udpConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.Conn.Close()
+		c.ctrl.Close()
+	})
+	return err
+}
+
+type hostAddr struct { // 未解析的域名地址
+	network, addr string
+}
+
+func (a hostAddr) Network() string { return a.network }
+func (a hostAddr) String() string  { return a.addr }
+
+// addr 为 IP 地址时返回 *net.UDPAddr，否则返回未解析的地址
+func udpAddr(addr string) net.Addr {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.UDPAddrFromAddrPort(ap)
+	}
+	return hostAddr{"udp", addr}
+}
+
+// 按 SOCKS5 的格式（ATYP、地址、端口）追加 addr
+func appendAddr(buf []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(append(buf, socks5AddrIPv4), ip4...)
+		} else {
+			buf = append(append(buf, socks5AddrIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, ErrAddrType
+		}
+		buf = append(append(buf, socks5AddrDomain, byte(len(host))), host...)
+	}
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// 读取 SOCKS5 格式的地址，返回 host:port
+func readAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == socks5AddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(r, atyp); err != nil {
+			return "", err
+		}
+		name := make([]byte, atyp[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", ErrAddrType
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// 解析 SOCKS5 UDP 数据报，返回数据与地址
+func parseUDPHeader(b []byte) ([]byte, string, error) {
+	if len(b) < 4 || b[0] != 0 || b[1] != 0 {
+		return nil, "", ErrBadUDPHeader
+	}
+	if b[2] != 0 {
+		return nil, "", ErrFragmented
+	}
+	r := bytes.NewReader(b[3:])
+	addr, err := readAddr(r)
+	if err != nil {
+		return nil, "", ErrBadUDPHeader
+	}
+	return b[len(b)-r.Len():], addr, nil
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	SOCKS5_VERSION       = 0x05             // SOCKS 协议版本（RFC 1928）
+	SOCKS5_AUTH_VERSION  = 0x01             // 用户名/密码认证的子协商版本（RFC 1929）
+	SOCKS5_MAX_UDP_HEAD  = 4 + 1 + 255 + 2  // UDP 数据报头的最大长度（域名地址）
+	MAX_UDP_PACKET       = 64 * 1024        // UDP 数据报的最大长度
+	HANDSHAKE_TIMEOUT    = 10 * time.Second // 与代理协商的超时时间（ctx 没有截止时间时）
+	HTTP_PROXY_AUTH_HEAD = "Proxy-Authorization"
+)
+
+const ( // SOCKS5 认证方式
+	socks5AuthNone     = 0x00 // 不需要认证
+	socks5AuthPassword = 0x02 // 用户名/密码
+	socks5AuthNoAccept = 0xFF // 没有可接受的方式
+)
+
+const ( // SOCKS5 命令
+	socks5CmdConnect      = 0x01
+	socks5CmdUDPAssociate = 0x03
+)
+
+const ( // SOCKS5 地址类型
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+const ( // SOCKS5 响应码
+	socks5Succeeded        = 0x00
+	socks5GeneralFailure   = 0x01
+	socks5ConnRefused      = 0x05
+	socks5CmdNotSupported  = 0x07
+	socks5AddrNotSupported = 0x08
+)
+
+type Dialer interface { // 建立出站连接的方式（与 net.Dialer 的 DialContext 相同）
+	// network 为 "tcp" 或 "udp"（及其 4/6 后缀），addr 为 host:port
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+var Direct Dialer = &net.Dialer{} // 直接连接
+
+var (
+	ErrUDPNotSupported  = errors.New("proxy does not support udp")        // 代理不支持 UDP（如 HTTP CONNECT）
+	ErrNetwork          = errors.New("proxy unsupported network")         // 不支持的网络类型
+	ErrAuthFailed       = errors.New("proxy authentication failed")       // 用户名或密码错误
+	ErrNoAcceptableAuth = errors.New("proxy no acceptable auth method")   // 代理不接受本地提供的认证方式
+	ErrBadVersion       = errors.New("proxy unexpected protocol version") // 代理的响应版本错误
+	ErrAddrType         = errors.New("proxy unsupported address type")    // 地址类型错误
+	ErrBadUDPHeader     = errors.New("proxy malformed udp header")        // UDP 数据报头格式错误
+	ErrFragmented       = errors.New("proxy fragmented udp datagram")     // 不支持分片的 UDP 数据报
+	ErrUnknownScheme    = errors.New("proxy unknown url scheme")          // 代理地址的协议未知
+	ErrRefused          = errors.New("proxy refused the request")         // 代理拒绝了请求（响应码见错误信息）
+)
@@ -1,6 +1,9 @@
 package torrent
 
-import "strconv"
+import (
+	"math/bits"
+	"strconv"
+)
 
 type Bitfield []byte // 其中下载器已发送的每个索引都设置为 1，其余设置为 0。尚未有任何内容的下载器可以跳过“位字段”消息。位字段的第一个字节对应于从高到低位依次为 0-7 的索引。下一个字节对应于 8-15，等等。末尾的空位被设置为 0。
 
@@ -24,6 +27,15 @@ func (field Bitfield) SetPiece(index int) {
 	field[byteIndex] |= 1 << uint(7-offset)
 }
 
+// 拥有的片段数量
+func (field Bitfield) count() int {
+	n := 0
+	for _, b := range field {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
+
 // 将拥有的片段转换为字符串
 func (field Bitfield) String() string {
 	str := "piece# "
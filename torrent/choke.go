@@ -0,0 +1,218 @@
+package torrent
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+type choker struct { // 阻塞算法（BEP 3）的状态
+	mu         sync.Mutex
+	running    bool                  // 定时协程是否在运行
+	rounds     int                   // 已经进行的轮数
+	optimistic *PeerConn             // 当前乐观解除阻塞的对端
+	last       time.Time             // 上一轮的时间
+	prev       map[*PeerConn]traffic // 上一轮时各连接的流量
+}
+
+type traffic struct { // 连接的累计流量
+	down uint64 // 下载字节数
+	up   uint64 // 上传字节数
+}
+
+type PeerState struct { // 与对端连接的阻塞与感兴趣状态
+	Peer           PeerInfo          // 对端信息
+	PeerId         [PEER_ID_LEN]byte // 对端的 Peer ID
+	Client         PeerClient        // 从对端 Peer ID 解析出的客户端
+	AmChoking      bool              // 本地阻塞对端
+	AmInterested   bool              // 本地对对端感兴趣
+	PeerChoking    bool              // 对端阻塞本地
+	PeerInterested bool              // 对端对本地感兴趣
+	Optimistic     bool              // 对端处于乐观解除阻塞
+	Snubbed        bool              // 对端长时间没有向本地发送数据
+	DownloadRate   uint64            // 下载速度（字节/秒）
+	UploadRate     uint64            // 上传速度（字节/秒）
+}
+
+// 上传槽位数量
+func (t *TorrentTask) uploadSlots() int {
+	if t.UploadSlots > 0 {
+		return t.UploadSlots
+	}
+	return DEFAULT_UPLOAD_SLOTS
+}
+
+// 对端是否 snub 本地：本地感兴趣，但对端长时间没有发送数据
+func (c *PeerConn) snubbed(now time.Time) bool {
+	return c.amInterested.Load() && now.Sub(time.Unix(0, c.lastPiece.Load())) > SNUB_TIMEOUT
+}
+
+// 所有连接当前的状态
+func (t *TorrentTask) PeerStates() []PeerState {
+	t.choker.mu.Lock()
+	optimistic := t.choker.optimistic
+	t.choker.mu.Unlock()
+
+	now := time.Now()
+	t.rwm.RLock()
+	defer t.rwm.RUnlock()
+	states := make([]PeerState, 0, len(t.conns))
+	for c := range t.conns {
+		states = append(states, PeerState{
+			Peer:           c.peer,
+			PeerId:         c.remoteId,
+			Client:         c.client,
+			AmChoking:      c.amChoking.Load(),
+			AmInterested:   c.amInterested.Load(),
+			PeerChoking:    c.peerChoking.Load(),
+			PeerInterested: c.peerInterested.Load(),
+			Optimistic:     c == optimistic,
+			Snubbed:        c.snubbed(now),
+			DownloadRate:   c.downRate.Load(),
+			UploadRate:     c.upRate.Load(),
+		})
+	}
+	return states
+}
+
+// 按需启动定时的阻塞算法（没有连接时协程退出）
+func (t *TorrentTask) startChoker() {
+	t.choker.mu.Lock()
+	defer t.choker.mu.Unlock()
+	if t.choker.running {
+		return
+	}
+	t.choker.running = true
+	go t.chokeRoutine()
+}
+
+// 每 CHOKE_INTERVAL 重新选择解除阻塞的对端，直到没有连接
+func (t *TorrentTask) chokeRoutine() {
+	ticker := time.NewTicker(CHOKE_INTERVAL)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		t.choker.mu.Lock()
+		t.rwm.RLock()
+		empty := len(t.conns) == 0
+		t.rwm.RUnlock()
+		if empty {
+			t.choker.running = false
+			t.choker.optimistic = nil
+			t.choker.mu.Unlock()
+			return
+		}
+		t.choker.mu.Unlock()
+		t.rechoke(now)
+	}
+}
+
+// 重新选择解除阻塞的对端：下载时按下载速度、做种时按上传速度选出前 N 个感兴趣的对端，
+// 另外每 OPTIMISTIC_UNCHOKE_ROUNDS 轮随机轮换一个乐观解除阻塞的对端；snub 本地的对端不占用常规槽位
+func (t *TorrentTask) rechoke(now time.Time) {
+	c := &t.choker
+	c.mu.Lock()
+
+	t.rwm.RLock()
+	uploaders := make(map[*PeerConn]*uploader, len(t.conns))
+	for conn, u := range t.conns {
+		uploaders[conn] = u
+	}
+	seeding := t.ctx != nil && t.ctx.isDone()
+	t.rwm.RUnlock()
+
+	// 计算各连接在上一轮以来的速度
+	elapsed := now.Sub(c.last).Seconds()
+	if c.last.IsZero() || elapsed <= 0 {
+		elapsed = CHOKE_INTERVAL.Seconds()
+	}
+	prev := c.prev
+	c.prev = make(map[*PeerConn]traffic, len(uploaders))
+	c.last = now
+	for conn := range uploaders {
+		cur := traffic{conn.downloaded.Load(), conn.uploaded.Load()}
+		p := prev[conn]
+		conn.downRate.Store(uint64(float64(cur.down-p.down) / elapsed))
+		conn.upRate.Store(uint64(float64(cur.up-p.up) / elapsed))
+		c.prev[conn] = cur
+	}
+
+	// 常规槽位
+	candidates := make([]*PeerConn, 0, len(uploaders))
+	for conn := range uploaders {
+		if conn.peerInterested.Load() && !conn.snubbed(now) {
+			candidates = append(candidates, conn)
+		}
+	}
+	rate := func(conn *PeerConn) uint64 {
+		if seeding {
+			return conn.upRate.Load()
+		}
+		return conn.downRate.Load()
+	}
+	sort.Slice(candidates, func(i, j int) bool { return rate(candidates[i]) > rate(candidates[j]) })
+	unchoke := make(map[*PeerConn]struct{}, t.uploadSlots()+1)
+	for _, conn := range candidates[:min(len(candidates), t.uploadSlots())] {
+		unchoke[conn] = struct{}{}
+	}
+
+	// 乐观解除阻塞
+	keep := false
+	if opt := c.optimistic; opt != nil && c.rounds%OPTIMISTIC_UNCHOKE_ROUNDS != 0 {
+		_, connected := uploaders[opt]
+		_, regular := unchoke[opt]
+		keep = connected && !regular && opt.peerInterested.Load()
+	}
+	if !keep {
+		c.optimistic = nil
+		var choked []*PeerConn
+		for conn := range uploaders {
+			if _, ok := unchoke[conn]; !ok && conn.peerInterested.Load() {
+				choked = append(choked, conn)
+			}
+		}
+		if len(choked) > 0 {
+			c.optimistic = choked[rand.Intn(len(choked))]
+		}
+	}
+	c.rounds++
+	if c.optimistic != nil {
+		unchoke[c.optimistic] = struct{}{}
+	}
+
+	var unchoking, choking []*uploader
+	for conn, u := range uploaders {
+		if _, ok := unchoke[conn]; ok {
+			unchoking = append(unchoking, u)
+		} else {
+			choking = append(choking, u)
+		}
+	}
+	c.mu.Unlock()
+
+	// 释放锁后再发送：不读取的对端只会阻塞本轮，写超时后连接被关闭
+	for _, u := range choking {
+		u.choke()
+	}
+	for _, u := range unchoking {
+		u.unchoke()
+	}
+}
+
+// 对端表示感兴趣时，若仍有空闲的常规槽位则立即解除阻塞
+func (t *TorrentTask) unchokeIfFree(u *uploader) error {
+	t.choker.mu.Lock()
+	t.rwm.RLock()
+	used := 0
+	for conn := range t.conns {
+		if !conn.amChoking.Load() && conn != t.choker.optimistic {
+			used++
+		}
+	}
+	t.rwm.RUnlock()
+	t.choker.mu.Unlock()
+	if used >= t.uploadSlots() {
+		return nil
+	}
+	return u.unchoke() // 不持有锁发送；同时到达的请求可能多占用一个槽位，下一轮 rechoke 时纠正
+}
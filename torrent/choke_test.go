@@ -0,0 +1,137 @@
+package torrent_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+// 读取消息直到收到 id 类型的消息
+func readUntil(t *testing.T, pc *torrent.PeerConn, id torrent.MsgId) *torrent.PeerMsg {
+	for {
+		msg, err := pc.ReadMsg()
+		require.NoError(t, err)
+		if msg != nil && msg.Id == id {
+			return msg
+		}
+	}
+}
+
+func TestChoker(t *testing.T) {
+	seeder, data := newTestTask(t, "choke", 100000, 40000)
+	seeder.UploadSlots = 1
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	pcs := make([]*torrent.PeerConn, 3)
+	states := func() map[string]torrent.PeerState {
+		m := make(map[string]torrent.PeerState)
+		for _, s := range seeder.PeerStates() {
+			m[s.Peer.GetConnAddr()] = s
+		}
+		return m
+	}
+	state := func(i int) torrent.PeerState {
+		return states()[pcs[i].LocalAddr().String()]
+	}
+	for i := range pcs {
+		conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'c', byte('0' + i)})
+		require.NoError(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		pcs[i] = &torrent.PeerConn{Conn: conn}
+		readUntil(t, pcs[i], torrent.MsgHaveAll)
+	}
+	require.Eventually(t, func() bool { return len(seeder.PeerStates()) == 3 }, time.Second, 10*time.Millisecond)
+
+	// 有空闲槽位时立即解除阻塞，并下载一个块
+	_, err := pcs[0].WriteMsg(&torrent.PeerMsg{Id: torrent.MsgInterested})
+	require.NoError(t, err)
+	readUntil(t, pcs[0], torrent.MsgUnchoke)
+	_, err = pcs[0].WriteMsg(torrent.NewRequestMsg(0, 0, torrent.BLOCK_SIZE))
+	require.NoError(t, err)
+	readUntil(t, pcs[0], torrent.MsgPiece)
+
+	// 槽位已满，后来者保持阻塞
+	for _, pc := range pcs[1:] {
+		_, err = pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgInterested})
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		return state(1).PeerInterested && state(2).PeerInterested
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, state(1).AmChoking)
+	require.True(t, state(2).AmChoking)
+
+	// 上传速度最快的对端占用常规槽位，另一个对端被乐观解除阻塞
+	seeder.Rechoke()
+	require.False(t, state(0).AmChoking)
+	require.False(t, state(0).Optimistic)
+	require.NotZero(t, state(0).UploadRate)
+	unchoked, optimistic := 0, 0
+	for i := 1; i < 3; i++ {
+		if s := state(i); !s.AmChoking {
+			unchoked++
+			require.True(t, s.Optimistic)
+			readUntil(t, pcs[i], torrent.MsgUnchoke)
+		}
+		if state(i).Optimistic {
+			optimistic++
+		}
+	}
+	require.Equal(t, 1, unchoked)
+	require.Equal(t, 1, optimistic)
+
+	// 不再感兴趣的对端被阻塞
+	_, err = pcs[0].WriteMsg(&torrent.PeerMsg{Id: torrent.MsgNotInterest})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return !state(0).PeerInterested }, time.Second, 10*time.Millisecond)
+	seeder.Rechoke()
+	require.True(t, state(0).AmChoking)
+	readUntil(t, pcs[0], torrent.MsgChoke)
+}
+
+func TestStuckPeer(t *testing.T) {
+	seeder, data := newTestTask(t, "stuck", 1<<20, 1<<18)
+	seeder.Manager = &torrent.ConnManager{WriteTimeout: 300 * time.Millisecond}
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 对端请求大量的块但从不读取
+	conn, err := net.DialTCP("tcp", nil, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadBuffer(4096)
+	require.NoError(t, torrent.NewHandShakeMsg(seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'s'}).WriteHandShakeMsg(conn))
+	_, err = torrent.ReadHandshake(conn)
+	require.NoError(t, err)
+	pc := &torrent.PeerConn{Conn: conn}
+	_, err = pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgInterested})
+	require.NoError(t, err)
+	go func() {
+		for i := 0; ; i++ {
+			begin := i % (len(data) / torrent.BLOCK_SIZE) * torrent.BLOCK_SIZE
+			if _, err := pc.WriteMsg(torrent.NewRequestMsg(begin/seeder.PieceLen, begin%seeder.PieceLen, torrent.BLOCK_SIZE)); err != nil {
+				return
+			}
+		}
+	}()
+	require.Eventually(t, func() bool { return len(seeder.PeerStates()) == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	// 阻塞算法不会因为该对端一直阻塞，写超时后连接被关闭
+	rechoked := make(chan struct{})
+	go func() {
+		for range 5 {
+			seeder.Rechoke()
+		}
+		close(rechoked)
+	}()
+	select {
+	case <-rechoked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rechoke blocked by a peer that does not read")
+	}
+	require.Eventually(t, func() bool { return len(seeder.PeerStates()) == 0 }, 5*time.Second, 50*time.Millisecond)
+}
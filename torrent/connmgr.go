@@ -0,0 +1,339 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Akimio521/torrent-go/proxy"
+)
+
+type ConnManager struct { // 连接管理：多个任务共享的全局连接数量与半开连接数量限制、重连退避、IP 封禁、全局限速与出站代理
+	MaxConns     int           // 全局最大连接数量（包括半开连接，为 0 时使用 DEFAULT_MAX_CONNS）
+	MaxHalfOpen  int           // 同时进行的连接尝试的最大数量（为 0 时使用 DEFAULT_MAX_HALF_OPEN）
+	Backoff      time.Duration // 第一次重连前的等待时间，之后每次翻倍（为 0 时使用 DEFAULT_RECONNECT_BACKOFF）
+	KeepAlive    time.Duration // 发送保活消息的间隔（为 0 时使用 KEEPALIVE_INTERVAL）
+	IdleTimeout  time.Duration // 没有收到对端消息时断开连接的时间（为 0 时使用 PEER_IDLE_TIMEOUT）
+	WriteTimeout time.Duration // 一条消息没有写完时断开连接的时间（为 0 时使用 PEER_WRITE_TIMEOUT）
+
+	UploadLimit   *RateLimiter // 所有任务共享的上传限速（为 nil 时不限制）
+	DownloadLimit *RateLimiter // 所有任务共享的下载限速（为 nil 时不限制）
+
+	Proxy     proxy.Dialer // 出站连接（对端与 Tracker）使用的代理（为 nil 时直接连接）
+	ProxyOnly bool         // 只通过代理连接：拒绝直接连接，不接受对端连入，不使用 uTP
+
+	mu         sync.Mutex
+	conns      int                 // 已建立的连接数量
+	halfOpen   int                 // 正在进行的连接尝试数量
+	violations map[string]int      // 每个 IP 违反协议的次数
+	banned     map[string]struct{} // 被封禁的 IP
+	wake       chan struct{}       // 有连接数量空出时关闭并替换
+}
+
+var DefaultConnManager = &ConnManager{} // 未设置 Manager 的任务共享的连接管理
+
+type candidate struct { // 候选池中的 Peer
+	peer     PeerInfo
+	failures int       // 连续失败（连接失败或没有传输数据就断开）的次数
+	nextTry  time.Time // 下次允许连接的时间
+	active   bool      // 正在连接或已经连接
+	self     bool      // 连接到了本机（不再连接）
+}
+
+// 将错误标记为对端违反协议（多次违反协议的 IP 会被封禁），已经标记的错误原样返回
+func protocolViolation(err error) error {
+	if errors.Is(err, ErrProtocolViolation) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrProtocolViolation, err)
+}
+
+func (m *ConnManager) maxConns() int {
+	if m.MaxConns > 0 {
+		return m.MaxConns
+	}
+	return DEFAULT_MAX_CONNS
+}
+
+func (m *ConnManager) maxHalfOpen() int {
+	if m.MaxHalfOpen > 0 {
+		return m.MaxHalfOpen
+	}
+	return DEFAULT_MAX_HALF_OPEN
+}
+
+func (m *ConnManager) keepAlive() time.Duration {
+	if m.KeepAlive > 0 {
+		return m.KeepAlive
+	}
+	return KEEPALIVE_INTERVAL
+}
+
+func (m *ConnManager) idleTimeout() time.Duration {
+	if m.IdleTimeout > 0 {
+		return m.IdleTimeout
+	}
+	return PEER_IDLE_TIMEOUT
+}
+
+func (m *ConnManager) writeTimeout() time.Duration {
+	if m.WriteTimeout > 0 {
+		return m.WriteTimeout
+	}
+	return PEER_WRITE_TIMEOUT
+}
+
+// 按代理设置建立出站连接（network 为 "tcp" 或 "udp"）
+func (m *ConnManager) dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.dialContext(ctx, network, addr)
+}
+
+func (m *ConnManager) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch {
+	case m.Proxy != nil:
+		return m.Proxy.DialContext(ctx, network, addr)
+	case m.ProxyOnly:
+		return nil, ErrProxyRequired
+	}
+	return proxy.Direct.DialContext(ctx, network, addr)
+}
+
+// 通过 TCP 连接对端
+func (m *ConnManager) dialTCP(addr string) (net.Conn, error) {
+	return m.dial("tcp", addr, 5*time.Second)
+}
+
+// 第 failures 次失败后的重连等待时间
+func (m *ConnManager) backoff(failures int) time.Duration {
+	d := m.Backoff
+	if d <= 0 {
+		d = DEFAULT_RECONNECT_BACKOFF
+	}
+	for i := 1; i < failures && d < MAX_RECONNECT_BACKOFF; i++ {
+		d *= 2
+	}
+	return min(d, MAX_RECONNECT_BACKOFF)
+}
+
+// 唤醒等待连接数量空出的任务（需持有锁）
+func (m *ConnManager) notify() {
+	if m.wake != nil {
+		close(m.wake)
+		m.wake = nil
+	}
+}
+
+// 用于等待连接数量空出的通道
+func (m *ConnManager) waiter() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wake == nil {
+		m.wake = make(chan struct{})
+	}
+	return m.wake
+}
+
+// 开始一次连接尝试，超出限制时返回 false
+func (m *ConnManager) startDial() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.halfOpen >= m.maxHalfOpen() || m.conns+m.halfOpen >= m.maxConns() {
+		return false
+	}
+	m.halfOpen++
+	return true
+}
+
+// 连接尝试结束，ok 为是否成功建立了连接
+func (m *ConnManager) endDial(ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.halfOpen--
+	if ok {
+		m.conns++
+	} else {
+		m.notify()
+	}
+}
+
+// 接受对端连入的连接，超出限制时返回 false
+func (m *ConnManager) openConn() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conns+m.halfOpen >= m.maxConns() {
+		return false
+	}
+	m.conns++
+	return true
+}
+
+// 连接断开，err 为断开的原因
+func (m *ConnManager) closeConn(ip net.IP, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conns--
+	if errors.Is(err, ErrProtocolViolation) {
+		if m.violations == nil {
+			m.violations = make(map[string]int)
+		}
+		if m.violations[ip.String()]++; m.violations[ip.String()] >= MAX_PROTOCOL_VIOLATIONS {
+			m.ban(ip)
+		}
+	}
+	m.notify()
+}
+
+// 封禁 IP，之后不再连接该 IP 且拒绝其连入
+func (m *ConnManager) Ban(ip net.IP) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ban(ip)
+}
+
+func (m *ConnManager) ban(ip net.IP) {
+	if m.banned == nil {
+		m.banned = make(map[string]struct{})
+	}
+	m.banned[ip.String()] = struct{}{}
+}
+
+// IP 是否已被封禁
+func (m *ConnManager) IsBanned(ip net.IP) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.banned[ip.String()]
+	return ok
+}
+
+// 当前的连接数量与半开连接数量
+func (m *ConnManager) Stats() (conns, halfOpen int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conns, m.halfOpen
+}
+
+func (t *TorrentTask) manager() *ConnManager {
+	if t.Manager != nil {
+		return t.Manager
+	}
+	return DefaultConnManager
+}
+
+func (t *TorrentTask) maxConns() int {
+	if t.MaxConns > 0 {
+		return t.MaxConns
+	}
+	return DEFAULT_TORRENT_CONNS
+}
+
+// 将 PeerList 中的 Peer 加入候选池（需持有锁）
+func (t *TorrentTask) initPool() {
+	if t.pool != nil {
+		return
+	}
+	t.pool = make(map[string]*candidate, len(t.PeerList))
+	for _, peer := range t.PeerList {
+		t.pool[peer.GetConnAddr()] = &candidate{peer: peer}
+	}
+	t.poolSignal = make(chan struct{}, 1)
+}
+
+// 通知连接协程候选池或连接数量发生了变化
+func (t *TorrentTask) signalPool() {
+	select {
+	case t.poolSignal <- struct{}{}:
+	default:
+	}
+}
+
+// 按连接限制从候选池中连接 Peer，直到下载完成
+func (t *TorrentTask) connectRoutine(p *picker, ctx *Context) {
+	m := t.manager()
+	for {
+		wake := m.waiter()
+		var timeout <-chan time.Time
+		if next := t.dialCandidates(p, ctx); !next.IsZero() {
+			timeout = time.After(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.poolSignal:
+		case <-wake:
+		case <-timeout:
+		}
+	}
+}
+
+// 按来源的优先级连接候选池中可以连接的 Peer，返回最早的退避结束时间（没有退避中的 Peer 时为零值）
+func (t *TorrentTask) dialCandidates(p *picker, ctx *Context) time.Time {
+	m := t.manager()
+	now := time.Now()
+	t.rwm.Lock()
+	defer t.rwm.Unlock()
+	var ready []*candidate
+	var next time.Time
+	for _, c := range t.pool {
+		switch {
+		case c.active || c.self || t.incoming[c.peer.IP.String()] > 0 || m.IsBanned(c.peer.IP):
+		case c.nextTry.After(now):
+			if next.IsZero() || c.nextTry.Before(next) {
+				next = c.nextTry
+			}
+		default:
+			ready = append(ready, c)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		if a, b := ready[i].peer.Source.Priority(), ready[j].peer.Source.Priority(); a != b {
+			return a > b
+		}
+		return ready[i].failures < ready[j].failures
+	})
+	for _, c := range ready {
+		if len(t.conns)+t.pending >= t.maxConns() || !m.startDial() {
+			break
+		}
+		c.active = true
+		t.pending++
+		go t.dial(c, p, ctx)
+	}
+	return next
+}
+
+// 连接候选的 Peer 并下载，连接失败或断开后按退避时间安排重连
+func (t *TorrentTask) dial(c *candidate, p *picker, ctx *Context) {
+	m := t.manager()
+	conn, err := t.connect(c.peer)
+	m.endDial(err == nil)
+	transferred := false
+	if err != nil {
+		t.rwm.Lock()
+		t.pending-- // 连接成功时由 serveConn 释放名额
+		t.rwm.Unlock()
+		ctx.pushErr(fmt.Errorf("connect peer %s failed: %s", c.peer.IP.String(), err.Error()))
+	} else {
+		err = t.serveConn(conn, p, ctx)
+		m.closeConn(c.peer.IP, err)
+		transferred = conn.downloaded.Load() > 0 || conn.uploaded.Load() > 0
+	}
+
+	t.rwm.Lock()
+	if errors.Is(err, ErrSelfConnection) { // 本机的地址（如 Tracker 返回的自身地址），保留在候选池中以免再次加入
+		c.self = true
+	}
+	if transferred { // 正常传输过数据的 Peer 重新开始计算退避时间
+		c.failures = 0
+	}
+	c.failures++
+	c.nextTry = time.Now().Add(m.backoff(c.failures))
+	c.active = false
+	t.rwm.Unlock()
+	t.signalPool()
+}
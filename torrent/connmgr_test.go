@@ -0,0 +1,204 @@
+package torrent_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	seeder, data := newTestTask(t, "backoff", 100000, 40000)
+	seeder.PeerId = [torrent.PEER_ID_LEN]byte{'s'}
+	seeder.Storage = &memStorage{data: data}
+	_, err := seeder.CheckStorage()
+	require.NoError(t, err)
+	seeder.Download()
+
+	// 做种者稍后才开始监听，第一次连接失败
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	manager := &torrent.ConnManager{Backoff: 50 * time.Millisecond}
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+		Manager:  manager,
+	}
+	ctx := leecher.Download()
+	select {
+	case err := <-ctx.GetErr():
+		require.ErrorContains(t, err, "connect peer")
+	case <-time.After(3 * time.Second):
+		t.Fatal("connect should fail")
+	}
+
+	ln, err = net.Listen("tcp", addr.String())
+	require.NoError(t, err)
+	listener := torrent.NewListener(ln)
+	listener.Register(seeder)
+	go listener.Serve()
+	t.Cleanup(func() { listener.Close() })
+
+	for range ctx.GetResult() {
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+	conns, halfOpen := manager.Stats()
+	require.Equal(t, 1, conns)
+	require.Equal(t, 0, halfOpen)
+}
+
+func TestConnLimits(t *testing.T) {
+	seeder, data := newTestTask(t, "limits", 100000, 40000)
+	seeder.MaxConns = 1
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 每个任务的连接数量限制
+	conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'a'})
+	require.NoError(t, err)
+	msg, err := (&torrent.PeerConn{Conn: conn}).ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, torrent.MsgHaveAll, msg.Id)
+	conn, _, err = dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'b'})
+	require.NoError(t, err)
+	_, err = (&torrent.PeerConn{Conn: conn}).ReadMsg()
+	require.ErrorIs(t, err, io.EOF)
+
+	// 半开连接数量限制：对端接受 TCP 连接但不响应握手
+	var peers []torrent.PeerInfo
+	for i := 0; i < 3; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		addr := ln.Addr().(*net.TCPAddr)
+		peers = append(peers, torrent.PeerInfo{IP: addr.IP, Port: uint16(addr.Port)})
+	}
+	manager := &torrent.ConnManager{MaxHalfOpen: 1}
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: peers,
+		Manager:  manager,
+	}
+	leecher.Download()
+	require.Eventually(t, func() bool {
+		_, halfOpen := manager.Stats()
+		return halfOpen == 1
+	}, time.Second, 10*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		_, halfOpen := manager.Stats()
+		require.LessOrEqual(t, halfOpen, 1)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConcurrentIncoming(t *testing.T) {
+	seeder, data := newTestTask(t, "incoming", 100000, 40000)
+	seeder.MaxConns = 2
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 同时连入的连接不会超过连接数量限制
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{byte('a' + i)})
+			if err != nil {
+				return
+			}
+			if _, err = (&torrent.PeerConn{Conn: conn}).ReadMsg(); err == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 2, accepted.Load())
+}
+
+func TestIncomingCandidate(t *testing.T) {
+	task, _ := newTestTask(t, "incoming candidate", 100000, 40000)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := torrent.NewListener(ln)
+	listener.Register(task)
+	go listener.Serve()
+	t.Cleanup(func() { listener.Close() })
+	task.Download()
+
+	// 候选的地址，记录本地是否主动连接了它
+	candidate, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer candidate.Close()
+	dialed := make(chan struct{}, 1)
+	go func() {
+		conn, err := candidate.Accept()
+		if err == nil {
+			conn.Close()
+			dialed <- struct{}{}
+		}
+	}()
+
+	// 同一 IP 的对端已经连入时不再主动连接该 IP 上的候选，连接断开后才会连接
+	conn, _, err := dialHandshake(t, ln.Addr(), task.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.NoError(t, err)
+	_, err = (&torrent.PeerConn{Conn: conn}).ReadMsg()
+	require.NoError(t, err)
+	cAddr := candidate.Addr().(*net.TCPAddr)
+	task.AddPeers(torrent.PeerInfo{IP: cAddr.IP, Port: uint16(cAddr.Port)})
+	select {
+	case <-dialed:
+		t.Fatal("candidate with an incoming connection should not be dialed")
+	case <-time.After(300 * time.Millisecond):
+	}
+	conn.Close()
+	select {
+	case <-dialed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("candidate should be dialed after the incoming connection closed")
+	}
+}
+
+func TestBanAfterViolations(t *testing.T) {
+	seeder, data := newTestTask(t, "ban", 100000, 40000)
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	for i := 0; i < torrent.MAX_PROTOCOL_VIOLATIONS; i++ {
+		conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+		require.NoError(t, err)
+		pc := &torrent.PeerConn{Conn: conn}
+		_, err = pc.WriteMsg(torrent.NewRequestMsg(len(seeder.PieceSHA), 0, torrent.BLOCK_SIZE)) // 越界的请求
+		require.NoError(t, err)
+		for err == nil {
+			_, err = pc.ReadMsg()
+		}
+	}
+	require.Eventually(t, func() bool { return seeder.Manager.IsBanned(peer.IP) }, time.Second, 10*time.Millisecond)
+
+	// 被封禁的 IP 连入后立即被断开
+	conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.NoError(t, err)
+	_, err = (&torrent.PeerConn{Conn: conn}).ReadMsg()
+	require.ErrorIs(t, err, io.EOF)
+}
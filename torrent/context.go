@@ -15,6 +15,8 @@ type Context struct {
 	peerInfos     []PeerInfo        // 正在下载的 Peer 列表
 	currentBytes  uint64            // 当前已成功已下载大小
 	currentPieces uint64            // 当前已下载 Piece 数量
+	endgame       atomic.Bool       // 是否处于终局模式
+	finishOnce    sync.Once         // 保证任务只结束一次
 }
 
 // 生成一个新的 Context
@@ -56,9 +58,35 @@ func (ctx *Context) GetPeerInfos() []PeerInfo {
 	return ctx.peerInfos
 }
 
+// 记录正在下载的 Peer
+func (ctx *Context) addPeer(peer PeerInfo) {
+	ctx.rwm.Lock()
+	defer ctx.rwm.Unlock()
+	ctx.peerInfos = append(ctx.peerInfos, peer)
+}
+
+// 移除断开连接的 Peer
+func (ctx *Context) removePeer(peer PeerInfo) {
+	ctx.rwm.Lock()
+	defer ctx.rwm.Unlock()
+	addr := peer.GetConnAddr()
+	peerInfos := make([]PeerInfo, 0, len(ctx.peerInfos))
+	for _, p := range ctx.peerInfos {
+		if p.GetConnAddr() != addr {
+			peerInfos = append(peerInfos, p)
+		}
+	}
+	ctx.peerInfos = peerInfos
+}
+
 // 获取正在下载进度（已下载大小和已下载片数）
 func (ctx *Context) GetProcess() (uint64, uint64) {
-	return atomic.LoadUint64(&ctx.currentBytes), atomic.LoadUint64(&ctx.currentBytes)
+	return atomic.LoadUint64(&ctx.currentBytes), atomic.LoadUint64(&ctx.currentPieces)
+}
+
+// 是否处于终局模式（所有未收到的块都已被请求，剩余的块同时向多个对端请求）
+func (ctx *Context) InEndgame() bool {
+	return ctx.endgame.Load()
 }
 
 func (ctx *Context) GetResult() <-chan *PieceResult {
@@ -70,11 +98,30 @@ func (ctx *Context) GetErr() <-chan error {
 	return ctx.errChan
 }
 
-// 结束任务
+// 结束任务（错误通道不会被关闭，以免仍在运行的 Peer 协程向其写入时 panic）
 func (ctx *Context) Finish() {
-	close(ctx.doneChan)
-	close(ctx.resultChan)
-	close(ctx.errChan)
+	ctx.finishOnce.Do(func() {
+		close(ctx.doneChan)
+		close(ctx.resultChan)
+	})
+}
+
+// 任务是否已经结束
+func (ctx *Context) isDone() bool {
+	select {
+	case <-ctx.doneChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// 发送错误信息，通道已满时丢弃，避免阻塞下载
+func (ctx *Context) pushErr(err error) {
+	select {
+	case ctx.errChan <- err:
+	default:
+	}
 }
 
 var _ context.Context = (*Context)(nil)
@@ -0,0 +1,33 @@
+package torrent
+
+import (
+	"net"
+	"time"
+)
+
+// 立即进行一轮阻塞算法
+func (t *TorrentTask) Rechoke() {
+	t.rechoke(time.Now())
+}
+
+// 启动写协程
+func (c *PeerConn) StartWriter(done <-chan struct{}, keepAlive time.Duration) {
+	c.startWriter(done, keepAlive, time.Minute)
+}
+
+// 新建拥有 field 的连接（种子共有 pieces 个 Piece）
+func NewFieldConn(conn net.Conn, peer PeerInfo, field Bitfield, pieces int) *PeerConn {
+	c := &PeerConn{Conn: conn, peer: peer, pieces: pieces}
+	c.setField(field)
+	return c
+}
+
+// 在 PEX 中告知其他 Peer 的标志
+func (c *PeerConn) PexFlags() PexFlags {
+	return c.pexFlags()
+}
+
+// 处理对端的消息
+func (c *PeerConn) HandleMsg(msg *PeerMsg) error {
+	return c.handleMsg(msg)
+}
@@ -0,0 +1,172 @@
+package torrent
+
+import (
+	"bytes"
+
+	"github.com/Akimio521/torrent-go/bencode"
+)
+
+type ExtHandshake struct { // 扩展协议握手（BEP 10）
+	M    map[string]uint8 // 扩展名 -> 扩展消息 ID（0 表示不支持）
+	P    int              // 监听端口（为 0 时不发送）
+	V    string           // 客户端名称与版本
+	Reqq int              // 允许的未完成请求数量（为 0 时不发送）
+}
+
+// 编码为扩展握手消息
+func (h *ExtHandshake) Encode() *PeerMsg {
+	m := make(map[string]*bencode.BObject, len(h.M))
+	for name, id := range h.M {
+		m[name] = bencode.GetBObject(int(id))
+	}
+	dict := map[string]*bencode.BObject{
+		"m": bencode.GetBObject(m),
+	}
+	if h.P > 0 {
+		dict["p"] = bencode.GetBObject(h.P)
+	}
+	if h.V != "" {
+		dict["v"] = bencode.GetBObject(h.V)
+	}
+	if h.Reqq > 0 {
+		dict["reqq"] = bencode.GetBObject(h.Reqq)
+	}
+	return newExtMsg(EXT_HANDSHAKE_ID, dict)
+}
+
+// 解析扩展握手消息的内容（不含扩展消息 ID）
+func ParseExtHandshake(payload []byte) (*ExtHandshake, error) {
+	dict, err := parseExtDict(payload)
+	if err != nil {
+		return nil, err
+	}
+	h := &ExtHandshake{M: make(map[string]uint8)}
+	if o, ok := dict["m"]; ok {
+		var m map[string]*bencode.BObject
+		if err := bencode.GetValue(o, &m); err != nil {
+			return nil, ErrMalformedExtMsg
+		}
+		for name, o := range m {
+			var id int
+			if bencode.GetValue(o, &id) == nil && id >= 0 && id <= 0xFF {
+				h.M[name] = uint8(id)
+			}
+		}
+	}
+	if o, ok := dict["p"]; ok {
+		bencode.GetValue(o, &h.P)
+	}
+	if o, ok := dict["v"]; ok {
+		bencode.GetValue(o, &h.V)
+	}
+	if o, ok := dict["reqq"]; ok {
+		bencode.GetValue(o, &h.Reqq)
+	}
+	return h, nil
+}
+
+type PexMsg struct { // Peer Exchange 消息（BEP 11）
+	Added   []PeerInfo // 新连接的 Peer（Flags 随之编码）
+	Dropped []PeerInfo // 已断开的 Peer
+}
+
+// 编码为扩展消息，id 为对端为 ut_pex 分配的扩展消息 ID
+func (pm *PexMsg) Encode(id uint8) *PeerMsg {
+	added, added6 := EncodePeerInfos(pm.Added)
+	dropped, dropped6 := EncodePeerInfos(pm.Dropped)
+	var flags, flags6 []byte
+	for _, p := range pm.Added {
+		if p.IP.To4() != nil {
+			flags = append(flags, byte(p.Flags))
+		} else if p.IP.To16() != nil {
+			flags6 = append(flags6, byte(p.Flags))
+		}
+	}
+	return newExtMsg(id, map[string]*bencode.BObject{
+		"added":    bencode.GetBObject(added),
+		"added.f":  bencode.GetBObject(string(flags)),
+		"added6":   bencode.GetBObject(added6),
+		"added6.f": bencode.GetBObject(string(flags6)),
+		"dropped":  bencode.GetBObject(dropped),
+		"dropped6": bencode.GetBObject(dropped6),
+	})
+}
+
+// 解析 PEX 消息的内容（不含扩展消息 ID），Added 中的 Peer 来源记为 SourcePEX
+func ParsePexMsg(payload []byte) (*PexMsg, error) {
+	dict, err := parseExtDict(payload)
+	if err != nil {
+		return nil, err
+	}
+	pm := new(PexMsg)
+	if pm.Added, err = parsePexPeers(dict, "added", "added6"); err != nil {
+		return nil, err
+	}
+	if pm.Dropped, err = parsePexPeers(dict, "dropped", "dropped6"); err != nil {
+		return nil, err
+	}
+	// 标志与 Peer 一一对应，缺失时视为 0
+	flags, _ := extDictString(dict, "added.f")
+	flags6, _ := extDictString(dict, "added6.f")
+	i4, i6 := 0, 0
+	for i := range pm.Added {
+		pm.Added[i].Source = SourcePEX
+		if pm.Added[i].IP.To4() != nil {
+			if i4 < len(flags) {
+				pm.Added[i].Flags = PexFlags(flags[i4])
+			}
+			i4++
+		} else {
+			if i6 < len(flags6) {
+				pm.Added[i].Flags = PexFlags(flags6[i6])
+			}
+			i6++
+		}
+	}
+	return pm, nil
+}
+
+// 解析紧凑格式的 IPv4 与 IPv6 Peer 列表
+func parsePexPeers(dict map[string]*bencode.BObject, key, key6 string) ([]PeerInfo, error) {
+	tr := new(TrackerResponse)
+	tr.Peers, _ = extDictString(dict, key)
+	tr.Peers6, _ = extDictString(dict, key6)
+	peers, err := tr.ParsePeerInfos()
+	if err != nil {
+		return nil, ErrMalformedExtMsg
+	}
+	return peers, nil
+}
+
+// 构造扩展消息
+func newExtMsg(id uint8, dict map[string]*bencode.BObject) *PeerMsg {
+	buf := bytes.NewBuffer([]byte{id})
+	bencode.GetBObject(dict).Bencode(buf)
+	return &PeerMsg{MsgExtended, buf.Bytes()}
+}
+
+// 解析扩展消息中 B 编码的字典
+func parseExtDict(payload []byte) (map[string]*bencode.BObject, error) {
+	o, err := bencode.Parse(bytes.NewReader(payload))
+	if err != nil {
+		return nil, ErrMalformedExtMsg
+	}
+	var dict map[string]*bencode.BObject
+	if err = bencode.GetValue(o, &dict); err != nil {
+		return nil, ErrMalformedExtMsg
+	}
+	return dict, nil
+}
+
+// 读取字典中的字符串
+func extDictString(dict map[string]*bencode.BObject, key string) (string, bool) {
+	o, ok := dict[key]
+	if !ok {
+		return "", false
+	}
+	var s string
+	if err := bencode.GetValue(o, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,140 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// 计算对端 ip 的允许快速下载集合（BEP 6），Piece 数量不足 k 时为全部 Piece
+func AllowedFastSet(ip net.IP, infoSHA [sha1.Size]byte, numPieces, k int) []int {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	k = min(k, numPieces)
+	x := make([]byte, 0, net.IPv4len+sha1.Size)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0) // 只使用 /24 网段
+	x = append(x, infoSHA[:]...)
+
+	set := make([]int, 0, k)
+	seen := make(map[int]struct{}, k)
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := int(binary.BigEndian.Uint32(x[i*4:i*4+4]) % uint32(numPieces))
+			if _, ok := seen[index]; !ok {
+				seen[index] = struct{}{}
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
+
+func NewSuggestMsg(index int) *PeerMsg {
+	return Encode(SuggestMsg{index})
+}
+
+func NewAllowedFastMsg(index int) *PeerMsg {
+	return Encode(AllowedFastMsg{index})
+}
+
+func NewRejectMsg(index, begin, length int) *PeerMsg {
+	return Encode(RejectMsg{index, begin, length})
+}
+
+// 从 Suggest/AllowedFast 消息中读取 Piece 索引
+func (msg *PeerMsg) GetPieceIndex() (int, error) {
+	if msg.Id != MsgSuggest && msg.Id != MsgAllowedFast {
+		return 0, fmt.Errorf("expected MsgSuggest or MsgAllowedFast, got Id %d", msg.Id)
+	}
+	if len(msg.Payload) != 4 {
+		return 0, fmt.Errorf("expected payload length 4, got length %d", len(msg.Payload))
+	}
+	return int(binary.BigEndian.Uint32(msg.Payload)), nil
+}
+
+// 对端是否支持 Fast 扩展（BEP 6，本地总是支持）
+func (c *PeerConn) SupportsFast() bool {
+	return c.reserved[RESERVED_FAST_BYTE]&RESERVED_FAST_BIT != 0
+}
+
+// 处理 Fast 扩展的消息（Reject 对应的请求由下载循环处理）
+func (c *PeerConn) handleFast(m Message) error {
+	if !c.SupportsFast() {
+		return ErrUnexpectedFastMsg
+	}
+	switch m := m.(type) {
+	case EmptyMsg: // HaveAll 或 HaveNone
+		field := make(Bitfield, len(c.Field))
+		if MsgId(m) == MsgHaveAll {
+			for i := 0; i < c.pieces; i++ {
+				field.SetPiece(i)
+			}
+		}
+		c.setField(field)
+	case SuggestMsg:
+		if m.Index >= c.pieces || c.isSuggested(m.Index) {
+			return nil
+		}
+		if len(c.suggested) >= MAX_SUGGESTED_PIECES { // 丢弃最早的建议
+			c.suggested = c.suggested[1:]
+		}
+		c.suggested = append(c.suggested, m.Index)
+	case AllowedFastMsg:
+		if c.allowedFast == nil {
+			c.allowedFast = make(Bitfield, (c.pieces+7)/8)
+		}
+		c.allowedFast.SetPiece(m.Index)
+	}
+	return nil
+}
+
+// 对端是否建议下载该 Piece
+func (c *PeerConn) isSuggested(index int) bool {
+	for _, i := range c.suggested {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// 从对端的建议中移除该 Piece
+func (c *PeerConn) removeSuggested(index int) {
+	for i, s := range c.suggested {
+		if s == index {
+			c.suggested = append(c.suggested[:i], c.suggested[i+1:]...)
+			return
+		}
+	}
+}
+
+// 阻塞时对端是否允许请求该 Piece
+func (c *PeerConn) canRequest(index int) bool {
+	return !c.Choked || c.allowedFast.HasPiece(index)
+}
+
+// 握手后的第一条消息：对端支持 Fast 扩展时用 HaveAll/HaveNone 代替 Bitfield
+func (t *TorrentTask) haveMsg(conn *PeerConn) *PeerMsg {
+	have := t.Bitfield()
+	if !conn.SupportsFast() {
+		return &PeerMsg{MsgBitfield, have}
+	}
+	count := 0
+	for i := range t.PieceSHA {
+		if have.HasPiece(i) {
+			count++
+		}
+	}
+	switch count {
+	case 0:
+		return &PeerMsg{MsgHaveNone, nil}
+	case len(t.PieceSHA):
+		return &PeerMsg{MsgHaveAll, nil}
+	}
+	return &PeerMsg{MsgBitfield, have}
+}
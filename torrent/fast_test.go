@@ -0,0 +1,167 @@
+package torrent_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedFastSet(t *testing.T) {
+	var infoSHA [20]byte
+	for i := range infoSHA {
+		infoSHA[i] = 0xaa
+	}
+	ip := net.ParseIP("80.4.4.200")
+	// BEP 6 中的示例
+	require.Equal(t, []int{1059, 431, 808, 1217, 287, 376, 1188}, torrent.AllowedFastSet(ip, infoSHA, 1313, 7))
+	require.Equal(t, []int{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}, torrent.AllowedFastSet(ip, infoSHA, 1313, 9))
+	require.ElementsMatch(t, []int{0, 1, 2}, torrent.AllowedFastSet(ip, infoSHA, 3, 10))
+	require.Nil(t, torrent.AllowedFastSet(net.ParseIP("::1"), infoSHA, 1313, 7))
+}
+
+func TestFastSeeder(t *testing.T) {
+	seeder, data := newTestTask(t, "fast", 20*torrent.BLOCK_SIZE, torrent.BLOCK_SIZE)
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 不支持 Fast 扩展的对端收到 Bitfield
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	hs := torrent.NewHandShakeMsg(seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'p'})
+	hs.Reserved[torrent.RESERVED_FAST_BYTE] = 0
+	require.NoError(t, hs.WriteHandShakeMsg(conn))
+	_, err = torrent.ReadHandshake(conn)
+	require.NoError(t, err)
+	msg, err := (&torrent.PeerConn{Conn: conn}).ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, torrent.MsgBitfield, msg.Id)
+	require.Equal(t, torrent.Bitfield{0xff, 0xff, 0xf0}, torrent.Bitfield(msg.Payload))
+
+	// 支持 Fast 扩展的对端收到 HaveAll 与允许快速下载集合
+	fconn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'f'})
+	require.NoError(t, err)
+	pc := &torrent.PeerConn{Conn: fconn}
+	msg, err = pc.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, torrent.MsgHaveAll, msg.Id)
+	var allowed []int
+	for len(allowed) < torrent.ALLOWED_FAST_COUNT {
+		index, err := readUntil(t, pc, torrent.MsgAllowedFast).GetPieceIndex()
+		require.NoError(t, err)
+		allowed = append(allowed, index)
+	}
+	expected := torrent.AllowedFastSet(fconn.LocalAddr().(*net.TCPAddr).IP, seeder.InfoSHA, len(seeder.PieceSHA), torrent.ALLOWED_FAST_COUNT)
+	require.ElementsMatch(t, expected, allowed)
+
+	// 阻塞时可以请求允许快速下载的 Piece，其余请求被拒绝
+	notAllowed := 0
+	for notAllowed < len(seeder.PieceSHA) && contains(allowed, notAllowed) {
+		notAllowed++
+	}
+	_, err = pc.WriteMsg(torrent.NewRequestMsg(notAllowed, 0, torrent.BLOCK_SIZE))
+	require.NoError(t, err)
+	msg = readUntil(t, pc, torrent.MsgReject)
+	index, begin, length, err := msg.GetRequest()
+	require.NoError(t, err)
+	require.Equal(t, []int{notAllowed, 0, torrent.BLOCK_SIZE}, []int{index, begin, length})
+
+	_, err = pc.WriteMsg(torrent.NewRequestMsg(allowed[0], 0, torrent.BLOCK_SIZE))
+	require.NoError(t, err)
+	msg = readUntil(t, pc, torrent.MsgPiece)
+	begin = allowed[0] * torrent.BLOCK_SIZE
+	require.Equal(t, data[begin:begin+torrent.BLOCK_SIZE], msg.Payload[8:])
+}
+
+func contains(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFastLeecher(t *testing.T) {
+	task, data := newTestTask(t, "fast-leech", 4*torrent.BLOCK_SIZE, torrent.BLOCK_SIZE)
+	task.PeerId = [torrent.PEER_ID_LEN]byte{'l'}
+	task.Storage = &memStorage{data: make([]byte, len(data))}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+	task.PeerList = []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}}
+
+	// 模拟的对端：先建议下载 Piece 2，拒绝第一个请求，之后正常上传
+	requests := make(chan int, 16)
+	first := make(chan torrent.MsgId, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		hs, err := torrent.ReadHandshake(conn)
+		if err != nil {
+			return
+		}
+		if torrent.NewHandShakeMsg(hs.InfoSHA, [torrent.PEER_ID_LEN]byte{'s'}).WriteHandShakeMsg(conn) != nil {
+			return
+		}
+		pc := &torrent.PeerConn{Conn: conn}
+		pc.WriteMsg(torrent.NewSuggestMsg(2))
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgHaveAll})
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgUnchoke})
+		rejected := false
+		for {
+			msg, err := pc.ReadMsg()
+			if err != nil {
+				return
+			}
+			if msg == nil {
+				continue
+			}
+			select {
+			case first <- msg.Id:
+			default:
+			}
+			if msg.Id != torrent.MsgRequest {
+				continue
+			}
+			index, begin, length, _ := msg.GetRequest()
+			requests <- index
+			if !rejected {
+				rejected = true
+				pc.WriteMsg(torrent.NewRejectMsg(index, begin, length))
+				continue
+			}
+			offset := index*torrent.BLOCK_SIZE + begin
+			pc.WriteMsg(torrent.NewPieceMsg(index, begin, data[offset:offset+length]))
+		}
+	}()
+
+	ctx := task.Download()
+	go func() {
+		for range ctx.GetResult() {
+		}
+	}()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("download timeout")
+	}
+	require.Equal(t, torrent.MsgHaveNone, <-first) // 本地没有任何 Piece
+	require.Equal(t, 2, <-requests)                // 优先下载建议的 Piece
+	retried := false
+	for len(requests) > 0 && !retried {
+		retried = <-requests == 2 // 被拒绝的块重新请求
+	}
+	require.True(t, retried)
+	require.True(t, bytes.Equal(data, task.Storage.(*memStorage).data))
+}
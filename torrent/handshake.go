@@ -7,17 +7,31 @@ import (
 )
 
 type HandshakeMsg struct {
-	PreStr  string            // 协议
-	InfoSHA [sha1.Size]byte   // 种子的 info 的 SHA-1 哈希
-	PeerId  [PEER_ID_LEN]byte // 本地的 peerId
+	PreStr   string             // 协议
+	Reserved [RESERVED_LEN]byte // 保留字段（用于声明支持的扩展）
+	InfoSHA  [sha1.Size]byte    // 种子的 info 的 SHA-1 哈希
+	PeerId   [PEER_ID_LEN]byte  // 本地的 peerId
+}
+
+// 是否支持扩展协议（BEP 10）
+func (msg *HandshakeMsg) SupportsExtensions() bool {
+	return msg.Reserved[RESERVED_EXTENSION_BYTE]&RESERVED_EXTENSION_BIT != 0
+}
+
+// 是否支持 Fast 扩展（BEP 6）
+func (msg *HandshakeMsg) SupportsFast() bool {
+	return msg.Reserved[RESERVED_FAST_BYTE]&RESERVED_FAST_BIT != 0
 }
 
 func NewHandShakeMsg(infoSHA, peerId [PEER_ID_LEN]byte) *HandshakeMsg {
-	return &HandshakeMsg{
+	msg := &HandshakeMsg{
 		PreStr:  "BitTorrent protocol",
 		InfoSHA: infoSHA,
 		PeerId:  peerId,
 	}
+	msg.Reserved[RESERVED_EXTENSION_BYTE] |= RESERVED_EXTENSION_BIT
+	msg.Reserved[RESERVED_FAST_BYTE] |= RESERVED_FAST_BIT
+	return msg
 }
 
 func (msg *HandshakeMsg) WriteHandShakeMsg(w io.Writer) error {
@@ -29,7 +43,7 @@ func (msg *HandshakeMsg) WriteHandShakeMsg(w io.Writer) error {
 	if _, err := bw.WriteString(msg.PreStr); err != nil { // 协议 prestr
 		return err
 	}
-	if _, err := bw.Write(make([]byte, RESERVED_LEN)); err != nil { // 保留字段
+	if _, err := bw.Write(msg.Reserved[:]); err != nil { // 保留字段
 		return err
 	}
 	if _, err := bw.Write(msg.InfoSHA[:]); err != nil { // infoSHA
@@ -60,13 +74,16 @@ func ReadHandshake(r io.Reader) (*HandshakeMsg, error) {
 
 	var peerId [PEER_ID_LEN]byte
 	var infoSHA [sha1.Size]byte
+	var reserved [RESERVED_LEN]byte
 
+	copy(reserved[:], msgBuf[prelen:prelen+RESERVED_LEN])
 	copy(infoSHA[:], msgBuf[prelen+RESERVED_LEN:prelen+RESERVED_LEN+sha1.Size])
 	copy(peerId[:], msgBuf[prelen+RESERVED_LEN+sha1.Size:])
 
 	return &HandshakeMsg{
-		PreStr:  string(msgBuf[0:prelen]),
-		InfoSHA: infoSHA,
-		PeerId:  peerId,
+		PreStr:   string(msgBuf[0:prelen]),
+		Reserved: reserved,
+		InfoSHA:  infoSHA,
+		PeerId:   peerId,
 	}, nil
 }
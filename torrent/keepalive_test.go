@@ -0,0 +1,126 @@
+package torrent_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAliveAndIdleTimeout(t *testing.T) {
+	seeder, data := newTestTask(t, "keepalive", 100000, 40000)
+	seeder.Manager = &torrent.ConnManager{KeepAlive: 50 * time.Millisecond, IdleTimeout: 400 * time.Millisecond}
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 对端不发送任何消息：做种者空闲时发送保活消息，超时后断开连接
+	conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.NoError(t, err)
+	pc := &torrent.PeerConn{Conn: conn}
+	start := time.Now()
+	keepAlives := 0
+	for {
+		msg, err := pc.ReadMsg()
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+		if msg == nil {
+			keepAlives++
+		}
+	}
+	require.GreaterOrEqual(t, keepAlives, 3)
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestInterestManagement(t *testing.T) {
+	task, data := newTestTask(t, "interest", 2*torrent.BLOCK_SIZE, torrent.BLOCK_SIZE)
+	task.PeerId = [torrent.PEER_ID_LEN]byte{'l'}
+	task.Storage = &memStorage{data: make([]byte, len(data))}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+	task.PeerList = []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}}
+	ctx := task.Download()
+	finished := make(chan struct{})
+	go func() {
+		for range ctx.GetResult() {
+		}
+		close(finished)
+	}()
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	hs, err := torrent.ReadHandshake(conn)
+	require.NoError(t, err)
+	require.NoError(t, torrent.NewHandShakeMsg(hs.InfoSHA, [torrent.PEER_ID_LEN]byte{'s'}).WriteHandShakeMsg(conn))
+	pc := &torrent.PeerConn{Conn: conn}
+	_, err = pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgBitfield, Payload: []byte{0}})
+	require.NoError(t, err)
+	_, err = pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgUnchoke})
+	require.NoError(t, err)
+
+	// 对端没有任何 Piece 时不发送 Interested
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		msg, err := pc.ReadMsg()
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			break
+		}
+		require.NoError(t, err)
+		if msg != nil {
+			require.NotEqual(t, torrent.MsgInterested, msg.Id)
+		}
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 每次 Have 使对端拥有需要的 Piece 时重新感兴趣，下载完成后不再感兴趣
+	for index := range 2 {
+		_, err = pc.WriteMsg(torrent.NewHaveMsg(index))
+		require.NoError(t, err)
+		readUntil(t, pc, torrent.MsgInterested)
+		msg := readUntil(t, pc, torrent.MsgRequest)
+		i, begin, length, err := msg.GetRequest()
+		require.NoError(t, err)
+		require.Equal(t, index, i)
+		offset := i*torrent.BLOCK_SIZE + begin
+		_, err = pc.WriteMsg(torrent.NewPieceMsg(i, begin, data[offset:offset+length]))
+		require.NoError(t, err)
+		readUntil(t, pc, torrent.MsgNotInterest)
+	}
+	<-finished
+	require.Equal(t, data, task.Storage.(*memStorage).data)
+}
+
+func TestWriteAfterDone(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+
+	pc := &torrent.PeerConn{Conn: local}
+	done := make(chan struct{})
+	pc.StartWriter(done, time.Minute)
+	_, err := pc.WriteMsg(torrent.NewHaveMsg(1))
+	require.NoError(t, err)
+
+	// 连接不再使用后写入返回错误
+	close(done)
+	require.Eventually(t, func() bool {
+		_, err := pc.WriteMsg(torrent.NewHaveMsg(2))
+		return errors.Is(err, net.ErrClosed)
+	}, time.Second, 10*time.Millisecond)
+	for range 100 {
+		_, err = pc.WriteMsg(torrent.NewHaveMsg(3))
+		require.ErrorIs(t, err, net.ErrClosed)
+	}
+}
@@ -0,0 +1,172 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Akimio521/torrent-go/mse"
+)
+
+type Listener struct { // 接受对端连入的连接（TCP 或 uTP），并按 info_hash 交给对应的任务
+	ln     net.Listener
+	rwm    sync.RWMutex
+	tasks  map[[sha1.Size]byte]*TorrentTask // info_hash -> 任务
+	closed chan struct{}
+	once   sync.Once
+}
+
+// 在 ln 上新建监听器，需要调用 Serve 开始接受连接
+func NewListener(ln net.Listener) *Listener {
+	return &Listener{
+		ln:     ln,
+		tasks:  make(map[[sha1.Size]byte]*TorrentTask),
+		closed: make(chan struct{}),
+	}
+}
+
+// 监听地址
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// 接受请求该任务的连接
+func (l *Listener) Register(task *TorrentTask) {
+	l.rwm.Lock()
+	defer l.rwm.Unlock()
+	l.tasks[task.InfoSHA] = task
+}
+
+// 不再接受请求 infoSHA 的连接
+func (l *Listener) Unregister(infoSHA [sha1.Size]byte) {
+	l.rwm.Lock()
+	defer l.rwm.Unlock()
+	delete(l.tasks, infoSHA)
+}
+
+// 接受连接直到监听器被关闭
+func (l *Listener) Serve() error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-l.closed:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			if c, task, err := l.accept(conn); err == nil {
+				task.AddConn(c)
+			} else {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// 接受加密连接的任务的 info_hash（MSE 中的 SKEY）
+func (l *Listener) encryptedHashes() [][]byte {
+	l.rwm.RLock()
+	defer l.rwm.RUnlock()
+	skeys := make([][]byte, 0, len(l.tasks))
+	for infoSHA, task := range l.tasks {
+		if task.Encryption != EncryptionDisabled {
+			skeys = append(skeys, infoSHA[:])
+		}
+	}
+	return skeys
+}
+
+// 根据任务的加密策略选择对端提供的加密方式（优先 RC4）
+func (l *Listener) chooseCrypto(skey []byte, provide mse.CryptoMethod) mse.CryptoMethod {
+	l.rwm.RLock()
+	task, ok := l.tasks[[sha1.Size]byte(skey)]
+	l.rwm.RUnlock()
+	switch {
+	case !ok:
+		return 0
+	case provide&mse.CryptoRC4 != 0:
+		return mse.CryptoRC4
+	case provide&mse.CryptoPlaintext != 0 && task.Encryption != EncryptionRequire:
+		return mse.CryptoPlaintext
+	}
+	return 0
+}
+
+// 关闭监听器
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.ln.Close()
+}
+
+// 完成握手的接收方：按任务的加密策略完成 MSE 握手，读取对端握手，拒绝未知的 info_hash、自身的连接与只通过代理连接的任务，并回复握手
+// （对端可能没有任何 Piece 而不发送 Bitfield，因此不在此等待）
+func (l *Listener) accept(raw net.Conn) (*PeerConn, *TorrentTask, error) {
+	raw.SetDeadline(time.Now().Add(3 * time.Second))
+	defer raw.SetDeadline(time.Time{})
+
+	conn, plaintext, err := mse.Detect(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var skey []byte
+	if !plaintext {
+		if conn, skey, err = mse.Receive(conn, l.encryptedHashes(), l.chooseCrypto); err != nil {
+			return nil, nil, err
+		}
+		raw.SetDeadline(time.Now().Add(3 * time.Second)) // Receive 会清除超时
+	}
+
+	reqMsg, err := ReadHandshake(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if skey != nil && !bytes.Equal(skey, reqMsg.InfoSHA[:]) {
+		return nil, nil, ErrCheckInfoSHAFaild
+	}
+	l.rwm.RLock()
+	task, ok := l.tasks[reqMsg.InfoSHA]
+	l.rwm.RUnlock()
+	if !ok {
+		return nil, nil, ErrUnknownInfoHash
+	}
+	if plaintext && task.Encryption == EncryptionRequire {
+		return nil, nil, ErrEncryptionRequired
+	}
+	if task.manager().ProxyOnly { // 只通过代理连接时不接受连入的连接
+		return nil, nil, ErrProxyRequired
+	}
+	if err = NewHandShakeMsg(task.InfoSHA, task.PeerId).WriteHandShakeMsg(conn); err != nil {
+		return nil, nil, err
+	}
+	if isLocalPeerId(reqMsg.PeerId, task.PeerId) { // 回复握手后再断开，让连接的一方也能识别出连接到了本机
+		return nil, nil, ErrSelfConnection
+	}
+
+	peer := PeerInfo{Source: SourceIncoming}
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		peer.IP, peer.Port = addr.IP, uint16(addr.Port)
+	case *net.UDPAddr: // uTP
+		peer.IP, peer.Port = addr.IP, uint16(addr.Port)
+		peer.Flags |= PexUTP
+	}
+	c := &PeerConn{
+		Conn:     conn,
+		Choked:   true,
+		peer:     peer,
+		peerId:   task.PeerId,
+		remoteId: reqMsg.PeerId,
+		client:   ParsePeerId(reqMsg.PeerId),
+		infoSHA:  task.InfoSHA,
+		reserved: reqMsg.Reserved,
+	}
+	return c, task, nil
+}
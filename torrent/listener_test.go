@@ -0,0 +1,173 @@
+package torrent_test
+
+import (
+	"crypto/sha1"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/mse"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/Akimio521/torrent-go/utp"
+	"github.com/stretchr/testify/require"
+)
+
+// 作为对端连接到 addr 并发送握手，返回连接与本地收到的握手
+func dialHandshake(t *testing.T, addr net.Addr, infoSHA [sha1.Size]byte, peerId [torrent.PEER_ID_LEN]byte) (net.Conn, *torrent.HandshakeMsg, error) {
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	require.NoError(t, torrent.NewHandShakeMsg(infoSHA, peerId).WriteHandShakeMsg(conn))
+	msg, err := torrent.ReadHandshake(conn)
+	return conn, msg, err
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := torrent.NewListener(ln)
+	go listener.Serve()
+	t.Cleanup(func() { listener.Close() })
+
+	task := &torrent.TorrentTask{
+		InfoSHA:  sha1.Sum([]byte("listener")),
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l', 'o', 'c', 'a', 'l'},
+		PieceLen: 1,
+		FileLen:  1,
+		PieceSHA: [][sha1.Size]byte{sha1.Sum([]byte{0})},
+	}
+	listener.Register(task)
+	ctx := task.Download()
+
+	// 未知的 info_hash 与自身的连接会被拒绝
+	_, _, err = dialHandshake(t, listener.Addr(), sha1.Sum([]byte("unknown")), [torrent.PEER_ID_LEN]byte{'r'})
+	require.ErrorIs(t, err, io.EOF)
+	self, hs, err := dialHandshake(t, listener.Addr(), task.InfoSHA, task.PeerId) // 回复握手后断开，让对方识别出自身的连接
+	require.NoError(t, err)
+	require.Equal(t, task.PeerId, hs.PeerId)
+	_, err = self.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	conn, hs, err := dialHandshake(t, listener.Addr(), task.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.NoError(t, err)
+	require.Equal(t, task.InfoSHA, hs.InfoSHA)
+	require.Equal(t, task.PeerId, hs.PeerId)
+	require.True(t, hs.SupportsExtensions())
+	_, err = (&torrent.PeerConn{Conn: conn}).WriteMsg(&torrent.PeerMsg{Id: torrent.MsgBitfield, Payload: []byte{0x80}})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		peers := ctx.GetPeerInfos()
+		return len(peers) == 1 && peers[0].Source == torrent.SourceIncoming &&
+			peers[0].GetConnAddr() == conn.LocalAddr().String()
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestEncryptedConnection(t *testing.T) {
+	seeder, data := newTestTask(t, "encrypted", 100000, 40000)
+	seeder.Encryption = torrent.EncryptionRequire
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 要求加密时拒绝明文连接
+	_, _, err := dialHandshake(t, addr, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.ErrorIs(t, err, io.EOF)
+
+	// 加密连接上的握手
+	raw, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer raw.Close()
+	conn, err := mse.Initiate(raw, seeder.InfoSHA[:], mse.CryptoRC4|mse.CryptoPlaintext)
+	require.NoError(t, err)
+	require.Equal(t, mse.CryptoRC4, conn.Method)
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	require.NoError(t, torrent.NewHandShakeMsg(seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'e'}).WriteHandShakeMsg(conn))
+	hs, err := torrent.ReadHandshake(conn)
+	require.NoError(t, err)
+	require.Equal(t, seeder.PeerId, hs.PeerId)
+	msg, err := (&torrent.PeerConn{Conn: conn}).ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, torrent.MsgHaveAll, msg.Id)
+
+	// 优先加密的下载者通过加密连接下载
+	leecher := &torrent.TorrentTask{
+		FileName:   seeder.FileName,
+		FileLen:    seeder.FileLen,
+		InfoSHA:    seeder.InfoSHA,
+		PieceLen:   seeder.PieceLen,
+		PieceSHA:   seeder.PieceSHA,
+		PeerId:     [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList:   []torrent.PeerInfo{peer},
+		Storage:    &memStorage{data: make([]byte, len(data))},
+		Encryption: torrent.EncryptionPrefer,
+	}
+	ctx := leecher.Download()
+	for range ctx.GetResult() {
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+}
+
+func TestEncryptionFallback(t *testing.T) {
+	seeder, data := newTestTask(t, "fallback", 100000, 40000)
+	peer := startSeeder(t, seeder, data) // 不接受加密连接
+
+	leecher := &torrent.TorrentTask{
+		FileName:   seeder.FileName,
+		FileLen:    seeder.FileLen,
+		InfoSHA:    seeder.InfoSHA,
+		PieceLen:   seeder.PieceLen,
+		PieceSHA:   seeder.PieceSHA,
+		PeerId:     [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList:   []torrent.PeerInfo{peer},
+		Storage:    &memStorage{data: make([]byte, len(data))},
+		Encryption: torrent.EncryptionPrefer,
+	}
+	ctx := leecher.Download()
+	for range ctx.GetResult() { // 加密失败后以明文重试
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+}
+
+func TestUTPConnection(t *testing.T) {
+	seeder, data := newTestTask(t, "utp", 100000, 40000)
+	seeder.PeerId = [torrent.PEER_ID_LEN]byte{'s', 'e', 'e', 'd'}
+	seeder.Storage = &memStorage{data: data}
+	_, err := seeder.CheckStorage()
+	require.NoError(t, err)
+	seederSock, err := utp.Listen("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go seederSock.Serve()
+	listener := torrent.NewListener(seederSock)
+	listener.Register(seeder)
+	go listener.Serve()
+	t.Cleanup(func() { listener.Close() })
+	seeder.Download()
+
+	leecherSock, err := utp.Listen("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go leecherSock.Serve()
+	t.Cleanup(func() { leecherSock.Close() })
+	addr := seederSock.Addr().(*net.UDPAddr)
+	leecher := &torrent.TorrentTask{
+		FileName:  seeder.FileName,
+		FileLen:   seeder.FileLen,
+		InfoSHA:   seeder.InfoSHA,
+		PieceLen:  seeder.PieceLen,
+		PieceSHA:  seeder.PieceSHA,
+		PeerId:    [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList:  []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}},
+		Storage:   &memStorage{data: make([]byte, len(data))},
+		UTP:       leecherSock,
+		Transport: torrent.PreferUTP,
+	}
+	ctx := leecher.Download()
+	for range ctx.GetResult() {
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+
+	states := seeder.PeerStates()
+	require.Len(t, states, 1)
+	require.NotZero(t, states[0].Peer.Flags&torrent.PexUTP)
+}
@@ -10,6 +10,14 @@ type PeerMsg struct {
 	Payload []byte // 消息内容
 }
 
+// 消息在连接上的长度（包括消息头，保活消息为 nil）
+func (msg *PeerMsg) size() int {
+	if msg == nil {
+		return int(PEER_MSG_HEAD_LEN)
+	}
+	return int(PEER_MSG_HEAD_LEN) + 1 + len(msg.Payload)
+}
+
 // 将下载消息写入传入 buf 中，返回写入的长度
 func (msg *PeerMsg) CopyPieceData(index int, buf []byte) (int, error) {
 	if msg.Id != MsgPiece {
@@ -34,6 +42,19 @@ func (msg *PeerMsg) CopyPieceData(index int, buf []byte) (int, error) {
 	return len(data), nil
 }
 
+// 从 Piece 消息中读取块（Piece 索引、块偏移与数据）
+func (msg *PeerMsg) GetPiece() (index, begin int, data []byte, err error) {
+	if msg.Id != MsgPiece {
+		return 0, 0, nil, fmt.Errorf("expected MsgPiece (Id %d), got Id %d", MsgPiece, msg.Id)
+	}
+	if len(msg.Payload) < 8 {
+		return 0, 0, nil, fmt.Errorf("payload too short. %d < 8", len(msg.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	return index, begin, msg.Payload[8:], nil
+}
+
 // 从 PeerMsg 中读取对端拥有的片段
 func (msg *PeerMsg) GetHaveIndex() (int, error) {
 	if msg.Id != MsgHave {
@@ -47,9 +68,31 @@ func (msg *PeerMsg) GetHaveIndex() (int, error) {
 }
 
 func NewRequestMsg(index, offset, length int) *PeerMsg {
-	payload := make([]byte, 12)
-	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
-	binary.BigEndian.PutUint32(payload[4:8], uint32(offset))
-	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
-	return &PeerMsg{MsgRequest, payload}
+	return Encode(RequestMsg{index, offset, length})
+}
+
+func NewCancelMsg(index, offset, length int) *PeerMsg {
+	return Encode(CancelMsg{index, offset, length})
+}
+
+// 从 Request/Cancel/Reject 消息中读取请求的块（Piece 索引、块偏移与长度）
+func (msg *PeerMsg) GetRequest() (index, begin, length int, err error) {
+	if msg.Id != MsgRequest && msg.Id != MsgCancel && msg.Id != MsgReject {
+		return 0, 0, 0, fmt.Errorf("expected MsgRequest, MsgCancel or MsgReject, got Id %d", msg.Id)
+	}
+	if len(msg.Payload) != 12 {
+		return 0, 0, 0, fmt.Errorf("expected payload length 12, got length %d", len(msg.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length = int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	return index, begin, length, nil
+}
+
+func NewHaveMsg(index int) *PeerMsg {
+	return Encode(HaveMsg{index})
+}
+
+func NewPieceMsg(index, begin int, data []byte) *PeerMsg {
+	return Encode(PieceMsg{index, begin, data})
 }
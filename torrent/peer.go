@@ -4,153 +4,430 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strconv"
+	"sync/atomic"
 	"time"
+
+	"github.com/Akimio521/torrent-go/mse"
 )
 
 type PeerConn struct {
-	net.Conn                   // 连接通道
-	Choked   bool              // 对端上传是否被阻塞
-	Field    Bitfield          // 对端的 Bitfield
-	peer     PeerInfo          // 对端信息
-	peerId   [PEER_ID_LEN]byte // 本地的 peerId
-	infoSHA  [sha1.Size]byte   // 请求种子的 info 的 SHA-1 哈希
+	net.Conn                                    // 连接通道
+	Choked      bool                            // 对端上传是否被阻塞
+	Field       Bitfield                        // 对端的 Bitfield
+	peer        PeerInfo                        // 对端信息
+	peerId      [PEER_ID_LEN]byte               // 本地的 peerId
+	remoteId    [PEER_ID_LEN]byte               // 对端的 peerId
+	client      PeerClient                      // 从对端 peerId 解析出的客户端
+	infoSHA     [sha1.Size]byte                 // 请求种子的 info 的 SHA-1 哈希
+	reserved    [RESERVED_LEN]byte              // 对端握手中的保留字段
+	ext         atomic.Pointer[ExtHandshake]    // 对端的扩展握手（未收到时为 nil）
+	onPex       func(added, dropped []PeerInfo) // 收到 PEX 消息时的回调（为 nil 时忽略 PEX）
+	pieces      int                             // 种子的 Piece 数量
+	allowedFast Bitfield                        // 对端允许在阻塞时请求的 Piece（BEP 6）
+	suggested   []int                           // 对端建议下载的 Piece（BEP 6）
+	picker      *picker                         // 对端 Bitfield 变化时更新可用度的选择器（为 nil 时不统计）
+
+	inbox          chan *PeerMsg  // 读协程转交的消息（为 nil 时直接从连接读取）
+	readErr        error          // 读协程退出的原因（inbox 关闭后有效）
+	outbox         chan []byte    // 交给写协程发送的消息（为 nil 时直接写入连接）
+	writerDone     chan struct{}  // 写协程退出时关闭
+	writeErr       error          // 写协程退出的原因（writerDone 关闭后有效）
+	upLimits       []*RateLimiter // 发送消息前依次等待的限速器（连接、任务与全局）
+	downLimits     []*RateLimiter // 收到消息后依次等待的限速器（连接、任务与全局）
+	amChoking      atomic.Bool    // 本地是否阻塞对端
+	amInterested   atomic.Bool    // 本地是否对对端感兴趣
+	peerChoking    atomic.Bool    // 对端是否阻塞本地（与 Choked 相同，可在其他协程读取）
+	peerInterested atomic.Bool    // 对端是否对本地感兴趣
+	havePieces     atomic.Int64   // 对端拥有的 Piece 数量（可在其他协程读取）
+	downloaded     atomic.Uint64  // 从对端下载的字节数
+	uploaded       atomic.Uint64  // 向对端上传的字节数
+	downRate       atomic.Uint64  // 最近的下载速度（字节/秒，由 choker 计算）
+	upRate         atomic.Uint64  // 最近的上传速度（字节/秒，由 choker 计算）
+	lastPiece      atomic.Int64   // 最近一次收到对端数据的时间（UnixNano，用于判断 snub）
 }
 
-func handshake(conn net.Conn, infoSHA [sha1.Size]byte, peerId [PEER_ID_LEN]byte) error {
+func handshake(conn net.Conn, infoSHA [sha1.Size]byte, peerId [PEER_ID_LEN]byte) (*HandshakeMsg, error) {
 	conn.SetDeadline(time.Now().Add(3 * time.Second))
 	defer conn.SetDeadline(time.Time{})
 	// send HandshakeMsg
 	reqMsg := NewHandShakeMsg(infoSHA, peerId)
 
 	if err := reqMsg.WriteHandShakeMsg(conn); err != nil {
-		return fmt.Errorf("send handshake failed: %s", err.Error())
+		return nil, fmt.Errorf("send handshake failed: %s", err.Error())
 	}
 	// read HandshakeMsg
 	respMsg, err := ReadHandshake(conn)
 	if err != nil {
-		return fmt.Errorf("read handshake failed: %s", err.Error())
+		return nil, fmt.Errorf("read handshake failed: %s", err.Error())
 	}
 	// check HandshakeMsg
 	if !bytes.Equal(respMsg.InfoSHA[:], infoSHA[:]) {
-		return fmt.Errorf("check handshake hash failed: %s", string(respMsg.InfoSHA[:]))
+		return nil, fmt.Errorf("check handshake hash failed: %s", string(respMsg.InfoSHA[:]))
 	}
-	return nil
+	if isLocalPeerId(respMsg.PeerId, peerId) {
+		return nil, ErrSelfConnection
+	}
+	return respMsg, nil
 }
 func (peer PeerInfo) NewConn(infoSHA [sha1.Size]byte, peerId [PEER_ID_LEN]byte) (*PeerConn, error) {
-	// setup tcp conn
-	conn, err := net.DialTimeout("tcp", peer.GetConnAddr(), 5*time.Second)
+	return peer.newConn(DefaultConnManager.dialTCP, infoSHA, peerId, 0)
+}
+
+type dialFunc func(addr string) (net.Conn, error) // 建立到对端的传输层连接
+
+// 通过 dial 连接对端，provide 不为 0 时先进行 MSE 握手（provide 为本地提供的加密方式）
+func (peer PeerInfo) newConn(dial dialFunc, infoSHA [sha1.Size]byte, peerId [PEER_ID_LEN]byte, provide mse.CryptoMethod) (*PeerConn, error) {
+	// setup transport conn
+	conn, err := dial(peer.GetConnAddr())
 	if err != nil {
-		return nil, fmt.Errorf("set tcp conn to %s failed: %s", peer.GetConnAddr(), err.Error())
+		return nil, fmt.Errorf("set conn to %s failed: %s", peer.GetConnAddr(), err.Error())
+	}
+	if provide != 0 {
+		ec, err := mse.Initiate(conn, infoSHA[:], provide)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("mse handshake failed: %s", err.Error())
+		}
+		conn = ec
 	}
 	// torrent p2p handshake
-	if err = handshake(conn, infoSHA, peerId); err != nil {
+	respMsg, err := handshake(conn, infoSHA, peerId)
+	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("handshake failed: %s", err.Error())
+		return nil, fmt.Errorf("handshake failed: %w", err)
 	}
 	c := &PeerConn{
-		Conn:    conn,
-		Choked:  true,
-		peer:    peer,
-		peerId:  peerId,
-		infoSHA: infoSHA,
+		Conn:     conn,
+		Choked:   true,
+		peer:     peer,
+		peerId:   peerId,
+		remoteId: respMsg.PeerId,
+		client:   ParsePeerId(respMsg.PeerId),
+		infoSHA:  infoSHA,
+		reserved: respMsg.Reserved,
 	}
 
-	if err = c.GetBitfield(); err != nil {
-		return nil, fmt.Errorf("fill bitfield failed: %s" + err.Error())
-	}
 	return c, nil
 }
 
-// 获取对端 Bitfield
-func (c *PeerConn) GetBitfield() error {
-	c.SetDeadline(time.Now().Add(5 * time.Second))
-	defer c.SetDeadline(time.Time{})
+// 对端是否支持扩展协议（BEP 10）
+func (c *PeerConn) SupportsExtensions() bool {
+	return c.reserved[RESERVED_EXTENSION_BYTE]&RESERVED_EXTENSION_BIT != 0
+}
 
-	msg, err := c.ReadMsg()
-	if err != nil {
-		return fmt.Errorf("read PeerMsg faild: %s", err.Error())
+// 对端的扩展握手（未收到时为 nil）
+func (c *PeerConn) ExtHandshake() *ExtHandshake {
+	return c.ext.Load()
+}
+
+// 对端为扩展 name 分配的消息 ID（为 0 表示不支持）
+func (c *PeerConn) extID(name string) uint8 {
+	if h := c.ext.Load(); h != nil {
+		return h.M[name]
 	}
-	if msg == nil {
-		return fmt.Errorf("expected bitfield")
+	return 0
+}
+
+// 处理扩展消息
+func (c *PeerConn) handleExtended(msg ExtendedMsg) error {
+	switch msg.ExtId {
+	case EXT_HANDSHAKE_ID:
+		h, err := ParseExtHandshake(msg.Payload)
+		if err != nil {
+			return err
+		}
+		c.ext.Store(h)
+	case EXT_PEX_ID:
+		if c.onPex == nil {
+			return nil
+		}
+		pm, err := ParsePexMsg(msg.Payload)
+		if err != nil {
+			return err
+		}
+		if len(pm.Added) > PEX_MAX_PEERS {
+			pm.Added = pm.Added[:PEX_MAX_PEERS]
+		}
+		c.onPex(pm.Added, pm.Dropped)
 	}
-	if msg.Id != MsgBitfield {
-		return fmt.Errorf("expected bitfield, get " + strconv.Itoa(int(msg.Id)))
+	return nil
+}
+
+// 解码消息，未知类型的消息返回 nil（忽略）
+func decodeMsg(msg *PeerMsg) (Message, error) {
+	m, err := Decode(msg)
+	if errors.Is(err, ErrUnknownMsg) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// 解码并处理与下载的 Piece 数据无关的消息
+func (c *PeerConn) handleMsg(msg *PeerMsg) error {
+	m, err := decodeMsg(msg)
+	if err != nil {
+		return err
+	}
+	return c.handle(m)
+}
+
+// 处理已解码的、与下载的 Piece 数据无关的消息
+func (c *PeerConn) handle(m Message) error {
+	switch m := m.(type) {
+	case EmptyMsg:
+		switch MsgId(m) {
+		case MsgChoke:
+			c.Choked = true
+			c.peerChoking.Store(true)
+		case MsgUnchoke:
+			c.Choked = false
+			c.peerChoking.Store(false)
+		case MsgHaveAll, MsgHaveNone:
+			return c.handleFast(m)
+		}
+	case HaveMsg:
+		if m.Index >= c.pieces {
+			return protocolViolation(fmt.Errorf("%w: have %d, %d pieces", ErrPieceIndex, m.Index, c.pieces))
+		}
+		if !c.Field.HasPiece(m.Index) {
+			c.Field.SetPiece(m.Index)
+			c.havePieces.Add(1)
+			if c.picker != nil {
+				c.picker.have(m.Index)
+			}
+		}
+	case BitfieldMsg:
+		if len(m.Field) != len(c.Field) {
+			return fmt.Errorf("expected bitfield length %d, got %d", len(c.Field), len(m.Field))
+		}
+		if spare := c.pieces % 8; spare != 0 && len(m.Field) > 0 && m.Field[len(m.Field)-1]&(0xff>>spare) != 0 {
+			return protocolViolation(ErrBitfieldSpareBits)
+		}
+		c.setField(m.Field)
+	case ExtendedMsg:
+		return c.handleExtended(m)
+	case SuggestMsg, RejectMsg, AllowedFastMsg:
+		return c.handleFast(m)
 	}
-	c.Field = msg.Payload
 	return nil
 }
 
-// 读取消息
+// 替换对端的 Bitfield，并更新选择器中的可用度
+func (c *PeerConn) setField(field Bitfield) {
+	if c.picker != nil {
+		c.picker.update(c.Field, field)
+	}
+	c.Field = field
+	c.havePieces.Store(int64(field.count()))
+}
+
+// 对端是否拥有所有 Piece
+func (c *PeerConn) isSeed() bool {
+	return c.pieces > 0 && c.havePieces.Load() >= int64(c.pieces)
+}
+
+// 在 PEX 中告知其他 Peer 的标志：保留从 PEX 得知的标志，并补充连接上观察到的标志
+func (c *PeerConn) pexFlags() PexFlags {
+	flags := c.peer.Flags | PexReachable // 本机能够主动连接到该 Peer
+	if c.isSeed() {
+		flags |= PexSeed
+	}
+	if _, ok := c.RemoteAddr().(*net.UDPAddr); ok { // 通过 uTP 连接
+		flags |= PexUTP
+	}
+	if ec, ok := c.Conn.(*mse.Conn); ok && ec.Method == mse.CryptoRC4 {
+		flags |= PexEncryption
+	}
+	return flags
+}
+
+// 启动读协程：上传相关的消息交给 u 处理，其余消息通过 ReadMsg 读取，直到连接出错、超过 idle 没有收到消息或 done 被关闭
+func (c *PeerConn) startReader(u *uploader, done <-chan struct{}, idle time.Duration) {
+	c.inbox = make(chan *PeerMsg, PEER_INBOX_LEN)
+	go u.run(done)
+	go func() {
+		defer close(c.inbox)
+		for {
+			c.SetReadDeadline(time.Now().Add(idle))
+			msg, err := c.readMsg()
+			if errors.Is(err, ErrMsgTooLong) {
+				c.readErr = protocolViolation(err)
+				return
+			}
+			if err != nil {
+				c.readErr = err
+				return
+			}
+			if !waitAll(c.downLimits, msg.size(), done) { // 读取完整的消息后再限速，不影响消息的边界
+				releaseMsg(msg)
+				return
+			}
+			handled, err := u.handleMsg(msg)
+			if err != nil {
+				c.readErr = protocolViolation(err)
+				c.Conn.Close()
+				return
+			}
+			if handled {
+				continue
+			}
+			select {
+			case c.inbox <- msg:
+			case <-done:
+				releaseMsg(msg)
+				return
+			}
+		}
+	}()
+}
+
+// 启动写协程：按顺序发送 WriteMsg 写入的消息（整条消息按限速等待），超过 keepAlive 没有发送消息时发送保活消息，
+// 直到连接出错、一条消息超过 writeTimeout 没有写完或 done 被关闭
+func (c *PeerConn) startWriter(done <-chan struct{}, keepAlive, writeTimeout time.Duration) {
+	c.outbox = make(chan []byte, PEER_OUTBOX_LEN)
+	c.writerDone = make(chan struct{})
+	go func() {
+		defer func() {
+			if c.writeErr == nil { // done 被关闭，连接已不再使用
+				c.writeErr = net.ErrClosed
+			}
+			close(c.writerDone)
+		}()
+		timer := time.NewTimer(keepAlive)
+		defer timer.Stop()
+		for {
+			var buf []byte
+			select {
+			case buf = <-c.outbox:
+			case <-timer.C:
+				buf = make([]byte, PEER_MSG_HEAD_LEN) // 保活消息（长度为 0）
+			case <-done:
+				return
+			}
+			if !waitAll(c.upLimits, len(buf), done) {
+				return
+			}
+			c.SetWriteDeadline(time.Now().Add(writeTimeout)) // 对端不读取时 Write 不会一直阻塞，等待写入的协程随之返回
+			_, err := c.Write(buf)
+			putBuf(buf)
+			if err != nil {
+				c.writeErr = err
+				c.Conn.Close()
+				return
+			}
+			timer.Reset(keepAlive)
+		}
+	}()
+}
+
+// 等待对端的下一条消息，wake 或 done 先被关闭时返回 nil，超时返回 ErrRequestTimeout（需要先启动读协程）
+func (c *PeerConn) wait(wake, done <-chan struct{}, timeout <-chan time.Time) (*PeerMsg, error) {
+	select {
+	case msg, ok := <-c.inbox:
+		if !ok {
+			return nil, c.readErr
+		}
+		return msg, nil
+	case <-wake:
+	case <-done:
+	case <-timeout:
+		return nil, ErrRequestTimeout
+	}
+	return nil, nil
+}
+
+// 读取消息（读协程启动后从读协程获取）
 func (c *PeerConn) ReadMsg() (*PeerMsg, error) {
+	if c.inbox != nil {
+		msg, ok := <-c.inbox
+		if !ok {
+			return nil, c.readErr
+		}
+		return msg, nil
+	}
+	return c.readMsg()
+}
+
+// 从连接读取一条消息
+func (c *PeerConn) readMsg() (*PeerMsg, error) {
 	// read msg length
-	lenBuf := make([]byte, 4)
-	_, err := io.ReadFull(c, lenBuf)
+	head := make([]byte, PEER_MSG_HEAD_LEN+1)
+	_, err := io.ReadFull(c, head[:PEER_MSG_HEAD_LEN])
 	if err != nil {
 		return nil, err
 	}
-	length := binary.BigEndian.Uint32(lenBuf)
+	length := binary.BigEndian.Uint32(head)
 	// keep alive msg
 	if length == 0 {
 		return nil, nil
 	}
-	// read msg body
-	msgBuf := make([]byte, length)
-	_, err = io.ReadFull(c, msgBuf)
+	// read msg id，在分配缓冲区前检查长度
+	_, err = io.ReadFull(c, head[PEER_MSG_HEAD_LEN:])
+	if err != nil {
+		return nil, err
+	}
+	id := MsgId(head[PEER_MSG_HEAD_LEN])
+	if limit := maxPayloadLen(id, c.pieces); length-1 > uint32(limit) {
+		return nil, fmt.Errorf("%w: id %d, length %d > %d", ErrMsgTooLong, id, length-1, limit)
+	}
+	// read msg body（Piece 消息使用缓冲池）
+	var payload []byte
+	if id == MsgPiece {
+		payload = getBuf()[:length-1]
+	} else {
+		payload = make([]byte, length-1)
+	}
+	_, err = io.ReadFull(c, payload)
 	if err != nil {
+		putBuf(payload)
 		return nil, err
 	}
 	return &PeerMsg{
-		Id:      MsgId(msgBuf[0]),
-		Payload: msgBuf[1:],
+		Id:      id,
+		Payload: payload,
 	}, nil
 }
 
-// 写入消息
+// 向对端发送 Interested 或 NotInterested（状态未变化时不发送）
+func (c *PeerConn) setInterested(interested bool) error {
+	if c.amInterested.Swap(interested) == interested {
+		return nil
+	}
+	id := MsgNotInterest
+	if interested {
+		id = MsgInterested
+	}
+	_, err := c.WriteMsg(&PeerMsg{id, nil})
+	return err
+}
+
+// 写入消息（写协程启动后交给写协程发送）
 func (c *PeerConn) WriteMsg(m *PeerMsg) (int, error) {
-	length := uint32(len(m.Payload) + 1) // +1 for id
-	buf := make([]byte, PEER_MSG_HEAD_LEN+length)
-	binary.BigEndian.PutUint32(buf[0:PEER_MSG_HEAD_LEN], length)
-	buf[PEER_MSG_HEAD_LEN] = byte(m.Id)
-	copy(buf[PEER_MSG_HEAD_LEN+1:], m.Payload)
-	return c.Write(buf)
-}
-
-// 将下载任务分配给该连接
-func (conn *PeerConn) DownloadPiece(task *PieceTask) (*PieceResult, error) {
-	state := &TaskState{
-		Index: task.Index,
-		Conn:  conn,
-		Data:  make([]byte, task.Length),
-	}
-	conn.SetDeadline(time.Now().Add(15 * time.Second))
-	defer conn.SetDeadline(time.Time{})
+	return c.Send(m)
+}
 
-	for state.Downloaded < task.Length {
-		if !conn.Choked {
-			for state.Backlog < MAX_BACKLOG && state.Requested < task.Length { // 并发度未达到最大值，且请求量未达到任务长度
-				length := BLOCK_SIZE
-				if task.Length-state.Requested < length { //最后一片的长度可能小与 Block Size
-					length = task.Length - state.Requested
-				}
-				msg := NewRequestMsg(state.Index, state.Requested, length)
-				if _, err := state.Conn.WriteMsg(msg); err != nil {
-					return nil, err
-				}
-				state.Backlog++
-				state.Requested += length
-			}
-		}
-		msg, err := state.Conn.ReadMsg()
-		if err != nil {
-			return nil, fmt.Errorf("read msg failed: %s", err.Error())
-		}
-		if err := state.HandleMsg(msg); err != nil {
-			return nil, fmt.Errorf("handle msg failed: %s", err.Error())
-		}
+// 编码并发送类型化的消息（有写协程时放入发送队列）
+func (c *PeerConn) Send(m Message) (int, error) {
+	buf := appendFrame(getBuf(), m)
+	if c.outbox == nil {
+		defer putBuf(buf)
+		return c.Write(buf)
+	}
+	select {
+	case <-c.writerDone: // 写协程已退出时不再放入发送队列
+		putBuf(buf)
+		return 0, c.writeErr
+	default:
+	}
+	select {
+	case c.outbox <- buf:
+		return len(buf), nil
+	case <-c.writerDone:
+		putBuf(buf)
+		return 0, c.writeErr
 	}
-	return &PieceResult{state.Index, state.Data}, nil
 }
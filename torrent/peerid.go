@@ -0,0 +1,178 @@
+package torrent
+
+import (
+	"crypto/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type PeerClient struct { // 从 Peer ID 解析出的对端客户端
+	Name    string // 客户端名称（无法识别时为空）
+	Version string // 客户端版本（无法识别时为空）
+}
+
+func (c PeerClient) String() string {
+	if c.Name == "" {
+		return "unknown"
+	}
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Version
+}
+
+var azureusClients = map[string]string{ // Azureus 风格（-XX1234-）的客户端代码
+	PEER_ID_CLIENT: CLIENT_VERSION,
+	"AZ":           "Vuze",
+	"BI":           "BiglyBT",
+	"BT":           "BitTorrent",
+	"DE":           "Deluge",
+	"FD":           "Free Download Manager",
+	"KT":           "KTorrent",
+	"LT":           "libtorrent",
+	"lt":           "rTorrent",
+	"qB":           "qBittorrent",
+	"SD":           "Thunder",
+	"TR":           "Transmission",
+	"UT":           "µTorrent",
+	"UM":           "µTorrent Mac",
+	"WW":           "WebTorrent",
+	"XL":           "Xunlei",
+}
+
+var shadowClients = map[byte]string{ // Shadow 风格（S58B-----）的客户端代码
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+var localPeerIds sync.Map // 本机生成的 Peer ID（用于识别连接到自身的连接）
+
+// 生成 Azureus 风格的 Peer ID：prefix（为空时使用 DEFAULT_PEER_ID_PREFIX，超过 Peer ID 长度时截断）加随机字节
+func NewPeerId(prefix string) [PEER_ID_LEN]byte {
+	if prefix == "" {
+		prefix = DEFAULT_PEER_ID_PREFIX
+	}
+	var id [PEER_ID_LEN]byte
+	n := copy(id[:], prefix)
+	_, _ = rand.Read(id[n:])
+	localPeerIds.Store(id, struct{}{})
+	return id
+}
+
+// 对端的 Peer ID 是否属于本机（与 local 相同，或由本机的 NewPeerId 生成）
+func isLocalPeerId(id, local [PEER_ID_LEN]byte) bool {
+	if id == local {
+		return true
+	}
+	_, ok := localPeerIds.Load(id)
+	return ok
+}
+
+// 解析 Peer ID 中的客户端名称与版本，支持 Azureus、Shadow 与 Mainline 风格，无法识别时返回空的 PeerClient
+func ParsePeerId(id [PEER_ID_LEN]byte) PeerClient {
+	if c, ok := parseAzureus(id); ok {
+		return c
+	}
+	if c, ok := parseMainline(id); ok {
+		return c
+	}
+	if c, ok := parseShadow(id); ok {
+		return c
+	}
+	return PeerClient{}
+}
+
+// Azureus 风格：'-' + 两个字符的客户端代码 + 四个字符的版本 + '-'
+func parseAzureus(id [PEER_ID_LEN]byte) (PeerClient, bool) {
+	if id[0] != '-' || id[7] != '-' {
+		return PeerClient{}, false
+	}
+	code := string(id[1:3])
+	name, ok := azureusClients[code]
+	if !ok {
+		if !isAlnum(id[1]) || !isAlnum(id[2]) {
+			return PeerClient{}, false
+		}
+		name = code // 未知的客户端代码
+	}
+	parts := make([]string, 0, 4)
+	for _, b := range id[3:7] {
+		v, ok := versionDigit(b)
+		if !ok {
+			return PeerClient{}, false
+		}
+		parts = append(parts, strconv.Itoa(v))
+	}
+	return PeerClient{name, strings.Join(parts, ".")}, true
+}
+
+// Mainline 风格：'M' + 以 '-' 分隔的版本号 + 填充的 '-'，如 M4-3-6--
+func parseMainline(id [PEER_ID_LEN]byte) (PeerClient, bool) {
+	if id[0] != 'M' {
+		return PeerClient{}, false
+	}
+	end := 1
+	for end < 8 && (id[end] == '-' || id[end] >= '0' && id[end] <= '9') {
+		end++
+	}
+	if end < 8 {
+		return PeerClient{}, false
+	}
+	var parts []string
+	for _, p := range strings.Split(string(id[1:end]), "-") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 || id[1] == '-' {
+		return PeerClient{}, false
+	}
+	return PeerClient{"Mainline", strings.Join(parts, ".")}, true
+}
+
+// Shadow 风格：一个字符的客户端代码 + 最多五个字符的版本（0-9、A-Z、a-z 分别表示 0-61）+ 以 '-' 填充到第 9 个字符
+func parseShadow(id [PEER_ID_LEN]byte) (PeerClient, bool) {
+	name, ok := shadowClients[id[0]]
+	if !ok || id[8] != '-' {
+		return PeerClient{}, false
+	}
+	var parts []string
+	for _, b := range id[1:6] {
+		if b == '-' || b == '.' {
+			break
+		}
+		v, ok := versionDigit(b)
+		if !ok {
+			return PeerClient{}, false
+		}
+		parts = append(parts, strconv.Itoa(v))
+	}
+	if len(parts) == 0 {
+		return PeerClient{}, false
+	}
+	return PeerClient{name, strings.Join(parts, ".")}, true
+}
+
+// 版本字符的值：0-9、A-Z、a-z 分别表示 0-9、10-35、36-61
+func versionDigit(b byte) (int, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return int(b - '0'), true
+	case b >= 'A' && b <= 'Z':
+		return int(b-'A') + 10, true
+	case b >= 'a' && b <= 'z':
+		return int(b-'a') + 36, true
+	}
+	return 0, false
+}
+
+func isAlnum(b byte) bool {
+	_, ok := versionDigit(b)
+	return ok
+}
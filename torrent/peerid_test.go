@@ -0,0 +1,109 @@
+package torrent_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func peerId(s string) [torrent.PEER_ID_LEN]byte {
+	var id [torrent.PEER_ID_LEN]byte
+	copy(id[:], s)
+	return id
+}
+
+func TestParsePeerId(t *testing.T) {
+	for _, c := range []struct {
+		id     string
+		client torrent.PeerClient
+	}{
+		{"-TG0100-abcdefghijkl", torrent.PeerClient{Name: torrent.CLIENT_VERSION, Version: "0.1.0.0"}},
+		{"-qB4250-abcdefghijkl", torrent.PeerClient{Name: "qBittorrent", Version: "4.2.5.0"}},
+		{"-UT355S-abcdefghijkl", torrent.PeerClient{Name: "µTorrent", Version: "3.5.5.28"}},
+		{"-ZZ1000-abcdefghijkl", torrent.PeerClient{Name: "ZZ", Version: "1.0.0.0"}},
+		{"S58B-----abcdefghijk", torrent.PeerClient{Name: "Shadow", Version: "5.8.11"}},
+		{"T03I--00abcdefghijkl", torrent.PeerClient{}}, // 第 9 个字符不是 '-'
+		{"T03I-----abcdefghijk", torrent.PeerClient{Name: "BitTornado", Version: "0.3.18"}},
+		{"M4-3-6--abcdefghijkl", torrent.PeerClient{Name: "Mainline", Version: "4.3.6"}},
+		{"M7-10-3-abcdefghijkl", torrent.PeerClient{Name: "Mainline", Version: "7.10.3"}},
+		{"\x00\x01\x02\x03abcdefghijklmnop", torrent.PeerClient{}},
+	} {
+		require.Equal(t, c.client, torrent.ParsePeerId(peerId(c.id)), c.id)
+	}
+	require.Equal(t, "unknown", torrent.PeerClient{}.String())
+	require.Equal(t, "Mainline 4.3.6", torrent.ParsePeerId(peerId("M4-3-6--abcdefghijkl")).String())
+}
+
+func TestNewPeerId(t *testing.T) {
+	a, b := torrent.NewPeerId(""), torrent.NewPeerId("")
+	require.True(t, strings.HasPrefix(string(a[:]), torrent.DEFAULT_PEER_ID_PREFIX))
+	require.NotEqual(t, a, b)
+	require.Equal(t, torrent.PeerClient{Name: torrent.CLIENT_VERSION, Version: "0.1.0.0"}, torrent.ParsePeerId(a))
+
+	custom := torrent.NewPeerId("-XX2000-custom")
+	require.True(t, strings.HasPrefix(string(custom[:]), "-XX2000-custom"))
+	long := torrent.NewPeerId(strings.Repeat("x", 30))
+	require.Equal(t, strings.Repeat("x", torrent.PEER_ID_LEN), string(long[:]))
+}
+
+func TestPeerIdentification(t *testing.T) {
+	seeder, data := newTestTask(t, "peerid", 100000, 40000)
+	peer := startSeeder(t, seeder, data)
+	addr := &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}
+
+	// 本机生成的其他 Peer ID 也被识别为自身的连接：回复握手后断开
+	self, _, err := dialHandshake(t, addr, seeder.InfoSHA, torrent.NewPeerId(""))
+	require.NoError(t, err)
+	_, err = self.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	remote := peerId("-qB4250-abcdefghijkl")
+	conn, _, err := dialHandshake(t, addr, seeder.InfoSHA, remote)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for _, s := range seeder.PeerStates() {
+			if s.Peer.GetConnAddr() == conn.LocalAddr().String() {
+				return s.PeerId == remote && s.Client == torrent.PeerClient{Name: "qBittorrent", Version: "4.2.5.0"}
+			}
+		}
+		return false
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+type countingListener struct { // 统计接受的连接数量
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestSelfConnection(t *testing.T) {
+	task, _ := newTestTask(t, "self", 100000, 40000)
+	task.Manager = &torrent.ConnManager{Backoff: 10 * time.Millisecond}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	counting := &countingListener{Listener: ln}
+	listener := torrent.NewListener(counting)
+	listener.Register(task)
+	go listener.Serve()
+	defer listener.Close()
+
+	// 连接到本机后不再重试该地址
+	addr := ln.Addr().(*net.TCPAddr)
+	task.PeerList = []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}}
+	task.Download()
+	require.Eventually(t, func() bool { return counting.accepted.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	require.Never(t, func() bool { return counting.accepted.Load() > 1 }, 300*time.Millisecond, 10*time.Millisecond)
+}
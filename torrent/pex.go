@@ -0,0 +1,87 @@
+package torrent
+
+import (
+	"time"
+)
+
+// 本地支持的扩展（私有种子不启用 PEX）
+func (t *TorrentTask) extensions() map[string]uint8 {
+	m := make(map[string]uint8)
+	if !t.Private {
+		m[EXT_PEX] = EXT_PEX_ID
+	}
+	return m
+}
+
+// 向对端发送扩展握手，并在启用 PEX 时开始交换 Peer，直到 done 被关闭（连接不再使用）
+func (t *TorrentTask) startExtensions(conn *PeerConn, done <-chan struct{}) error {
+	h := &ExtHandshake{M: t.extensions(), P: int(t.Port), V: CLIENT_VERSION, Reqq: MAX_UPLOAD_REQUESTS}
+	if _, err := conn.WriteMsg(h.Encode()); err != nil {
+		return err
+	}
+	if t.Private {
+		return nil
+	}
+	conn.onPex = func(added, dropped []PeerInfo) {
+		t.AddPeers(added...)
+	}
+	go t.pexRoutine(conn, done)
+	return nil
+}
+
+// 定期向对端发送自上次以来新连接与已断开的 Peer，直到 done 被关闭（与下载是否完成无关）或连接出错
+func (t *TorrentTask) pexRoutine(conn *PeerConn, done <-chan struct{}) {
+	ticker := time.NewTicker(PEX_INTERVAL)
+	defer ticker.Stop()
+	sent := make(map[string]PeerInfo) // 已告知对端且仍然连接的 Peer
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		id := conn.extID(EXT_PEX)
+		if id == 0 { // 对端不支持 PEX
+			continue
+		}
+
+		current := t.pexPeers(conn)
+		pm := new(PexMsg)
+		for addr, p := range current {
+			if _, ok := sent[addr]; ok || len(pm.Added) >= PEX_MAX_PEERS {
+				continue
+			}
+			pm.Added = append(pm.Added, p)
+			sent[addr] = p
+		}
+		for addr, p := range sent {
+			if _, ok := current[addr]; ok || len(pm.Dropped) >= PEX_MAX_PEERS {
+				continue
+			}
+			pm.Dropped = append(pm.Dropped, p)
+			delete(sent, addr)
+		}
+		if len(pm.Added) == 0 && len(pm.Dropped) == 0 {
+			continue
+		}
+		if _, err := conn.WriteMsg(pm.Encode(id)); err != nil {
+			return
+		}
+	}
+}
+
+// 可以告知 conn 的已连接 Peer 及其标志（不包括 conn 自身）
+func (t *TorrentTask) pexPeers(conn *PeerConn) map[string]PeerInfo {
+	t.rwm.RLock()
+	defer t.rwm.RUnlock()
+	peers := make(map[string]PeerInfo, len(t.conns))
+	for c := range t.conns {
+		if c == conn || c.peer.Source == SourceIncoming { // 连入的 Peer 使用临时端口，无法被连接
+			continue
+		}
+		p := c.peer
+		p.Flags = c.pexFlags()
+		peers[p.GetConnAddr()] = p
+	}
+	return peers
+}
@@ -0,0 +1,177 @@
+package torrent_test
+
+import (
+	"crypto/sha1"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/mse"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtHandshakeRoundTrip(t *testing.T) {
+	h := &torrent.ExtHandshake{M: map[string]uint8{torrent.EXT_PEX: 3}, P: 6881, V: torrent.CLIENT_VERSION, Reqq: 250}
+	msg := h.Encode()
+	require.Equal(t, torrent.MsgExtended, msg.Id)
+	require.Equal(t, torrent.EXT_HANDSHAKE_ID, msg.Payload[0])
+	require.Equal(t, "d1:md6:ut_pexi3ee1:pi6881e4:reqqi250e1:v10:torrent-goe", string(msg.Payload[1:]))
+
+	parsed, err := torrent.ParseExtHandshake(msg.Payload[1:])
+	require.NoError(t, err)
+	require.Equal(t, h, parsed)
+
+	_, err = torrent.ParseExtHandshake([]byte("li1ee"))
+	require.ErrorIs(t, err, torrent.ErrMalformedExtMsg)
+}
+
+func TestPexMsgRoundTrip(t *testing.T) {
+	pm := &torrent.PexMsg{
+		Added: []torrent.PeerInfo{
+			{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881, Source: torrent.SourcePEX, Flags: torrent.PexSeed | torrent.PexReachable},
+			{IP: net.ParseIP("2001:db8::1"), Port: 6882, Source: torrent.SourcePEX, Flags: torrent.PexUTP},
+		},
+		Dropped: []torrent.PeerInfo{{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 6883}},
+	}
+	msg := pm.Encode(7)
+	require.Equal(t, uint8(7), msg.Payload[0])
+
+	parsed, err := torrent.ParsePexMsg(msg.Payload[1:])
+	require.NoError(t, err)
+	require.Equal(t, pm, parsed)
+
+	_, err = torrent.ParsePexMsg([]byte("d5:added5:abcdee"))
+	require.ErrorIs(t, err, torrent.ErrMalformedExtMsg)
+}
+
+// 模拟一个支持扩展协议的 Peer：完成握手后发送 Bitfield 与扩展握手，
+// 收到本地的扩展握手后发送一条 PEX 消息，通过 gotExt 返回本地的扩展握手
+func fakePexPeer(t *testing.T, infoSHA [sha1.Size]byte, pexPeer torrent.PeerInfo, gotExt chan<- *torrent.ExtHandshake) torrent.PeerInfo {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if _, err = torrent.ReadHandshake(c); err != nil {
+			return
+		}
+		peerId := [torrent.PEER_ID_LEN]byte{'p', 'e', 'x'}
+		if err = torrent.NewHandShakeMsg(infoSHA, peerId).WriteHandShakeMsg(c); err != nil {
+			return
+		}
+		conn := &torrent.PeerConn{Conn: c}
+		conn.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgBitfield, Payload: []byte{0x80}})
+		conn.WriteMsg((&torrent.ExtHandshake{M: map[string]uint8{torrent.EXT_PEX: 2}}).Encode())
+		for {
+			msg, err := conn.ReadMsg()
+			if err != nil {
+				return
+			}
+			if msg == nil || msg.Id != torrent.MsgExtended || msg.Payload[0] != torrent.EXT_HANDSHAKE_ID {
+				continue
+			}
+			h, err := torrent.ParseExtHandshake(msg.Payload[1:])
+			if err != nil {
+				return
+			}
+			gotExt <- h
+			conn.WriteMsg((&torrent.PexMsg{Added: []torrent.PeerInfo{pexPeer}}).Encode(torrent.EXT_PEX_ID))
+			break
+		}
+		time.Sleep(time.Second) // 保持连接，等待本地处理 PEX 消息
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return torrent.PeerInfo{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+func TestPexExchange(t *testing.T) {
+	pexPeer := torrent.PeerInfo{IP: net.IPv4(127, 0, 0, 2).To4(), Port: 1}
+	for _, private := range []bool{false, true} {
+		infoSHA := sha1.Sum([]byte("pex"))
+		gotExt := make(chan *torrent.ExtHandshake, 1)
+		peer := fakePexPeer(t, infoSHA, pexPeer, gotExt)
+		task := &torrent.TorrentTask{
+			InfoSHA:  infoSHA,
+			PeerList: []torrent.PeerInfo{peer},
+			PieceLen: 1,
+			FileLen:  1,
+			PieceSHA: [][sha1.Size]byte{sha1.Sum([]byte{0})},
+			Private:  private,
+		}
+		task.Download()
+
+		var h *torrent.ExtHandshake
+		select {
+		case h = <-gotExt:
+		case <-time.After(3 * time.Second):
+			t.Fatal("extended handshake not received")
+		}
+		hasPex := func() bool {
+			for _, p := range task.Peers() {
+				if p.GetConnAddr() == pexPeer.GetConnAddr() {
+					require.Equal(t, torrent.SourcePEX, p.Source)
+					return true
+				}
+			}
+			return false
+		}
+		if private {
+			require.NotContains(t, h.M, torrent.EXT_PEX)
+			require.Never(t, hasPex, 300*time.Millisecond, 50*time.Millisecond)
+		} else {
+			require.Equal(t, torrent.EXT_PEX_ID, h.M[torrent.EXT_PEX])
+			require.Eventually(t, hasPex, 3*time.Second, 50*time.Millisecond)
+		}
+	}
+}
+
+func TestPexFlags(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// 保留从 PEX 得知的标志，对端拥有所有 Piece 后标记为做种者
+	peer := torrent.PeerInfo{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881, Source: torrent.SourcePEX, Flags: torrent.PexUTP}
+	conn := torrent.NewFieldConn(local, peer, torrent.Bitfield{0x80}, 2)
+	require.Equal(t, torrent.PexUTP|torrent.PexReachable, conn.PexFlags())
+	require.NoError(t, conn.HandleMsg(torrent.NewHaveMsg(1)))
+	require.Equal(t, torrent.PexUTP|torrent.PexReachable|torrent.PexSeed, conn.PexFlags())
+
+	// 使用 RC4 加密的连接
+	skey := []byte("pex flags")
+	go mse.Receive(remote, [][]byte{skey}, func([]byte, mse.CryptoMethod) mse.CryptoMethod { return mse.CryptoRC4 })
+	ec, err := mse.Initiate(local, skey, mse.CryptoRC4)
+	require.NoError(t, err)
+	conn = torrent.NewFieldConn(ec, torrent.PeerInfo{IP: peer.IP, Port: peer.Port}, torrent.Bitfield{0}, 2)
+	require.Equal(t, torrent.PexEncryption|torrent.PexReachable, conn.PexFlags())
+}
+
+func TestInvalidPieceIndex(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// 超出范围的 Have 与末尾空位被置位的 Bitfield 违反协议，不会让对端被当作做种者
+	conn := torrent.NewFieldConn(local, torrent.PeerInfo{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}, torrent.Bitfield{0x80}, 2)
+	for range 2 {
+		err := conn.HandleMsg(torrent.NewHaveMsg(2))
+		require.ErrorIs(t, err, torrent.ErrProtocolViolation)
+		require.ErrorIs(t, err, torrent.ErrPieceIndex)
+	}
+	require.Zero(t, conn.PexFlags()&torrent.PexSeed)
+	err := conn.HandleMsg(&torrent.PeerMsg{Id: torrent.MsgBitfield, Payload: []byte{0x60}})
+	require.ErrorIs(t, err, torrent.ErrProtocolViolation)
+	require.ErrorIs(t, err, torrent.ErrBitfieldSpareBits)
+	require.Zero(t, conn.PexFlags()&torrent.PexSeed)
+	require.NoError(t, conn.HandleMsg(&torrent.PeerMsg{Id: torrent.MsgBitfield, Payload: []byte{0xc0}}))
+	require.NotZero(t, conn.PexFlags()&torrent.PexSeed)
+
+	// 消息按 Decode 校验，未知类型的消息被忽略
+	require.ErrorIs(t, conn.HandleMsg(&torrent.PeerMsg{Id: torrent.MsgHave, Payload: make([]byte, 3)}), torrent.ErrMsgLength)
+	require.NoError(t, conn.HandleMsg(&torrent.PeerMsg{Id: 99}))
+}
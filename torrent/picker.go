@@ -0,0 +1,382 @@
+package torrent
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type PieceStrategy interface { // Piece 选择策略
+	// 从候选的 Piece 索引（按索引升序，非空）中选择下一个下载的 Piece，返回其在 candidates 中的位置；
+	// availability[i] 为拥有 Piece i 的连接数量，两者都不能被修改或保留
+	Pick(candidates []int, availability []int) int
+}
+
+type StrategyFunc func(candidates []int, availability []int) int // 以函数实现的自定义策略
+
+func (f StrategyFunc) Pick(candidates []int, availability []int) int {
+	return f(candidates, availability)
+}
+
+type RarestFirst struct{} // 最稀有优先（默认策略），可用度相同时随机选择
+
+func (RarestFirst) Pick(candidates []int, availability []int) int {
+	best, ties := 0, 1
+	for i := 1; i < len(candidates); i++ {
+		a, b := availability[candidates[i]], availability[candidates[best]]
+		switch {
+		case a < b:
+			best, ties = i, 1
+		case a == b: // 蓄水池抽样，使可用度相同的 Piece 被选中的概率相等
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+type Sequential struct{} // 按索引顺序（适合边下边播）
+
+func (Sequential) Pick(candidates []int, availability []int) int {
+	return 0
+}
+
+type Priority struct { // 按优先级选择，优先级相同时使用 Fallback
+	Priorities map[int]int   // Piece 索引 -> 优先级（越大越优先，未设置时为 0）
+	Fallback   PieceStrategy // 优先级相同时的策略（为 nil 时使用 RarestFirst）
+}
+
+func (p Priority) Pick(candidates []int, availability []int) int {
+	top := p.Priorities[candidates[0]]
+	for _, index := range candidates[1:] {
+		top = max(top, p.Priorities[index])
+	}
+	var positions, same []int
+	for i, index := range candidates {
+		if p.Priorities[index] == top {
+			positions = append(positions, i)
+			same = append(same, index)
+		}
+	}
+	fallback := p.Fallback
+	if fallback == nil {
+		fallback = RarestFirst{}
+	}
+	return positions[fallback.Pick(same, availability)]
+}
+
+type picker struct { // 按对端的 Bitfield 统计每个 Piece 的可用度，并按策略以块为单位分配下载
+	mu           sync.Mutex
+	strategy     PieceStrategy
+	pending      []*PieceTask          // 尚未开始下载的 Piece（索引 -> 任务，已开始或已完成时为 nil）
+	unstarted    int                   // 尚未开始下载的 Piece 数量
+	partial      map[int]*partialPiece // 正在下载的 Piece
+	availability []int                 // 拥有每个 Piece 的连接数量
+	wake         chan struct{}         // 有块重新变为可请求或被其他连接收到时关闭并替换，用于唤醒等待的连接
+	endgame      *atomic.Bool          // 是否处于终局模式（所有未收到的块都已被请求，指向 Context 中的状态）
+	failed       map[int][]failedPiece // 校验失败的 Piece 的下载记录
+}
+
+type partialPiece struct { // 正在下载的 Piece，其中的块可以从多个连接下载
+	task     *PieceTask
+	data     []byte
+	owners   [][]*PeerConn // 每个块的请求者（终局模式下可能有多个）
+	sources  []PeerInfo    // 每个块的来源（用于找出发送错误数据的对端）
+	received []bool        // 每个块是否已经收到
+	remain   int           // 尚未收到的块数量
+}
+
+func newPicker(strategy PieceStrategy, pieces int, endgame *atomic.Bool) *picker {
+	if strategy == nil {
+		strategy = RarestFirst{}
+	}
+	return &picker{
+		strategy:     strategy,
+		pending:      make([]*PieceTask, pieces),
+		partial:      make(map[int]*partialPiece),
+		availability: make([]int, pieces),
+		wake:         make(chan struct{}),
+		endgame:      endgame,
+		failed:       make(map[int][]failedPiece),
+	}
+}
+
+func newPartialPiece(task *PieceTask) *partialPiece {
+	blocks := (task.Length + BLOCK_SIZE - 1) / BLOCK_SIZE
+	return &partialPiece{
+		task:     task,
+		data:     make([]byte, task.Length),
+		owners:   make([][]*PeerConn, blocks),
+		sources:  make([]PeerInfo, blocks),
+		received: make([]bool, blocks),
+		remain:   blocks,
+	}
+}
+
+// 第 i 个块的请求
+func (pp *partialPiece) block(i int) blockRequest {
+	begin := i * BLOCK_SIZE
+	return blockRequest{pp.task.Index, begin, min(BLOCK_SIZE, pp.task.Length-begin)} // 最后一块的长度可能小于 Block Size
+}
+
+// 偏移为 begin 的块的序号（偏移未按块对齐或越界时返回 false）
+func (pp *partialPiece) blockAt(begin int) (int, bool) {
+	if begin%BLOCK_SIZE != 0 || begin < 0 || begin >= pp.task.Length {
+		return 0, false
+	}
+	return begin / BLOCK_SIZE, true
+}
+
+// 添加待下载的 Piece（开始下载前调用）
+func (p *picker) add(task *PieceTask) {
+	p.pending[task.Index] = task
+	p.unstarted++
+}
+
+// 唤醒等待的连接（需持有锁）
+func (p *picker) notify() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// 用于等待块的状态变化的通道（需在检查状态之前获取，以免错过通知）
+func (p *picker) waiter() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.wake
+}
+
+// 丢弃 Piece 已下载的块并重新下载（校验或写入失败时）
+func (p *picker) push(task *PieceTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.partial, task.Index)
+	p.add(task)
+	p.endgame.Store(false)
+	p.notify()
+}
+
+// 为连接分配一个块，没有可请求的块时返回 false；校验失败的 Piece 优先从未参与上次下载的对端重新下载
+func (p *picker) request(conn *PeerConn) (blockRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req, ok := p.assign(conn, false); ok || len(p.failed) == 0 {
+		return req, ok
+	}
+	return p.assign(conn, true)
+}
+
+// 优先完成正在下载的 Piece，其次按策略开始新的 Piece（优先选择对端建议的 Piece）；所有未收到的块都已被请求时
+// 进入终局模式，向该连接重复请求其他连接正在下载的块；suspect 为是否允许请求该连接参与过且校验失败的 Piece（需持有锁）
+func (p *picker) assign(conn *PeerConn, suspect bool) (blockRequest, bool) {
+	usable := func(index int) bool {
+		return conn.Field.HasPiece(index) && conn.canRequest(index) && (suspect || !p.isSuspect(index, conn))
+	}
+	free := false // 是否还有未被请求的块（包括该连接无法请求的）
+	for index, pp := range p.partial {
+		for i, owners := range pp.owners {
+			if len(owners) > 0 || pp.received[i] {
+				continue
+			}
+			if usable(index) {
+				pp.owners[i] = append(owners, conn)
+				return pp.block(i), true
+			}
+			free = true
+		}
+	}
+
+	if p.unstarted > 0 {
+		index := -1
+		for _, i := range conn.suggested {
+			if i < len(p.pending) && p.pending[i] != nil && usable(i) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			var candidates []int
+			for i, task := range p.pending {
+				if task != nil && usable(i) {
+					candidates = append(candidates, i)
+				}
+			}
+			if len(candidates) == 0 {
+				return blockRequest{}, false
+			}
+			index = candidates[p.strategy.Pick(candidates, p.availability)]
+		}
+		conn.removeSuggested(index)
+		pp := newPartialPiece(p.pending[index])
+		p.pending[index] = nil
+		p.unstarted--
+		p.partial[index] = pp
+		pp.owners[0] = []*PeerConn{conn}
+		return pp.block(0), true
+	}
+	if free {
+		return blockRequest{}, false
+	}
+
+	// 终局模式：重复请求请求者最少的块
+	p.endgame.Store(true)
+	var best *partialPiece
+	bestBlock := 0
+	for index, pp := range p.partial {
+		if !usable(index) {
+			continue
+		}
+		for i, owners := range pp.owners {
+			if pp.received[i] || slices.Contains(owners, conn) {
+				continue
+			}
+			if best == nil || len(owners) < len(best.owners[bestBlock]) {
+				best, bestBlock = pp, i
+			}
+		}
+	}
+	if best == nil {
+		return blockRequest{}, false
+	}
+	best.owners[bestBlock] = append(best.owners[bestBlock], conn)
+	return best.block(bestBlock), true
+}
+
+// 连接请求的块是否仍需要从该连接下载（终局模式下块可能已被其他连接收到）
+func (p *picker) owns(conn *PeerConn, req blockRequest) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pp, ok := p.partial[req.Index]
+	if !ok {
+		return false
+	}
+	i, ok := pp.blockAt(req.Begin)
+	return ok && slices.Contains(pp.owners[i], conn)
+}
+
+// 保存 conn 收到的块，返回块是否被接受；Piece 的所有块都已收到时同时返回该 Piece
+func (p *picker) receive(conn *PeerConn, index, begin int, data []byte) (bool, *partialPiece) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pp, ok := p.partial[index]
+	if !ok {
+		return false, nil
+	}
+	i, ok := pp.blockAt(begin)
+	if !ok || pp.received[i] || len(data) != pp.block(i).Length {
+		return false, nil
+	}
+	copy(pp.data[begin:], data)
+	pp.received[i] = true
+	pp.sources[i] = conn.peer
+	if slices.ContainsFunc(pp.owners[i], func(c *PeerConn) bool { return c != conn }) {
+		p.notify() // 其他请求了该块的连接需要发送 Cancel
+	}
+	pp.owners[i] = nil
+	if pp.remain--; pp.remain > 0 {
+		return true, nil
+	}
+	delete(p.partial, index)
+	return true, pp
+}
+
+// 连接不会再发送这些块（被拒绝、被阻塞或连接断开），将其交给其他连接请求；已收到的块保留
+func (p *picker) release(conn *PeerConn, reqs ...blockRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	released := false
+	for _, req := range reqs {
+		pp, ok := p.partial[req.Index]
+		if !ok {
+			continue
+		}
+		i, ok := pp.blockAt(req.Begin)
+		if !ok {
+			continue
+		}
+		if j := slices.Index(pp.owners[i], conn); j >= 0 {
+			pp.owners[i] = slices.Delete(pp.owners[i], j, j+1)
+			if len(pp.owners[i]) == 0 {
+				released = true
+			}
+		}
+	}
+	if released {
+		p.notify()
+	}
+}
+
+// 是否还需要下载 Piece（尚未开始或正在下载）
+func (p *picker) needs(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index < 0 || index >= len(p.pending) {
+		return false
+	}
+	_, ok := p.partial[index]
+	return ok || p.pending[index] != nil
+}
+
+// 拥有 field 的对端是否有需要下载的 Piece
+func (p *picker) interesting(field Bitfield) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for index := range p.partial {
+		if field.HasPiece(index) {
+			return true
+		}
+	}
+	if p.unstarted == 0 {
+		return false
+	}
+	for index, task := range p.pending {
+		if task != nil && field.HasPiece(index) {
+			return true
+		}
+	}
+	return false
+}
+
+// 对端新拥有了 Piece
+func (p *picker) have(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index >= 0 && index < len(p.availability) {
+		p.availability[index]++
+	}
+}
+
+// 对端的 Bitfield 从 old 变为 new（连接断开时 new 为 nil）
+func (p *picker) update(old, new Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for index := range p.availability {
+		if old.HasPiece(index) {
+			p.availability[index]--
+		}
+		if new.HasPiece(index) {
+			p.availability[index]++
+		}
+	}
+}
+
+// 每个 Piece 的可用度的副本
+func (p *picker) counts() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int(nil), p.availability...)
+}
+
+// 每个 Piece 的可用度（拥有该 Piece 的连接数量），未开始下载时为 nil
+func (t *TorrentTask) Availability() []int {
+	t.rwm.RLock()
+	p := t.picker
+	t.rwm.RUnlock()
+	if p == nil {
+		return nil
+	}
+	return p.counts()
+}
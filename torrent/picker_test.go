@@ -0,0 +1,244 @@
+package torrent_test
+
+import (
+	"io"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrategies(t *testing.T) {
+	candidates := []int{0, 2, 3, 5}
+	availability := []int{1, 0, 3, 1, 0, 4}
+
+	// 最稀有优先，可用度相同时随机选择
+	picked := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		picked[candidates[torrent.RarestFirst{}.Pick(candidates, availability)]]++
+	}
+	require.Len(t, picked, 2)
+	require.NotZero(t, picked[0])
+	require.NotZero(t, picked[3])
+
+	require.Equal(t, 0, torrent.Sequential{}.Pick(candidates, availability))
+
+	priority := torrent.Priority{Priorities: map[int]int{2: 1, 5: 1, 0: -1}}
+	require.Equal(t, 1, priority.Pick(candidates, availability)) // 2 与 5 优先级最高，2 更稀有
+	priority.Fallback = torrent.StrategyFunc(func(candidates []int, availability []int) int {
+		return len(candidates) - 1
+	})
+	require.Equal(t, 3, priority.Pick(candidates, availability))
+}
+
+func TestPickerDownload(t *testing.T) {
+	seeder, data := newTestTask(t, "picker", 100000, 20000)
+	peer := startSeeder(t, seeder, data)
+
+	var picks, order []int
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{peer},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+		Strategy: torrent.StrategyFunc(func(candidates []int, availability []int) int {
+			for _, index := range candidates {
+				if availability[index] != 1 {
+					panic("unexpected availability")
+				}
+			}
+			picks = append(picks, candidates[len(candidates)-1])
+			return len(candidates) - 1 // 倒序下载
+		}),
+	}
+	ctx := leecher.Download()
+	for res := range ctx.GetResult() {
+		order = append(order, res.Index)
+	}
+	require.Equal(t, picks, order) // 阻塞期间可能先下载允许快速下载的 Piece
+	require.ElementsMatch(t, []int{0, 1, 2, 3, 4}, order)
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+	require.Equal(t, []int{1, 1, 1, 1, 1}, leecher.Availability())
+	require.Eventually(t, func() bool { // 做种者通过 Have 消息统计下载者的 Piece
+		return slices.Equal([]int{1, 1, 1, 1, 1}, seeder.Availability())
+	}, time.Second, 10*time.Millisecond)
+}
+
+type countingStorage struct { // 统计读取字节数的存储
+	*memStorage
+	read atomic.Int64
+}
+
+func (s *countingStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.read.Add(int64(len(p)))
+	return s.memStorage.ReadAt(p, off)
+}
+
+func TestBlockScheduling(t *testing.T) {
+	seeder, data := newTestTask(t, "blocks", 4*torrent.BLOCK_SIZE, 4*torrent.BLOCK_SIZE)
+	peer := startSeeder(t, seeder, data)
+	storage := &countingStorage{memStorage: seeder.Storage.(*memStorage)}
+	seeder.Storage = storage
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	// 模拟的对端：只发送前两个请求的块，之后断开连接
+	served := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		hs, err := torrent.ReadHandshake(conn)
+		if err != nil {
+			return
+		}
+		if torrent.NewHandShakeMsg(hs.InfoSHA, [torrent.PEER_ID_LEN]byte{'p'}).WriteHandShakeMsg(conn) != nil {
+			return
+		}
+		pc := &torrent.PeerConn{Conn: conn}
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgHaveAll})
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgUnchoke})
+		for n := 0; n < 2; {
+			msg, err := pc.ReadMsg()
+			if err != nil {
+				return
+			}
+			if msg == nil || msg.Id != torrent.MsgRequest {
+				continue
+			}
+			index, begin, length, _ := msg.GetRequest()
+			pc.WriteMsg(torrent.NewPieceMsg(index, begin, data[begin:begin+length]))
+			n++
+		}
+		conn.(*net.TCPConn).CloseWrite()
+		close(served)
+		io.Copy(io.Discard, conn)
+	}()
+
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+	}
+	ctx := leecher.Download()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("partial peer timeout")
+	}
+	leecher.AddPeers(peer) // 同一个 Piece 的其余块从做种者下载
+	for range ctx.GetResult() {
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+	require.Equal(t, int64(2*torrent.BLOCK_SIZE), storage.read.Load()) // 断开前收到的块被保留
+}
+
+func TestEndgame(t *testing.T) {
+	seeder, data := newTestTask(t, "endgame", 4*torrent.BLOCK_SIZE, 4*torrent.BLOCK_SIZE)
+	peer := startSeeder(t, seeder, data)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	// 模拟的慢速对端：接受所有请求但从不发送数据
+	requested := make(chan struct{})
+	cancels := make(chan torrent.PeerMsg, 8)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		hs, err := torrent.ReadHandshake(conn)
+		if err != nil {
+			return
+		}
+		if torrent.NewHandShakeMsg(hs.InfoSHA, [torrent.PEER_ID_LEN]byte{'p'}).WriteHandShakeMsg(conn) != nil {
+			return
+		}
+		pc := &torrent.PeerConn{Conn: conn}
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgHaveAll})
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgUnchoke})
+		n := 0
+		for {
+			msg, err := pc.ReadMsg()
+			if err != nil {
+				return
+			}
+			if msg == nil {
+				continue
+			}
+			switch msg.Id {
+			case torrent.MsgRequest:
+				if n++; n == 4 {
+					close(requested)
+				}
+			case torrent.MsgCancel:
+				cancels <- *msg
+			}
+		}
+	}()
+
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+	}
+	ctx := leecher.Download()
+	select {
+	case <-requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request timeout")
+	}
+	leecher.AddPeers(peer) // 所有块都已向慢速对端请求，做种者重复请求这些块
+	start := time.Now()
+	for range ctx.GetResult() {
+	}
+	require.Less(t, time.Since(start), torrent.REQUEST_TIMEOUT)
+	require.True(t, ctx.InEndgame())
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+
+	// 慢速对端收到所有块的 Cancel
+	var begins []int
+	for len(begins) < 4 {
+		select {
+		case msg := <-cancels:
+			index, begin, length, err := msg.GetRequest()
+			require.NoError(t, err)
+			require.Equal(t, 0, index)
+			require.Equal(t, torrent.BLOCK_SIZE, length)
+			begins = append(begins, begin)
+		case <-time.After(3 * time.Second):
+			t.Fatal("cancel timeout")
+		}
+	}
+	require.ElementsMatch(t, []int{0, torrent.BLOCK_SIZE, 2 * torrent.BLOCK_SIZE, 3 * torrent.BLOCK_SIZE}, begins)
+	bytes, pieces := ctx.GetProcess()
+	require.Equal(t, uint64(len(data)), bytes)
+	require.Equal(t, uint64(1), pieces)
+}
@@ -0,0 +1,67 @@
+package torrent
+
+import "time"
+
+type pipeline struct { // 按对端的下载速度与往返时间调整请求队列的长度（参考 libtorrent）
+	size      int           // 期望的未完成请求数量（块）
+	slowStart bool          // 是否处于慢启动（每收到一个块队列长度加一，直到速度不再增长）
+	rtt       time.Duration // 平滑的往返时间（请求发出到收到块的时间）
+	rate      float64       // 平滑的下载速度（字节/秒）
+	peak      float64       // 慢启动期间的最高速度
+	bytes     int           // 当前统计周期内收到的字节数
+	since     time.Time     // 当前统计周期的开始时间
+}
+
+func newPipeline(now time.Time) *pipeline {
+	return &pipeline{size: MIN_REQUEST_QUEUE, slowStart: true, since: now}
+}
+
+// 收到 n 字节的块，sent 为该块的请求发出的时间
+func (pl *pipeline) received(n int, sent, now time.Time) {
+	if sample := now.Sub(sent); pl.rtt == 0 {
+		pl.rtt = sample
+	} else {
+		pl.rtt += (sample - pl.rtt) / 8
+	}
+	pl.bytes += n
+	if pl.slowStart {
+		pl.size = min(pl.size+1, MAX_REQUEST_QUEUE)
+	}
+	elapsed := now.Sub(pl.since)
+	if elapsed < PIPELINE_INTERVAL {
+		return
+	}
+	rate := float64(pl.bytes) / elapsed.Seconds()
+	pl.bytes, pl.since = 0, now
+	if pl.rate == 0 {
+		pl.rate = rate
+	} else {
+		pl.rate += (rate - pl.rate) / 4
+	}
+	if pl.slowStart {
+		if rate < pl.peak*SLOW_START_GROWTH { // 速度不再明显增长，队列已足够长
+			pl.slowStart = false
+		}
+		pl.peak = max(pl.peak, rate)
+		return
+	}
+	// 队列中的数据足够对端在往返时间加上 REQUEST_QUEUE_TIME 内持续发送
+	pl.size = int(pl.rate*(pl.rtt+REQUEST_QUEUE_TIME).Seconds()/BLOCK_SIZE) + 1
+}
+
+// 允许的未完成请求数量（不超过对端在扩展握手中声明的 reqq）
+func (pl *pipeline) limit(reqq int) int {
+	upper := MAX_REQUEST_QUEUE
+	if reqq > 0 {
+		upper = min(upper, reqq)
+	}
+	return min(max(pl.size, MIN_REQUEST_QUEUE), upper)
+}
+
+// 对端声明的 reqq（未收到扩展握手时为 0）
+func (c *PeerConn) reqq() int {
+	if h := c.ext.Load(); h != nil {
+		return h.Reqq
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package torrent_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+// 以模拟的高延迟对端下载，返回对端观察到的最大未完成请求数量
+func maxOutstanding(t *testing.T, reqq int) int {
+	task, data := newTestTask(t, "pipeline", 64*torrent.BLOCK_SIZE, 4*torrent.BLOCK_SIZE)
+	task.PeerId = [torrent.PEER_ID_LEN]byte{'l'}
+	task.Storage = &memStorage{data: make([]byte, len(data))}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+	task.PeerList = []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}}
+
+	// 模拟的对端：每个请求延迟 20ms 后响应
+	var mu sync.Mutex
+	outstanding, peak := 0, 0
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		hs, err := torrent.ReadHandshake(conn)
+		if err != nil {
+			return
+		}
+		if torrent.NewHandShakeMsg(hs.InfoSHA, [torrent.PEER_ID_LEN]byte{'p'}).WriteHandShakeMsg(conn) != nil {
+			return
+		}
+		pc := &torrent.PeerConn{Conn: conn}
+		pc.WriteMsg((&torrent.ExtHandshake{Reqq: reqq}).Encode())
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgHaveAll})
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgUnchoke})
+		for {
+			msg, err := pc.ReadMsg()
+			if err != nil {
+				return
+			}
+			if msg == nil || msg.Id != torrent.MsgRequest {
+				continue
+			}
+			index, begin, length, _ := msg.GetRequest()
+			mu.Lock()
+			outstanding++
+			peak = max(peak, outstanding)
+			mu.Unlock()
+			time.AfterFunc(20*time.Millisecond, func() {
+				mu.Lock()
+				outstanding--
+				mu.Unlock()
+				offset := index*task.PieceLen + begin
+				pc.WriteMsg(torrent.NewPieceMsg(index, begin, data[offset:offset+length]))
+			})
+		}
+	}()
+
+	ctx := task.Download()
+	for range ctx.GetResult() {
+	}
+	require.Equal(t, data, task.Storage.(*memStorage).data)
+	mu.Lock()
+	defer mu.Unlock()
+	return peak
+}
+
+func TestRequestPipeline(t *testing.T) {
+	require.Greater(t, maxOutstanding(t, 0), 16)    // 慢启动使队列长度随收到的块增长
+	require.LessOrEqual(t, maxOutstanding(t, 8), 8) // 不超过对端声明的 reqq
+}
@@ -0,0 +1,54 @@
+package torrent_test
+
+import (
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/Akimio521/torrent-go/proxy"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyOnly(t *testing.T) {
+	seeder, data := newTestTask(t, "proxy", 100000, 40000)
+	peer := startSeeder(t, seeder, data)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	server := &proxy.Server{Username: "user", Password: "pass"}
+	go server.Serve(ln)
+
+	// 只通过代理连接对端
+	m := &torrent.ConnManager{
+		Proxy:     &proxy.SOCKS5{Addr: ln.Addr().String(), Username: "user", Password: "pass"},
+		ProxyOnly: true,
+	}
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{peer},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+		Manager:  m,
+	}
+	for range leecher.Download().GetResult() {
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+	require.True(t, slices.Contains(server.Requests(), proxy.Request{Network: "tcp", Addr: peer.GetConnAddr()}))
+
+	// 不接受连入的连接
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := torrent.NewListener(plain)
+	listener.Register(leecher)
+	go listener.Serve()
+	defer listener.Close()
+	_, _, err = dialHandshake(t, listener.Addr(), leecher.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,172 @@
+package torrent
+
+import (
+	"sync"
+	"time"
+)
+
+type RateLimiter struct { // 令牌桶限速器（字节/秒），可以在运行时修改速度，多个连接可以共享同一个限速器
+	mu      sync.Mutex
+	rate    int           // 每秒生成的令牌数量（为 0 时不限制）
+	tokens  float64       // 当前的令牌数量（为负数时表示透支，需要等待补足）
+	last    time.Time     // 上次补充令牌的时间
+	changed chan struct{} // 速度变化时关闭并替换，用于唤醒等待的连接
+}
+
+// 创建速度为 rate 字节/秒的限速器（rate 为 0 时不限制）
+func NewRateLimiter(rate int) *RateLimiter {
+	return &RateLimiter{
+		rate:    max(rate, 0),
+		tokens:  float64(max(rate, 0)),
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// 当前的速度（字节/秒，为 0 时不限制）
+func (l *RateLimiter) Rate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// 修改速度（rate 为 0 时不限制），正在等待的连接按新的速度继续
+func (l *RateLimiter) SetRate(rate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = max(rate, 0)
+	l.tokens = min(l.tokens, float64(l.rate))
+	if l.rate == 0 {
+		l.tokens = 0
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// 按经过的时间补充令牌，最多积累 1 秒的令牌（需持有锁）
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+	}
+	l.last = now
+}
+
+// 取出 n 个令牌，令牌不足时等待补足；消息可以大于 1 秒的令牌（透支后由之后的消息等待），
+// 因此不需要拆分消息。done 先被关闭时返回 false（l 为 nil 时不限制）
+func (l *RateLimiter) wait(n int, done <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	l.refill(time.Now())
+	if l.rate > 0 {
+		l.tokens -= float64(n)
+	}
+	l.mu.Unlock()
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		rate, tokens, changed := l.rate, l.tokens, l.changed
+		l.mu.Unlock()
+		if rate == 0 || tokens >= 0 {
+			return true
+		}
+		timer := time.NewTimer(time.Duration(-tokens / float64(rate) * float64(time.Second)))
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-done:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// 依次从所有限速器取出 n 个令牌
+func waitAll(limiters []*RateLimiter, n int, done <-chan struct{}) bool {
+	for _, l := range limiters {
+		if !l.wait(n, done) {
+			return false
+		}
+	}
+	return true
+}
+
+type RateRule struct { // 时间段内的速度限制
+	Start    time.Duration // 开始时间（距离当天 0 点）
+	End      time.Duration // 结束时间（距离当天 0 点，小于 Start 时跨越午夜）
+	Upload   int           // 上传速度（字节/秒，为 0 时不限制）
+	Download int           // 下载速度（字节/秒，为 0 时不限制）
+}
+
+type RateSchedule struct { // 按一天中的时间调整限速器的速度（例如夜间不限速）
+	Upload          *RateLimiter // 被调整的上传限速器（为 nil 时忽略）
+	Download        *RateLimiter // 被调整的下载限速器（为 nil 时忽略）
+	Rules           []RateRule   // 时间段规则，使用第一个包含当前时间的规则
+	DefaultUpload   int          // 不在任何时间段内时的上传速度
+	DefaultDownload int          // 不在任何时间段内时的下载速度
+}
+
+// 时间段是否包含 now
+func (r RateRule) contains(now time.Time) bool {
+	y, m, d := now.Date()
+	offset := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	if r.Start <= r.End {
+		return offset >= r.Start && offset < r.End
+	}
+	return offset >= r.Start || offset < r.End
+}
+
+// 按 now 所在的时间段设置限速器的速度
+func (s *RateSchedule) Apply(now time.Time) {
+	upload, download := s.DefaultUpload, s.DefaultDownload
+	for _, r := range s.Rules {
+		if r.contains(now) {
+			upload, download = r.Upload, r.Download
+			break
+		}
+	}
+	if s.Upload != nil && s.Upload.Rate() != upload {
+		s.Upload.SetRate(upload)
+	}
+	if s.Download != nil && s.Download.Rate() != download {
+		s.Download.SetRate(download)
+	}
+}
+
+// 立即应用一次，之后每隔 RATE_SCHEDULE_INTERVAL 应用一次，直到 stop 被关闭
+func (s *RateSchedule) Run(stop <-chan struct{}) {
+	s.Apply(time.Now())
+	ticker := time.NewTicker(RATE_SCHEDULE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Apply(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 修改该任务每个连接的速度限制（字节/秒，为 0 时不限制），对已有的连接立即生效
+func (t *TorrentTask) SetPeerRateLimit(upload, download int) {
+	t.rwm.Lock()
+	defer t.rwm.Unlock()
+	t.PeerUploadLimit, t.PeerDownloadLimit = upload, download
+	for c := range t.conns {
+		c.upLimits[0].SetRate(upload)
+		c.downLimits[0].SetRate(download)
+	}
+}
+
+// 为连接设置限速器：连接自身、任务与全局（依次等待）
+func (t *TorrentTask) initLimits(c *PeerConn) {
+	m := t.manager()
+	t.rwm.RLock()
+	c.upLimits = []*RateLimiter{NewRateLimiter(t.PeerUploadLimit), t.UploadLimit, m.UploadLimit}
+	c.downLimits = []*RateLimiter{NewRateLimiter(t.PeerDownloadLimit), t.DownloadLimit, m.DownloadLimit}
+	t.rwm.RUnlock()
+}
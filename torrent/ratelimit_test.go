@@ -0,0 +1,89 @@
+package torrent_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateSchedule(t *testing.T) {
+	up, down := torrent.NewRateLimiter(0), torrent.NewRateLimiter(0)
+	s := &torrent.RateSchedule{
+		Upload:   up,
+		Download: down,
+		Rules: []torrent.RateRule{
+			{Start: 9 * time.Hour, End: 18 * time.Hour, Upload: 10000, Download: 50000}, // 白天限速
+			{Start: 23 * time.Hour, End: 6 * time.Hour},                                 // 夜间不限速（跨越午夜）
+		},
+		DefaultUpload:   20000,
+		DefaultDownload: 100000,
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	for _, c := range []struct {
+		now      time.Time
+		up, down int
+	}{
+		{at(12, 0), 10000, 50000},
+		{at(18, 0), 20000, 100000},
+		{at(23, 30), 0, 0},
+		{at(3, 0), 0, 0},
+		{at(6, 0), 20000, 100000},
+		{at(9, 0), 10000, 50000},
+	} {
+		s.Apply(c.now)
+		require.Equal(t, c.up, up.Rate(), c.now)
+		require.Equal(t, c.down, down.Rate(), c.now)
+	}
+}
+
+func TestRateLimitedDownload(t *testing.T) {
+	seeder, data := newTestTask(t, "ratelimit", 200000, 40000)
+	peer := startSeeder(t, seeder, data)
+	newLeecher := func(id byte) *torrent.TorrentTask {
+		return &torrent.TorrentTask{
+			FileName: seeder.FileName,
+			FileLen:  seeder.FileLen,
+			InfoSHA:  seeder.InfoSHA,
+			PieceLen: seeder.PieceLen,
+			PieceSHA: seeder.PieceSHA,
+			PeerId:   [torrent.PEER_ID_LEN]byte{id},
+			PeerList: []torrent.PeerInfo{peer},
+			Storage:  &memStorage{data: make([]byte, len(data))},
+			Manager:  &torrent.ConnManager{},
+		}
+	}
+
+	// 任务的下载限速：开始时积累 1 秒的令牌，其余的数据按速度下载
+	leecher := newLeecher('a')
+	leecher.DownloadLimit = torrent.NewRateLimiter(100000)
+	start := time.Now()
+	for range leecher.Download().GetResult() {
+	}
+	require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+
+	// 每个连接的上传限速可以在运行时修改
+	seeder.SetPeerRateLimit(1000, 0)
+	leecher = newLeecher('b')
+	ctx := leecher.Download()
+	done := make(chan struct{})
+	go func() {
+		for range ctx.GetResult() {
+		}
+		close(done)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	_, pieces := ctx.GetProcess()
+	require.Zero(t, pieces)
+	seeder.SetPeerRateLimit(0, 0)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download should finish after the limit is removed")
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+}
@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+)
+
+type failedPiece struct { // 校验失败的 Piece 中每个块的来源与哈希
+	sources []PeerInfo
+	hashes  [][sha1.Size]byte
+}
+
+type PeerBannedError struct { // 对端发送了错误的数据而被封禁（通过 Context 的错误通道通知）
+	Peer  PeerInfo // 被封禁的对端
+	Piece int      // 校验失败的 Piece
+}
+
+func (e *PeerBannedError) Error() string {
+	return fmt.Sprintf("banned peer %s: sent corrupt data for piece %d", e.Peer.GetConnAddr(), e.Piece)
+}
+
+// 每个块的哈希
+func (pp *partialPiece) blockHashes() [][sha1.Size]byte {
+	hashes := make([][sha1.Size]byte, len(pp.received))
+	for i := range hashes {
+		b := pp.block(i)
+		hashes[i] = sha1.Sum(pp.data[b.Begin : b.Begin+b.Length])
+	}
+	return hashes
+}
+
+// 连接的 IP 是否参与过该 Piece 校验失败的下载
+func (p *picker) isSuspect(index int, conn *PeerConn) bool {
+	for _, f := range p.failed[index] {
+		for _, source := range f.sources {
+			if source.IP.Equal(conn.peer.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 记录校验失败的 Piece 中每个块的来源与哈希并重新下载；所有块来自同一个 IP 时返回该对端
+func (p *picker) fail(pp *partialPiece) []PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	index := pp.task.Index
+	p.failed[index] = append(p.failed[index], failedPiece{pp.sources, pp.blockHashes()})
+	p.add(pp.task)
+	p.endgame.Store(false)
+	p.notify()
+	for _, source := range pp.sources[1:] {
+		if !source.IP.Equal(pp.sources[0].IP) {
+			return nil
+		}
+	}
+	return pp.sources[:1]
+}
+
+// 校验通过的 Piece 与之前校验失败的下载逐块比较，返回发送了不同数据的对端
+func (p *picker) verify(pp *partialPiece) []PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	index := pp.task.Index
+	failed, ok := p.failed[index]
+	if !ok {
+		return nil
+	}
+	delete(p.failed, index)
+	hashes := pp.blockHashes()
+	var bad []PeerInfo
+	seen := make(map[string]struct{})
+	for _, f := range failed {
+		for i, h := range f.hashes {
+			ip := f.sources[i].IP.String()
+			if _, ok := seen[ip]; ok || h == hashes[i] {
+				continue
+			}
+			seen[ip] = struct{}{}
+			bad = append(bad, f.sources[i])
+		}
+	}
+	return bad
+}
+
+// 封禁发送了错误数据的对端，断开与其 IP 的所有连接，并通过错误通道通知
+func (t *TorrentTask) banPeer(ctx *Context, peer PeerInfo, index int) {
+	t.manager().Ban(peer.IP)
+	t.rwm.RLock()
+	for c := range t.conns {
+		if c.peer.IP.Equal(peer.IP) {
+			c.Close()
+		}
+	}
+	t.rwm.RUnlock()
+	ctx.pushErr(&PeerBannedError{peer, index})
+}
@@ -0,0 +1,102 @@
+package torrent_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmartBan(t *testing.T) {
+	seeder, data := newTestTask(t, "smartban", 2*torrent.BLOCK_SIZE, 2*torrent.BLOCK_SIZE)
+	peer := startSeeder(t, seeder, data)
+
+	ln, err := net.Listen("tcp", "127.0.0.2:0") // 与做种者使用不同的 IP
+	if err != nil {
+		t.Skip("127.0.0.2 is not available:", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().(*net.TCPAddr)
+
+	// 模拟的恶意对端：第一个块发送错误的数据，之后阻塞并拒绝其余请求
+	sent := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		hs, err := torrent.ReadHandshake(conn)
+		if err != nil {
+			return
+		}
+		if torrent.NewHandShakeMsg(hs.InfoSHA, [torrent.PEER_ID_LEN]byte{'b'}).WriteHandShakeMsg(conn) != nil {
+			return
+		}
+		pc := &torrent.PeerConn{Conn: conn}
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgHaveAll})
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgUnchoke})
+		var requests []*torrent.PeerMsg
+		for len(requests) < 2 {
+			msg, err := pc.ReadMsg()
+			if err != nil {
+				return
+			}
+			if msg != nil && msg.Id == torrent.MsgRequest {
+				requests = append(requests, msg)
+			}
+		}
+		index, begin, length, _ := requests[0].GetRequest()
+		pc.WriteMsg(torrent.NewPieceMsg(index, begin, make([]byte, length)))
+		pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgChoke})
+		index, begin, length, _ = requests[1].GetRequest()
+		pc.WriteMsg(torrent.NewRejectMsg(index, begin, length))
+		close(sent)
+		io.Copy(io.Discard, conn)
+	}()
+
+	manager := &torrent.ConnManager{}
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{{IP: addr.IP, Port: uint16(addr.Port)}},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+		Manager:  manager,
+	}
+	ctx := leecher.Download()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bad peer timeout")
+	}
+	leecher.AddPeers(peer) // 第二个块来自做种者，校验失败后从做种者重新下载
+	go func() {
+		for range ctx.GetResult() {
+		}
+	}()
+
+	var banned *torrent.PeerBannedError
+	for banned == nil {
+		select {
+		case err := <-ctx.GetErr():
+			errors.As(err, &banned)
+		case <-time.After(5 * time.Second):
+			t.Fatal("ban timeout")
+		}
+	}
+	require.True(t, banned.Peer.IP.Equal(addr.IP))
+	require.Equal(t, addr.String(), banned.Peer.GetConnAddr())
+	require.Equal(t, 0, banned.Piece)
+	require.True(t, manager.IsBanned(addr.IP))
+	require.False(t, manager.IsBanned(peer.IP))
+	<-ctx.Done()
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+}
@@ -3,57 +3,395 @@ package torrent
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"net"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/Akimio521/torrent-go/mse"
+	"github.com/Akimio521/torrent-go/utp"
 )
 
+type PeerFinder interface { // Tracker 以外的 Peer 发现途径（如 DHT）
+	// 查找拥有指定种子的 Peer，并宣告本机正在通过 port 下载该种子
+	FindPeers(infoSHA [sha1.Size]byte, port uint16) ([]PeerInfo, error)
+}
+
+type PeerWatcher interface { // 运行时持续发现 Peer 的途径（如 LSD），任务开始时可以没有 Peer
+	PeerFinder
+	// 开始为指定种子发现 Peer，发现时调用 onPeer
+	Watch(infoSHA [sha1.Size]byte, onPeer func(PeerInfo))
+}
+
 type TorrentTask struct { // 种子任务
-	FileName string            // 文件名
-	FileLen  int               // 文件长度
-	InfoSHA  [sha1.Size]byte   // 种子的 Info 的 SHA-1 哈希
-	PeerList []PeerInfo        // Peer 列表
-	PeerId   [20]byte          // 本地 Peer ID
-	PieceLen int               // 每一块 Piece 的长度
-	PieceSHA [][sha1.Size]byte // 所有 Piece 的 SHA-1 哈希值
-}
-
-func (t *TorrentTask) peerRoutine(peer PeerInfo, taskChan chan *PieceTask, ctx *Context) {
-	// set up conn with peer
-	conn, err := peer.NewConn(t.InfoSHA, t.PeerId)
-	if err != nil {
-		ctx.errChan <- fmt.Errorf("connect peer %s failed: %s", peer.IP.String(), err.Error())
+	FileName    string              // 文件名
+	FileLen     int                 // 文件长度
+	InfoSHA     [sha1.Size]byte     // 种子的 Info 的 SHA-1 哈希
+	PeerList    []PeerInfo          // Peer 列表
+	PeerId      [20]byte            // 本地 Peer ID
+	Port        uint16              // 本地监听端口
+	PieceLen    int                 // 每一块 Piece 的长度
+	PieceSHA    [][sha1.Size]byte   // 所有 Piece 的 SHA-1 哈希值
+	Finders     []PeerFinder        // 额外的 Peer 发现途径
+	Private     bool                // 私有种子（BEP 27），不使用 PEX 等 Tracker 以外的 Peer 发现途径
+	Storage     Storage             // 数据存储（设置后下载的 Piece 会写入其中，并向其他 Peer 上传；GetResult 只通知完成的 Piece，无需读取）
+	Encryption  EncryptionPolicy    // 连接加密（MSE/PE）策略
+	UTP         *utp.Socket         // uTP 套接字（为 nil 时只使用 TCP）
+	Transport   TransportPreference // 连接对端时优先使用的传输协议
+	UploadSlots int                 // 上传槽位数量（按速度解除阻塞的对端数量，不含乐观解除阻塞；为 0 时使用 DEFAULT_UPLOAD_SLOTS）
+	Strategy    PieceStrategy       // Piece 选择策略（为 nil 时使用 RarestFirst）
+	MaxConns    int                 // 该任务的最大连接数量（为 0 时使用 DEFAULT_TORRENT_CONNS）
+	Manager     *ConnManager        // 连接管理（为 nil 时使用 DefaultConnManager）
+
+	UploadLimit       *RateLimiter // 该任务的上传限速（为 nil 时不限制）
+	DownloadLimit     *RateLimiter // 该任务的下载限速（为 nil 时不限制）
+	PeerUploadLimit   int          // 每个连接的上传速度限制（字节/秒，为 0 时不限制，运行时通过 SetPeerRateLimit 修改）
+	PeerDownloadLimit int          // 每个连接的下载速度限制（字节/秒，为 0 时不限制，运行时通过 SetPeerRateLimit 修改）
+
+	rwm        sync.RWMutex            // 保护以下运行时字段
+	ctx        *Context                // 正在进行的下载（未开始下载时为 nil）
+	picker     *picker                 // 待下载的 Piece 与其可用度
+	pool       map[string]*candidate   // 候选池：已知 Peer 的连接地址 -> 连接状态（用于去重与重连）
+	poolSignal chan struct{}           // 候选池或连接数量变化时通知连接协程
+	pending    int                     // 正在连接或已连接但尚未加入 conns 的连接数量（计入连接数量限制）
+	incoming   map[string]int          // 连入连接的 IP -> 连接数量（不再连接这些 IP 上的候选）
+	have       Bitfield                // 本地已拥有的 Piece
+	conns      map[*PeerConn]*uploader // 活动的连接及其上传者
+	choker     choker                  // 阻塞算法的状态
+}
+
+// 向任务中添加 Peer，已知的 Peer 会被忽略；下载进行中时由连接协程按连接限制连接新的 Peer
+func (t *TorrentTask) AddPeers(peers ...PeerInfo) {
+	t.rwm.Lock()
+	t.initPool()
+	for _, peer := range peers {
+		addr := peer.GetConnAddr()
+		if _, ok := t.pool[addr]; ok {
+			continue
+		}
+		t.pool[addr] = &candidate{peer: peer}
+		t.PeerList = append(t.PeerList, peer)
+	}
+	t.rwm.Unlock()
+	t.signalPool()
+}
+
+// 获取任务中所有已知 Peer 的副本
+func (t *TorrentTask) Peers() []PeerInfo {
+	t.rwm.RLock()
+	defer t.rwm.RUnlock()
+	return append([]PeerInfo(nil), t.PeerList...)
+}
+
+// 定期通过 Finders 查找新的 Peer，直到下载完成
+func (t *TorrentTask) findRoutine(ctx *Context) {
+	ticker := time.NewTicker(FIND_PEERS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		for _, finder := range t.Finders {
+			peers, err := finder.FindPeers(t.InfoSHA, t.Port)
+			if err != nil {
+				ctx.pushErr(fmt.Errorf("find peers failed: %s", err.Error()))
+				continue
+			}
+			t.AddPeers(peers...)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 按传输协议偏好与加密策略连接对端：依次尝试各传输协议，每种协议上优先加密，策略允许时加密失败后以明文重试
+func (t *TorrentTask) connect(peer PeerInfo) (*PeerConn, error) {
+	var provides []mse.CryptoMethod
+	switch t.Encryption {
+	case EncryptionRequire:
+		provides = []mse.CryptoMethod{mse.CryptoRC4}
+	case EncryptionPrefer:
+		provides = []mse.CryptoMethod{mse.CryptoRC4 | mse.CryptoPlaintext, 0}
+	default:
+		provides = []mse.CryptoMethod{0}
+	}
+	var err error
+	for _, dial := range t.dialers(peer) {
+		for _, provide := range provides {
+			var conn *PeerConn
+			if conn, err = peer.newConn(dial, t.InfoSHA, t.PeerId, provide); err == nil {
+				return conn, nil
+			}
+			if errors.Is(err, ErrSelfConnection) { // 换用其他传输协议或加密方式也是连接到本机
+				return nil, err
+			}
+		}
+	}
+	return nil, err
+}
+
+// 按偏好排列的传输协议（对端在 PEX 中声明支持 uTP 时优先使用 uTP）
+func (t *TorrentTask) dialers(peer PeerInfo) []dialFunc {
+	m := t.manager()
+	dialTCP := m.dialTCP
+	if t.UTP == nil || m.ProxyOnly { // uTP 不经过代理
+		return []dialFunc{dialTCP}
+	}
+	dialUTP := func(addr string) (net.Conn, error) {
+		return t.UTP.DialTimeout(addr, 5*time.Second)
+	}
+	if t.Transport == PreferUTP || peer.Flags&PexUTP != 0 {
+		return []dialFunc{dialUTP, dialTCP}
+	}
+	return []dialFunc{dialTCP, dialUTP}
+}
+
+// 将对端连入的连接交给任务（下载完成后仍用于上传），任务未开始、连接数量已满、对端 IP 被封禁或只通过代理连接时关闭连接
+func (t *TorrentTask) AddConn(conn *PeerConn) {
+	m := t.manager()
+	t.rwm.Lock()
+	ctx, p := t.ctx, t.picker
+	if ctx == nil || len(t.conns)+t.pending >= t.maxConns() || m.ProxyOnly || m.IsBanned(conn.peer.IP) || !m.openConn() {
+		t.rwm.Unlock()
+		conn.Close()
 		return
 	}
+	t.pending++ // 与连接协程一样先占用名额，同时连入的连接不会超过连接数量限制
+	// 连入的连接使用临时端口，无法对应到候选池中的地址：连接期间不再连接同一 IP 的候选
+	ip := conn.peer.IP.String()
+	if t.incoming == nil {
+		t.incoming = make(map[string]int)
+	}
+	t.incoming[ip]++
+	t.rwm.Unlock()
+	go func() {
+		err := t.serveConn(conn, p, ctx)
+		m.closeConn(conn.peer.IP, err)
+		t.rwm.Lock()
+		if t.incoming[ip]--; t.incoming[ip] == 0 {
+			delete(t.incoming, ip)
+		}
+		t.rwm.Unlock()
+		t.signalPool()
+	}()
+}
+
+// 通过已完成握手的连接下载，下载完成后继续为对端上传，直到连接出错，返回连接断开的原因；
+// 调用者需已为连接占用 pending 的名额，连接加入 conns 或提前返回时释放
+func (t *TorrentTask) serveConn(conn *PeerConn, p *picker, ctx *Context) error {
+	peer := conn.peer
 	defer conn.Close()
+	reserved := true
+	defer func() {
+		if reserved {
+			t.rwm.Lock()
+			t.pending--
+			t.rwm.Unlock()
+		}
+	}()
+
+	// 握手后的第一条消息为本地的 Bitfield（或 HaveAll/HaveNone），对端的 Bitfield 由 handleMsg 处理
+	conn.pieces = len(t.PieceSHA)
+	if len(conn.Field) == 0 {
+		conn.Field = make(Bitfield, (len(t.PieceSHA)+7)/8)
+	}
+	if _, err := conn.WriteMsg(t.haveMsg(conn)); err != nil {
+		ctx.pushErr(fmt.Errorf("send bitfield to %s failed: %s", peer.IP.String(), err.Error()))
+		return err
+	}
+	conn.peerChoking.Store(conn.Choked)
+	conn.picker = p
+	p.update(nil, conn.Field) // 加入可用度的统计
+	defer conn.setField(nil)
+	done := make(chan struct{})
+	defer close(done)
+	m := t.manager()
+	t.initLimits(conn)
+	conn.startWriter(done, m.keepAlive(), m.writeTimeout()) // 之后的消息都由写协程发送（需在连接对其他协程可见之前启动）
+	u := t.newUploader(conn)
+	for index := range u.allowedFast {
+		if _, err := conn.WriteMsg(NewAllowedFastMsg(index)); err != nil {
+			return err
+		}
+	}
+	t.addConn(conn, u)
+	reserved = false
+	defer t.removeConn(conn)
+	conn.startReader(u, done, m.idleTimeout())
 
-	ctx.rwm.Lock()
-	ctx.peerInfos = append(ctx.peerInfos, peer)
-	ctx.rwm.Unlock()
+	ctx.addPeer(peer)
+	defer ctx.removePeer(peer)
 
-	conn.WriteMsg(&PeerMsg{MsgInterested, nil})
-	// get piece task & download
-	for task := range taskChan {
-		if !conn.Field.HasPiece(task.Index) {
-			taskChan <- task
-			continue
+	if conn.SupportsExtensions() {
+		if err := t.startExtensions(conn, done); err != nil {
+			ctx.pushErr(fmt.Errorf("send extended handshake to %s failed: %s", peer.IP.String(), err.Error()))
+			return err
+		}
+	}
+
+	if !ctx.isDone() { // 已经拥有所有 Piece 时只做种
+		if err := t.download(conn, p, ctx); err != nil {
+			return err
 		}
-		res, err := conn.DownloadPiece(task)
+		conn.setInterested(false)
+	}
+	// 下载完成，只为对端上传
+	for {
+		msg, err := conn.ReadMsg()
 		if err != nil {
-			taskChan <- task
-			ctx.errChan <- fmt.Errorf("fail to download piece" + err.Error())
-			return
+			return err
 		}
-		if !task.CheckPiece(res) {
-			taskChan <- task
-			ctx.errChan <- fmt.Errorf("check piece failed")
-			continue
+		err = conn.handleMsg(msg)
+		releaseMsg(msg) // 做种时收到的块不再需要
+		if err != nil {
+			ctx.pushErr(fmt.Errorf("handle msg from %s failed: %s", peer.IP.String(), err.Error()))
+			return protocolViolation(err)
+		}
+	}
+}
+
+// 通过连接以块为单位下载，直到下载完成（返回 nil）或连接出错（已收到的块会保留）
+func (t *TorrentTask) download(conn *PeerConn, p *picker, ctx *Context) error {
+	conn.setInterested(p.interesting(conn.Field))
+	requests := make(map[blockRequest]time.Time) // 已向对端请求但尚未收到的块及其请求时间
+	pl := newPipeline(time.Now())
+	defer func() {
+		for req := range requests {
+			p.release(conn, req)
+		}
+	}()
+	var deadline time.Time // 有未完成的请求时，等待下一个块的截止时间
+	for {
+		wake := p.waiter()
+		for req := range requests { // 终局模式下已被其他连接收到的块
+			if p.owns(conn, req) {
+				continue
+			}
+			delete(requests, req)
+			if _, err := conn.WriteMsg(NewCancelMsg(req.Index, req.Begin, req.Length)); err != nil {
+				return err
+			}
+		}
+		if ctx.isDone() {
+			return nil
 		}
-		ctx.resultChan <- res
-		atomic.AddUint64(&ctx.currentBytes, uint64(len(res.Data)))
-		atomic.AddUint64(&ctx.currentPieces, 1)
-		if atomic.LoadUint64(&ctx.currentPieces) == uint64(len(t.PieceSHA)) {
-			ctx.Finish()
+		for limit := pl.limit(conn.reqq()); len(requests) < limit; { // 未完成的请求未达到队列长度，跨 Piece 继续请求
+			req, ok := p.request(conn)
+			if !ok {
+				break
+			}
+			if _, err := conn.WriteMsg(NewRequestMsg(req.Index, req.Begin, req.Length)); err != nil {
+				p.release(conn, req)
+				return err
+			}
+			if len(requests) == 0 {
+				deadline = time.Now().Add(REQUEST_TIMEOUT)
+			}
+			requests[req] = time.Now()
+		}
+		if len(requests) == 0 { // 对端没有需要下载的 Piece 时不再感兴趣
+			conn.setInterested(p.interesting(conn.Field))
+		}
+		var timeout <-chan time.Time
+		if len(requests) > 0 {
+			timeout = time.After(time.Until(deadline))
+		}
+		msg, err := conn.wait(wake, ctx.Done(), timeout)
+		if err != nil {
+			ctx.pushErr(fmt.Errorf("download from %s failed: %s", conn.peer.IP.String(), err.Error()))
+			return err
+		}
+		m, err := decodeMsg(msg)
+		if err != nil {
+			releaseMsg(msg)
+			ctx.pushErr(fmt.Errorf("handle msg failed: %s", err.Error()))
+			return protocolViolation(err)
+		}
+		switch m := m.(type) {
+		case nil: // 保活消息与未知的消息
+		case PieceMsg:
+			req := blockRequest{m.Index, m.Begin, len(m.Data)}
+			if sent, ok := requests[req]; ok {
+				delete(requests, req)
+				pl.received(len(m.Data), sent, time.Now())
+			}
+			ok, pp := p.receive(conn, m.Index, m.Begin, m.Data)
+			// 块已复制到 Piece 的缓冲区，归还消息的缓冲区
+			putBuf(msg.Payload)
+			if !ok { // 未请求或已经收到的块
+				continue
+			}
+			deadline = time.Now().Add(REQUEST_TIMEOUT)
+			conn.downloaded.Add(uint64(req.Length))
+			conn.lastPiece.Store(time.Now().UnixNano())
+			if pp != nil {
+				t.finishPiece(p, ctx, pp)
+			}
+		case RejectMsg: // 对端拒绝请求（BEP 6），交给其他连接重新请求
+			if err = conn.handle(m); err == nil {
+				req := blockRequest(m)
+				if _, ok := requests[req]; ok {
+					delete(requests, req)
+					p.release(conn, req)
+				}
+			}
+		case HaveMsg: // 对端新拥有的 Piece 可能是需要下载的
+			if err = conn.handle(m); err == nil && !conn.amInterested.Load() && p.needs(m.Index) {
+				conn.setInterested(true)
+			}
+		case BitfieldMsg:
+			if err = conn.handle(m); err == nil {
+				conn.setInterested(p.interesting(conn.Field))
+			}
+		case EmptyMsg:
+			if MsgId(m) == MsgChoke && !conn.SupportsFast() { // 不支持 Fast 扩展的对端在阻塞时丢弃所有请求
+				for req := range requests {
+					p.release(conn, req)
+				}
+				clear(requests)
+			}
+			if err = conn.handle(m); err == nil && (MsgId(m) == MsgHaveAll || MsgId(m) == MsgHaveNone) {
+				conn.setInterested(p.interesting(conn.Field))
+			}
+		default:
+			err = conn.handle(m)
+		}
+		if err != nil {
+			ctx.pushErr(fmt.Errorf("handle msg failed: %s", err.Error()))
+			return protocolViolation(err)
+		}
+	}
+}
+
+// 校验并保存下载完成的 Piece，失败时重新下载
+func (t *TorrentTask) finishPiece(p *picker, ctx *Context, pp *partialPiece) {
+	res := &PieceResult{pp.task.Index, pp.data}
+	if !pp.task.CheckPiece(res) {
+		ctx.pushErr(fmt.Errorf("check piece %d failed", res.Index))
+		for _, peer := range p.fail(pp) { // 所有块来自同一个 IP
+			t.banPeer(ctx, peer, res.Index)
 		}
+		return
+	}
+	for _, peer := range p.verify(pp) { // 与校验失败时的块不同的来源发送了错误的数据
+		t.banPeer(ctx, peer, res.Index)
+	}
+	if err := t.completePiece(res); err != nil {
+		p.push(pp.task)
+		ctx.pushErr(fmt.Errorf("write piece %d failed: %s", res.Index, err.Error()))
+		return
+	}
+	n := len(res.Data)
+	if t.Storage != nil { // 数据已写入存储，结果只用于通知
+		res = &PieceResult{Index: res.Index}
+	}
+	ctx.resultChan <- res
+	atomic.AddUint64(&ctx.currentBytes, uint64(n))
+	atomic.AddUint64(&ctx.currentPieces, 1)
+	if atomic.LoadUint64(&ctx.currentPieces) == uint64(len(t.PieceSHA)) {
+		ctx.Finish()
 	}
 }
 
@@ -70,15 +408,32 @@ func (t *TorrentTask) GetPieceBounds(index int) (bengin int, end int) {
 // 下载种子任务
 func (task *TorrentTask) Download() *Context {
 	ctx := newContext()
-	taskChan := make(chan *PieceTask, len(task.PieceSHA))
+	p := newPicker(task.Strategy, len(task.PieceSHA), &ctx.endgame)
 
+	task.rwm.Lock()
+	task.initHave()
 	for index, sha := range task.PieceSHA {
 		begin, end := task.GetPieceBounds(index)
-		taskChan <- &PieceTask{index, sha, (end - begin)}
+		if task.have.HasPiece(index) { // 已经拥有的 Piece 无需下载
+			ctx.currentBytes += uint64(end - begin)
+			ctx.currentPieces++
+			continue
+		}
+		p.add(&PieceTask{index, sha, (end - begin)})
+	}
+	if task.Storage != nil { // 缓冲所有剩余 Piece 的结果，调用者不读取 GetResult 时下载也不会阻塞
+		ctx.resultChan = make(chan *PieceResult, len(task.PieceSHA)-int(ctx.currentPieces))
+	}
+	if ctx.currentPieces == uint64(len(task.PieceSHA)) {
+		ctx.Finish()
 	}
-	// init goroutines for each peer
-	for _, peer := range task.PeerList {
-		go task.peerRoutine(peer, taskChan, ctx)
+	task.ctx = ctx
+	task.picker = p
+	task.initPool()
+	task.rwm.Unlock()
+	go task.connectRoutine(p, ctx)
+	if len(task.Finders) > 0 {
+		go task.findRoutine(ctx)
 	}
 	return ctx
 }
@@ -95,43 +450,7 @@ func (pt *PieceTask) CheckPiece(res *PieceResult) bool {
 	return bytes.Equal(pt.SHA1[:], sha[:])
 }
 
-type TaskState struct { // 每一片 Piece 的任务状态
-	Index      int       // Piece 的索引
-	Conn       *PeerConn // 与对端 Peer 的连接
-	Requested  int       // 请求量
-	Downloaded int       // 下载量
-	Backlog    int       // 并发度
-	Data       []byte    // 数据
-}
-
-// 处理消息
-func (ts *TaskState) HandleMsg(msg *PeerMsg) error {
-	if msg == nil { // 保活消息
-		return nil
-	}
-	switch msg.Id {
-	case MsgChoke:
-		ts.Conn.Choked = true
-	case MsgUnchoke:
-		ts.Conn.Choked = false
-	case MsgHave:
-		index, err := msg.GetHaveIndex()
-		if err != nil {
-			return err
-		}
-		ts.Conn.Field.SetPiece(index)
-	case MsgPiece:
-		n, err := msg.CopyPieceData(ts.Index, ts.Data)
-		if err != nil {
-			return err
-		}
-		ts.Downloaded += n
-		ts.Backlog--
-	}
-	return nil
-}
-
 type PieceResult struct { // Piece 结果
 	Index int    // Piece 的索引
-	Data  []byte // 数据
+	Data  []byte // 数据（设置了 TorrentTask.Storage 时为 nil，数据已写入存储）
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/Akimio521/torrent-go/bencode"
@@ -22,6 +23,7 @@ type RawInfo struct {
 	PiceLength int     `bencode:"piece length"` // 每个 piece 的大小
 	Pieces     string  `bencode:"pieces"`       // 所有 piece 的 hash 值
 	Files      []Files `bencode:"files"`        // 文件列表（当种子是目录时不为空）
+	Private    int     `bencode:"private"`      // 为 1 时是私有种子（BEP 27）
 }
 
 type TorrentFile struct {
@@ -30,6 +32,7 @@ type TorrentFile struct {
 	AnnounceList []string        `bencode:"announce-list"` // 备选 tracker 列表 可选
 	Comment      string          `bencode:"comment"`       // 备注 可选
 	CreatBy      string          `bencode:"created by"`    // 创建者信息 可选
+	Manager      *ConnManager    `bencode:"-"`             // 连接 Tracker 与创建的任务使用的连接管理（为 nil 时使用 DefaultConnManager）
 	infoSHA1     [sha1.Size]byte `bencode:"-"`             // 用于存储 Info 的哈希（种子的唯一标识）
 }
 
@@ -38,6 +41,11 @@ func (tf *TorrentFile) GetInfoSHA1() [sha1.Size]byte {
 	return tf.infoSHA1
 }
 
+// 是否为私有种子（只能通过种子自身的 Tracker 获取 Peer）
+func (tf *TorrentFile) IsPrivate() bool {
+	return tf.Info.Private == 1
+}
+
 // 获取所有的 SHA1 值
 func (tf *TorrentFile) GetAllPieceSHA() [][sha1.Size]byte {
 	pieces := []byte(tf.Info.Pieces)
@@ -56,6 +64,13 @@ func (tf *TorrentFile) GetPiecesNum() int {
 
 // 向 TorrentFile 中的 Tracker 发送请求获取 Peer 列表
 func (tf *TorrentFile) FindPeers(peerID [PEER_ID_LEN]byte, port uint16) ([]PeerInfo, error) {
+	m := tf.Manager
+	if m == nil {
+		m = DefaultConnManager
+	}
+	if u, err := url.Parse(tf.Announce); err == nil && u.Scheme == "udp" {
+		return announceUDP(m, u.Host, tf.GetInfoSHA1(), peerID, port, tf.Info.Length)
+	}
 	url, err := buildUrl(tf, peerID, port)
 	if err != nil {
 		return nil, fmt.Errorf("build tracker URL error: %s", err.Error())
@@ -63,6 +78,9 @@ func (tf *TorrentFile) FindPeers(peerID [PEER_ID_LEN]byte, port uint16) ([]PeerI
 	// fmt.Println(url)
 
 	client := http.Client{Timeout: 15 * time.Second}
+	if m.Proxy != nil || m.ProxyOnly { // 通过代理连接 Tracker（不使用环境变量中的代理）
+		client.Transport = &http.Transport{DialContext: m.dialContext}
+	}
 	resp, err := client.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("fail to connect to tracker: %s", err.Error())
@@ -81,24 +99,52 @@ func (tf *TorrentFile) FindPeers(peerID [PEER_ID_LEN]byte, port uint16) ([]PeerI
 	return trackerResp.ParsePeerInfos()
 }
 
-// 获取种子文件转的任务
-func (tf *TorrentFile) GetTask(peerID [PEER_ID_LEN]byte, port uint16) (*TorrentTask, error) {
+// 获取种子文件转的任务，Tracker 没有返回 Peer 时依次通过 finders 查找（私有种子忽略 finders）；
+// finders 中有 PeerWatcher 时没有找到 Peer 也返回任务，之后发现的 Peer 直接加入任务
+func (tf *TorrentFile) GetTask(peerID [PEER_ID_LEN]byte, port uint16, finders ...PeerFinder) (*TorrentTask, error) {
+	if tf.IsPrivate() {
+		finders = nil
+	}
 	peers, err := tf.FindPeers(peerID, port)
-	if err != nil {
+	if err != nil && len(finders) == 0 {
 		return nil, fmt.Errorf("find peers faild: %s", err.Error())
 	}
-	if len(peers) == 0 {
+	for i := 0; i < len(finders) && len(peers) == 0; i++ {
+		if found, ferr := finders[i].FindPeers(tf.GetInfoSHA1(), port); ferr == nil {
+			peers = append(peers, found...)
+		} else if err == nil {
+			err = ferr
+		}
+	}
+	var watchers []PeerWatcher // 运行时发现的 Peer 直接加入任务
+	for _, f := range finders {
+		if w, ok := f.(PeerWatcher); ok {
+			watchers = append(watchers, w)
+		}
+	}
+	if len(peers) == 0 && len(watchers) == 0 {
+		if err != nil {
+			return nil, fmt.Errorf("can not find peers: %s", err.Error())
+		}
 		return nil, fmt.Errorf("can not find peers")
 	}
-	return &TorrentTask{
+	task := &TorrentTask{
 		PeerId:   peerID,
+		Port:     port,
 		PeerList: peers,
 		InfoSHA:  tf.GetInfoSHA1(),
 		FileName: tf.Info.Name,
 		FileLen:  tf.Info.Length,
 		PieceLen: tf.Info.PiceLength,
 		PieceSHA: tf.GetAllPieceSHA(),
-	}, nil
+		Finders:  finders,
+		Private:  tf.IsPrivate(),
+		Manager:  tf.Manager,
+	}
+	for _, w := range watchers {
+		w.Watch(task.InfoSHA, func(peer PeerInfo) { task.AddPeers(peer) })
+	}
+	return task, nil
 }
 
 // 解析种子文件
@@ -2,9 +2,12 @@ package torrent_test
 
 import (
 	"bufio"
+	"bytes"
+	"net"
 	"os"
 	"testing"
 
+	"github.com/Akimio521/torrent-go/bencode"
 	"github.com/Akimio521/torrent-go/torrent"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -37,3 +40,82 @@ func BenchmarkParseFile(b *testing.B) {
 		torrent.ParseFile(file)
 	}
 }
+
+type staticFinder []torrent.PeerInfo
+
+func (f staticFinder) FindPeers(infoSHA [20]byte, port uint16) ([]torrent.PeerInfo, error) {
+	return f, nil
+}
+
+func TestGetTaskWithFinder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := bencode.Marshal(buf, &torrent.TorrentFile{
+		Announce: "http://127.0.0.1:1/announce", // 无法连接的 Tracker
+		Info:     torrent.RawInfo{Name: "test", Length: 1, PiceLength: 1, Pieces: string(make([]byte, 20))},
+	})
+	require.NoError(t, err)
+	tf, err := torrent.ParseFile(buf)
+	require.NoError(t, err)
+
+	var peerId [torrent.PEER_ID_LEN]byte
+	_, err = tf.GetTask(peerId, 6881)
+	require.Error(t, err)
+
+	finder := staticFinder{{IP: net.IPv4(127, 0, 0, 1), Port: 7000}}
+	task, err := tf.GetTask(peerId, 6881, finder)
+	require.NoError(t, err)
+	require.Len(t, task.PeerList, 1)
+
+	task.AddPeers(finder[0], torrent.PeerInfo{IP: net.IPv4(127, 0, 0, 1), Port: 7001})
+	require.Len(t, task.PeerList, 2)
+}
+
+type watchFinder struct { // 运行时发现 Peer 的途径，查找时还没有 Peer
+	onPeer func(torrent.PeerInfo)
+}
+
+func (f *watchFinder) FindPeers(infoSHA [20]byte, port uint16) ([]torrent.PeerInfo, error) {
+	return nil, nil
+}
+
+func (f *watchFinder) Watch(infoSHA [20]byte, onPeer func(torrent.PeerInfo)) {
+	f.onPeer = onPeer
+}
+
+func TestGetTaskWithWatcher(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := bencode.Marshal(buf, &torrent.TorrentFile{
+		Announce: "http://127.0.0.1:1/announce", // 无法连接的 Tracker
+		Info:     torrent.RawInfo{Name: "test", Length: 1, PiceLength: 1, Pieces: string(make([]byte, 20))},
+	})
+	require.NoError(t, err)
+	tf, err := torrent.ParseFile(buf)
+	require.NoError(t, err)
+
+	// 没有找到 Peer 时仍然返回任务，之后发现的 Peer 加入任务
+	var peerId [torrent.PEER_ID_LEN]byte
+	finder := new(watchFinder)
+	task, err := tf.GetTask(peerId, 6881, finder)
+	require.NoError(t, err)
+	require.Empty(t, task.PeerList)
+	require.NotNil(t, finder.onPeer)
+	finder.onPeer(torrent.PeerInfo{IP: net.IPv4(127, 0, 0, 1), Port: 7000})
+	require.Len(t, task.Peers(), 1)
+}
+
+func TestPrivateTorrent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, err := bencode.Marshal(buf, &torrent.TorrentFile{
+		Announce: "http://127.0.0.1:1/announce", // 无法连接的 Tracker
+		Info:     torrent.RawInfo{Name: "test", Length: 1, PiceLength: 1, Pieces: string(make([]byte, 20)), Private: 1},
+	})
+	require.NoError(t, err)
+	tf, err := torrent.ParseFile(buf)
+	require.NoError(t, err)
+	require.True(t, tf.IsPrivate())
+
+	// 私有种子不使用 Tracker 以外的 Peer 发现途径
+	var peerId [torrent.PEER_ID_LEN]byte
+	_, err = tf.GetTask(peerId, 6881, staticFinder{{IP: net.IPv4(127, 0, 0, 1), Port: 7000}})
+	require.Error(t, err)
+}
@@ -9,8 +9,10 @@ import (
 )
 
 type PeerInfo struct { // Peer 对端信息
-	IP   net.IP // IP 地址
-	Port uint16 // 端口号
+	IP     net.IP     // IP 地址
+	Port   uint16     // 端口号
+	Source PeerSource // 来源
+	Flags  PexFlags   // PEX 中携带的标志（其他来源为 0）
 }
 
 // 获取连接地址
@@ -18,10 +20,12 @@ func (pi *PeerInfo) GetConnAddr() string {
 	return net.JoinHostPort(pi.IP.String(), strconv.Itoa(int(pi.Port)))
 }
 
-type TrackerResponse struct { // Tracker 响应
-	Interval int    `bencode:"interval"` // 间隔时间
-	Peers    string `bencode:"peers"`    // Peer 列表
-	Peers6   string `bencode:"peers6"`   // Peer 列表（IPv6）
+type TrackerResponse struct { // Tracker 响应（字段按键名的字典序排列，以便编码结果符合规范）
+	Complete   int    `bencode:"complete"`   // 做种者数量
+	Incomplete int    `bencode:"incomplete"` // 下载者数量
+	Interval   int    `bencode:"interval"`   // 间隔时间
+	Peers      string `bencode:"peers"`      // Peer 列表
+	Peers6     string `bencode:"peers6"`     // Peer 列表（IPv6）
 }
 
 // 解析 Tracker 响应中的 Peers 信息，返回 PeerInfo 列表
@@ -85,3 +89,19 @@ func buildUrl(tf *TorrentFile, peerID [PEER_ID_LEN]byte, port uint16) (string, e
 	base.RawQuery = params.Encode()
 	return base.String(), nil
 }
+
+// 将 PeerInfo 列表编码为紧凑格式，分别返回 IPv4 与 IPv6 的 Peers 字符串（ParsePeerInfos 的逆过程）
+func EncodePeerInfos(peerInfos []PeerInfo) (string, string) {
+	peers := make([]byte, 0, len(peerInfos)*PEER_V4_LEN)
+	peers6 := make([]byte, 0)
+	for _, pi := range peerInfos {
+		if ip4 := pi.IP.To4(); ip4 != nil {
+			peers = append(peers, ip4...)
+			peers = binary.BigEndian.AppendUint16(peers, pi.Port)
+		} else if ip6 := pi.IP.To16(); ip6 != nil {
+			peers6 = append(peers6, ip6...)
+			peers6 = binary.BigEndian.AppendUint16(peers6, pi.Port)
+		}
+	}
+	return string(peers), string(peers6)
+}
@@ -0,0 +1,104 @@
+package torrent
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	udpTrackerRetries = 3               // UDP Tracker 请求重试次数
+	udpTrackerTimeout = 5 * time.Second // 单次请求超时时间
+)
+
+// 向 UDP Tracker（BEP 15）宣告并获取 Peer 列表（设置了 SOCKS5 代理时通过 UDP ASSOCIATE 中继）
+func announceUDP(m *ConnManager, host string, infoSHA [sha1.Size]byte, peerID [PEER_ID_LEN]byte, port uint16, left int) ([]PeerInfo, error) {
+	conn, err := m.dial("udp", host, udpTrackerTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial udp tracker %s failed: %s", host, err.Error())
+	}
+	defer conn.Close()
+
+	// 建立连接，获取 connection id
+	req := make([]byte, UDP_CONNECT_LEN)
+	binary.BigEndian.PutUint64(req[0:8], UDP_PROTOCOL_ID)
+	binary.BigEndian.PutUint32(req[8:12], UDP_ACTION_CONNECT)
+	resp, err := udpRoundTrip(conn, req, UDP_ACTION_CONNECT)
+	if err != nil {
+		return nil, fmt.Errorf("connect udp tracker failed: %s", err.Error())
+	}
+	if len(resp) < UDP_CONNECT_LEN {
+		return nil, ErrUDPTrackerResponse
+	}
+	connID := binary.BigEndian.Uint64(resp[8:16])
+
+	// 宣告
+	req = make([]byte, UDP_ANNOUNCE_LEN)
+	binary.BigEndian.PutUint64(req[0:8], connID)
+	binary.BigEndian.PutUint32(req[8:12], UDP_ACTION_ANNOUNCE)
+	copy(req[16:36], infoSHA[:])
+	copy(req[36:56], peerID[:])
+	binary.BigEndian.PutUint64(req[56:64], 0)            // downloaded
+	binary.BigEndian.PutUint64(req[64:72], uint64(left)) // left
+	binary.BigEndian.PutUint64(req[72:80], 0)            // uploaded
+	binary.BigEndian.PutUint32(req[80:84], 0)            // event
+	binary.BigEndian.PutUint32(req[84:88], 0)            // IP（由 Tracker 根据来源地址确定）
+	rand.Read(req[88:92])                                // key
+	binary.BigEndian.PutUint32(req[92:96], 0xFFFFFFFF)   // num_want（-1 表示默认）
+	binary.BigEndian.PutUint16(req[96:98], port)
+	resp, err = udpRoundTrip(conn, req, UDP_ACTION_ANNOUNCE)
+	if err != nil {
+		return nil, fmt.Errorf("announce udp tracker failed: %s", err.Error())
+	}
+	if len(resp) < UDP_ANNOUNCE_HEAD {
+		return nil, ErrUDPTrackerResponse
+	}
+
+	// 响应中 Peer 的地址族与请求所用的地址族一致
+	tr := new(TrackerResponse)
+	tr.Interval = int(binary.BigEndian.Uint32(resp[8:12]))
+	tr.Incomplete = int(binary.BigEndian.Uint32(resp[12:16]))
+	tr.Complete = int(binary.BigEndian.Uint32(resp[16:20]))
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		tr.Peers6 = string(resp[UDP_ANNOUNCE_HEAD:])
+	} else {
+		tr.Peers = string(resp[UDP_ANNOUNCE_HEAD:])
+	}
+	return tr.ParsePeerInfos()
+}
+
+// 发送 UDP 请求并等待响应，超时则重试；req[12:16] 会被填充为随机的 transaction id
+func udpRoundTrip(conn net.Conn, req []byte, action uint32) ([]byte, error) {
+	rand.Read(req[12:16])
+	txID := binary.BigEndian.Uint32(req[12:16])
+	buf := make([]byte, 2048)
+	var lastErr error
+	for i := 0; i < udpTrackerRetries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(udpTrackerTimeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				lastErr = err
+				break
+			}
+			if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != txID { // 不是本次请求的响应
+				continue
+			}
+			switch binary.BigEndian.Uint32(buf[0:4]) {
+			case action:
+				return buf[:n], nil
+			case UDP_ACTION_ERROR:
+				return nil, fmt.Errorf("tracker error: %s", string(buf[8:n]))
+			default:
+				return nil, ErrUDPTrackerResponse
+			}
+		}
+	}
+	return nil, lastErr
+}
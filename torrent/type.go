@@ -4,22 +4,104 @@ import (
 	"crypto/sha1"
 	"errors"
 	"net"
+	"time"
 )
 
 const (
-	RESERVED_LEN      int    = 8                                      // 保留长度
-	PEER_ID_LEN       int    = 20                                     // Peer ID 长度
-	HS_MSG_LEN        int    = RESERVED_LEN + sha1.Size + PEER_ID_LEN // 握手消息长度
-	PORT_LEN          int    = 2                                      // 端口长度
-	PEER_V4_LEN       int    = net.IPv4len + PORT_LEN                 // Peer 长度（IPv4）
-	PEER_V6_LEN       int    = net.IPv6len + PORT_LEN                 // Peer 长度（IPv6）
-	PEER_MSG_HEAD_LEN uint32 = 4                                      // Peer 消息头长度（消息头用于存储消息长度（不包括消息头））
-	BLOCK_SIZE               = 16 * 1024                              // 块大小（16KB）
-	MAX_BACKLOG              = 5                                      // 最大并发度（同一个 Peer）
+	RESERVED_LEN           int    = 8                                      // 保留长度
+	PEER_ID_LEN            int    = 20                                     // Peer ID 长度
+	HS_MSG_LEN             int    = RESERVED_LEN + sha1.Size + PEER_ID_LEN // 握手消息长度
+	PORT_LEN               int    = 2                                      // 端口长度
+	PEER_V4_LEN            int    = net.IPv4len + PORT_LEN                 // Peer 长度（IPv4）
+	PEER_V6_LEN            int    = net.IPv6len + PORT_LEN                 // Peer 长度（IPv6）
+	PEER_MSG_HEAD_LEN      uint32 = 4                                      // Peer 消息头长度（消息头用于存储消息长度（不包括消息头））
+	BLOCK_SIZE                    = 16 * 1024                              // 块大小（16KB）
+	REQUEST_TIMEOUT               = 15 * time.Second                       // 有未完成的请求时，超过该时间没有收到块则断开连接
+	FIND_PEERS_INTERVAL           = 5 * time.Minute                        // 通过 PeerFinder 查找 Peer 的间隔
+	CLIENT_VERSION                = "torrent-go"                           // 扩展协议握手中的客户端名称
+	PEER_ID_CLIENT                = "TG"                                   // Azureus 风格 Peer ID 中的客户端代码
+	DEFAULT_PEER_ID_PREFIX        = "-" + PEER_ID_CLIENT + "0100-"         // 默认的 Peer ID 前缀（客户端代码与版本 0.1.0.0）
+	PEER_INBOX_LEN                = 64                                     // 读协程转交消息的缓冲区长度
+	PEER_OUTBOX_LEN               = 64                                     // 写协程待发送消息的缓冲区长度
+	MAX_UPLOAD_REQUESTS           = 256                                    // 每个连接最多排队的上传请求数量
+)
+
+const ( // 消息编解码
+	MAX_BITFIELD_LEN = 1 << 20                                     // Piece 数量未知时 Bitfield 的最大长度
+	MAX_EXTENDED_LEN = 1 << 20                                     // 扩展消息与未知消息的最大长度
+	MSG_BUF_LEN      = int(PEER_MSG_HEAD_LEN) + 1 + 8 + BLOCK_SIZE // 缓冲池中缓冲区的长度（一条完整的 Piece 消息）
+)
+
+const ( // 请求流水线（参考 libtorrent）
+	MIN_REQUEST_QUEUE  = 4               // 每个连接最少的未完成请求数量（也是慢启动的初始值）
+	MAX_REQUEST_QUEUE  = 250             // 每个连接最多的未完成请求数量（对端未声明 reqq 时的上限）
+	REQUEST_QUEUE_TIME = 3 * time.Second // 请求队列中的数据足够对端持续发送的时间
+	PIPELINE_INTERVAL  = 1 * time.Second // 统计下载速度的周期
+	SLOW_START_GROWTH  = 1.1             // 慢启动期间每个周期的速度增长低于该倍数时结束慢启动
+)
+
+const ( // 连接管理
+	DEFAULT_MAX_CONNS         = 200              // 默认的全局最大连接数量
+	DEFAULT_TORRENT_CONNS     = 50               // 默认的每个任务最大连接数量
+	DEFAULT_MAX_HALF_OPEN     = 20               // 默认的同时进行的连接尝试数量
+	DEFAULT_RECONNECT_BACKOFF = 30 * time.Second // 默认的第一次重连前的等待时间
+	MAX_RECONNECT_BACKOFF     = 30 * time.Minute // 重连等待时间的上限
+	MAX_PROTOCOL_VIOLATIONS   = 3                // 违反协议达到该次数的 IP 会被封禁
+	KEEPALIVE_INTERVAL        = 2 * time.Minute  // 没有发送任何消息超过该时间时发送保活消息
+	PEER_IDLE_TIMEOUT         = 3 * time.Minute  // 超过该时间没有收到对端的任何消息（包括保活消息）时断开连接
+	PEER_WRITE_TIMEOUT        = 1 * time.Minute  // 一条消息超过该时间没有写完（对端不读取）时断开连接
+	RATE_SCHEDULE_INTERVAL    = time.Minute      // RateSchedule 检查时间段的间隔
+)
+
+const ( // 阻塞算法（BEP 3）
+	CHOKE_INTERVAL            = 10 * time.Second // 重新选择解除阻塞的对端的间隔
+	OPTIMISTIC_UNCHOKE_ROUNDS = 3                // 每隔多少轮轮换乐观解除阻塞的对端（30 秒）
+	DEFAULT_UPLOAD_SLOTS      = 4                // 默认的上传槽位数量
+	SNUB_TIMEOUT              = 1 * time.Minute  // 超过该时间没有收到数据的对端视为 snub 本地
+)
+
+const ( // 扩展协议（BEP 10）与 Peer Exchange（BEP 11）
+	RESERVED_EXTENSION_BYTE int   = 5               // 保留字段中扩展协议标志所在的字节
+	RESERVED_EXTENSION_BIT  byte  = 0x10            // 保留字段中扩展协议标志
+	EXT_HANDSHAKE_ID        uint8 = 0               // 扩展握手消息 ID
+	EXT_PEX                       = "ut_pex"        // PEX 扩展名
+	EXT_PEX_ID              uint8 = 1               // 本地为 PEX 分配的扩展消息 ID
+	PEX_INTERVAL                  = 1 * time.Minute // 发送 PEX 消息的间隔
+	PEX_MAX_PEERS                 = 50              // 单条 PEX 消息中 added/dropped 各自的最大 Peer 数量
+)
+
+const ( // Fast 扩展（BEP 6）
+	RESERVED_FAST_BYTE   int  = 7    // 保留字段中 Fast 扩展标志所在的字节
+	RESERVED_FAST_BIT    byte = 0x04 // 保留字段中 Fast 扩展标志
+	ALLOWED_FAST_COUNT        = 10   // 允许快速下载集合的大小
+	MAX_SUGGESTED_PIECES      = 32   // 每个连接最多记录的建议 Piece 数量
+)
+
+const ( // UDP Tracker 协议（BEP 15）
+	UDP_PROTOCOL_ID     uint64 = 0x41727101980 // 连接请求中的协议魔数
+	UDP_CONNECT_LEN     int    = 16            // 连接请求/响应长度
+	UDP_ANNOUNCE_LEN    int    = 98            // 宣告请求长度
+	UDP_ANNOUNCE_HEAD   int    = 20            // 宣告响应头长度（不含 Peers）
+	UDP_SCRAPE_HEAD     int    = 16            // Scrape 请求头长度（不含 info_hash）
+	UDP_MAX_SCRAPE      int    = 74            // 单次 Scrape 最多携带的 info_hash 数量
+	UDP_ACTION_CONNECT  uint32 = 0             // 连接
+	UDP_ACTION_ANNOUNCE uint32 = 1             // 宣告
+	UDP_ACTION_SCRAPE   uint32 = 2             // Scrape
+	UDP_ACTION_ERROR    uint32 = 3             // 错误
 )
 
 type MsgId uint8
 
+const ( // Fast 扩展（BEP 6）的消息
+	MsgSuggest     MsgId = 0x0D // 建议下载（Payload 为 Piece 索引）
+	MsgHaveAll     MsgId = 0x0E // 拥有全部 Piece（代替 Bitfield，没有 Payload）
+	MsgHaveNone    MsgId = 0x0F // 没有任何 Piece（代替 Bitfield，没有 Payload）
+	MsgReject      MsgId = 0x10 // 拒绝请求（Payload 与请求消息相同）
+	MsgAllowedFast MsgId = 0x11 // 允许在阻塞时请求的 Piece（Payload 为 Piece 索引）
+)
+
+const MsgExtended MsgId = 20 // 扩展消息（BEP 10，Payload 第一个字节为扩展消息 ID，其余为 B 编码的内容）
+
 const (
 	MsgChoke       MsgId = iota // 上传者阻塞（不提供上传，没有 Payload）
 	MsgUnchoke                  // 上传者未阻塞（支持上传，没有 Payload）
@@ -30,11 +112,74 @@ const (
 	MsgRequest                  // 下载请求（请求消息包含索引、开始和长度。后两者是字节偏移量。长度通常是 2 的幂，除非它被文件末尾截断）
 	MsgPiece                    // 下载响应（Payload 是块内容）
 	MsgCancel                   // 取消消息（取消消息与请求消息具有相同的负载。它们通常只在下载的“终局模式”结束时发送。当下载接近完成时，最后几块内容往往会从单个故障调制解调器线路下载，耗时非常长。为了确保最后几块内容能快速到达，一旦给定下载器尚未拥有的所有块请求都处于挂起状态，它就会向所有正在下载的内容发送请求。为了防止这变得极其低效，每当一块内容到达时，它就会向其他人发送取消请求）
+	MsgPort                     // DHT 端口（BEP 5，Payload 为 2 字节的端口号）
+)
+
+type PeerSource uint8 // Peer 的来源
+
+const (
+	SourceTracker  PeerSource = iota // Tracker
+	SourceDHT                        // DHT
+	SourcePEX                        // Peer Exchange
+	SourceLSD                        // 本地 Peer 发现
+	SourceIncoming                   // 对端主动连入
+)
+
+// 连接优先级，数值越大越优先连接（局域网 Peer 最优先）
+func (s PeerSource) Priority() int {
+	switch s {
+	case SourceLSD:
+		return 2
+	case SourceIncoming:
+		return 0
+	default:
+		return 1
+	}
+}
+
+type EncryptionPolicy uint8 // 连接加密（MSE/PE）策略
+
+const (
+	EncryptionDisabled EncryptionPolicy = iota // 只使用明文连接
+	EncryptionPrefer                           // 优先使用加密连接，失败时以明文重试
+	EncryptionRequire                          // 只接受加密连接
+)
+
+type TransportPreference uint8 // 连接对端时优先使用的传输协议（设置了 uTP 套接字时另一种协议作为备选）
+
+const (
+	PreferTCP TransportPreference = iota // 优先使用 TCP
+	PreferUTP                            // 优先使用 uTP（BEP 29）
+)
+
+type PexFlags uint8 // PEX 中每个 Peer 携带的标志（BEP 11）
+
+const (
+	PexEncryption PexFlags = 0x01 // 偏好加密连接
+	PexSeed       PexFlags = 0x02 // 做种者（或只上传）
+	PexUTP        PexFlags = 0x04 // 支持 uTP
+	PexHolepunch  PexFlags = 0x08 // 支持 ut_holepunch
+	PexReachable  PexFlags = 0x10 // 可以主动连接
 )
 
 var (
-	ErrMalformedPeersFormat = errors.New("malformed peers format") // 错误 Peers 格式
-	ErrZeroPrelen           = errors.New("prelen cannot be 0")     // 握手消息中 prelen 不能为0
-	ErrCheckInfoSHAFaild    = errors.New("check handshake failed") // 检查 InfoSHA 失败
-	ErrNoImplement          = errors.New("no implement")           // 未实现
+	ErrMalformedPeersFormat = errors.New("malformed peers format")                       // 错误 Peers 格式
+	ErrZeroPrelen           = errors.New("prelen cannot be 0")                           // 握手消息中 prelen 不能为0
+	ErrCheckInfoSHAFaild    = errors.New("check handshake failed")                       // 检查 InfoSHA 失败
+	ErrNoImplement          = errors.New("no implement")                                 // 未实现
+	ErrUDPTrackerResponse   = errors.New("bad udp tracker response")                     // UDP Tracker 响应非法
+	ErrUnknownInfoHash      = errors.New("unknown info hash")                            // 连入的对端请求的种子不在下载中
+	ErrSelfConnection       = errors.New("connected to self")                            // 连接到了自身
+	ErrInvalidRequest       = errors.New("invalid block request")                        // 对端请求的块越界或长度非法
+	ErrMalformedExtMsg      = errors.New("malformed extended message")                   // 扩展消息格式错误
+	ErrEncryptionRequired   = errors.New("encryption required")                          // 加密策略要求加密，但对端使用明文连接
+	ErrUnexpectedFastMsg    = errors.New("unexpected fast extension message")            // 对端未声明支持 Fast 扩展却发送了其消息
+	ErrRequestTimeout       = errors.New("block request timeout")                        // 对端长时间没有发送请求的块
+	ErrProtocolViolation    = errors.New("protocol violation")                           // 对端发送了非法的消息
+	ErrPieceIndex           = errors.New("piece index out of range")                     // Piece 索引超出种子的 Piece 数量
+	ErrBitfieldSpareBits    = errors.New("bitfield spare bits set")                      // Bitfield 末尾的空位没有置为 0
+	ErrProxyRequired        = errors.New("direct connection refused in proxy-only mode") // 只通过代理连接时没有设置代理
+	ErrMsgTooLong           = errors.New("peer message too long")                        // 消息超过该类型的最大长度
+	ErrMsgLength            = errors.New("invalid peer message length")                  // 定长消息的长度错误
+	ErrUnknownMsg           = errors.New("unknown peer message")                         // 未知的消息类型
 )
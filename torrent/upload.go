@@ -0,0 +1,317 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"io"
+	"sync"
+	"time"
+)
+
+type Storage interface { // 种子数据的存储（按整个种子的字节偏移读写）
+	io.ReaderAt
+	io.WriterAt
+}
+
+type blockRequest struct { // 对端请求的块
+	Index  int // Piece 索引
+	Begin  int // 块在 Piece 中的偏移
+	Length int // 块长度
+}
+
+type uploader struct { // 为一个连接排队并发送对端请求的块
+	task        *TorrentTask
+	conn        *PeerConn
+	mu          sync.Mutex
+	queue       []blockRequest   // 尚未发送的请求
+	chokeMu     sync.Mutex       // 保证阻塞状态的变化与发送的 Choke/Unchoke 顺序一致
+	signal      chan struct{}    // 有新请求时通知发送协程
+	allowedFast map[int]struct{} // 阻塞时仍允许对端请求的 Piece（BEP 6）
+}
+
+func (t *TorrentTask) newUploader(conn *PeerConn) *uploader {
+	conn.amChoking.Store(true)
+	u := &uploader{task: t, conn: conn, signal: make(chan struct{}, 1)}
+	if conn.SupportsFast() {
+		u.allowedFast = make(map[int]struct{})
+		for _, index := range AllowedFastSet(conn.peer.IP, t.InfoSHA, len(t.PieceSHA), ALLOWED_FAST_COUNT) {
+			u.allowedFast[index] = struct{}{}
+		}
+	}
+	return u
+}
+
+// 当前是否可以向对端发送该 Piece 的块
+func (u *uploader) canServe(index int) bool {
+	if !u.conn.amChoking.Load() {
+		return true
+	}
+	_, ok := u.allowedFast[index]
+	return ok
+}
+
+// 拒绝对端的请求（对端不支持 Fast 扩展时直接丢弃）
+func (u *uploader) reject(req blockRequest) error {
+	if !u.conn.SupportsFast() {
+		return nil
+	}
+	_, err := u.conn.WriteMsg(NewRejectMsg(req.Index, req.Begin, req.Length))
+	return err
+}
+
+// 处理上传相关的消息，返回消息是否已被处理；请求越界时返回错误
+func (u *uploader) handleMsg(msg *PeerMsg) (bool, error) {
+	if msg == nil {
+		return false, nil
+	}
+	switch msg.Id {
+	case MsgInterested, MsgNotInterest, MsgRequest, MsgCancel:
+	default:
+		return false, nil
+	}
+	m, err := Decode(msg)
+	if err != nil {
+		return true, err
+	}
+	switch m := m.(type) {
+	case EmptyMsg:
+		if MsgId(m) == MsgNotInterest {
+			u.conn.peerInterested.Store(false)
+			break
+		}
+		u.conn.peerInterested.Store(true)
+		if err := u.task.unchokeIfFree(u); err != nil {
+			return true, err
+		}
+	case RequestMsg:
+		if !u.task.validRequest(m.Index, m.Begin, m.Length) {
+			return true, ErrInvalidRequest
+		}
+		req := blockRequest(m)
+		if !u.canServe(m.Index) || !u.task.HasPiece(m.Index) {
+			return true, u.reject(req) // 阻塞期间的请求与本地没有的 Piece 不予处理
+		}
+		u.mu.Lock()
+		full := len(u.queue) >= MAX_UPLOAD_REQUESTS
+		if !full {
+			u.queue = append(u.queue, req)
+		}
+		u.mu.Unlock()
+		if full {
+			return true, u.reject(req)
+		}
+		select {
+		case u.signal <- struct{}{}:
+		default:
+		}
+	case CancelMsg:
+		if req := blockRequest(m); u.cancel(req) {
+			return true, u.reject(req) // Fast 扩展要求对取消的请求回复 Reject
+		}
+	}
+	return true, nil
+}
+
+// 阻塞对端，并拒绝尚未发送的请求（允许快速下载的 Piece 除外）
+func (u *uploader) choke() error {
+	u.chokeMu.Lock()
+	if !u.conn.amChoking.CompareAndSwap(false, true) {
+		u.chokeMu.Unlock()
+		return nil
+	}
+	_, err := u.conn.WriteMsg(&PeerMsg{MsgChoke, nil})
+	u.chokeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	var rejected []blockRequest
+	queue := u.queue[:0]
+	for _, req := range u.queue {
+		if _, ok := u.allowedFast[req.Index]; ok {
+			queue = append(queue, req)
+		} else {
+			rejected = append(rejected, req)
+		}
+	}
+	u.queue = queue
+	u.mu.Unlock()
+	for _, req := range rejected {
+		if err := u.reject(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 解除对对端的阻塞
+func (u *uploader) unchoke() error {
+	u.chokeMu.Lock()
+	defer u.chokeMu.Unlock()
+	if !u.conn.amChoking.CompareAndSwap(true, false) {
+		return nil
+	}
+	_, err := u.conn.WriteMsg(&PeerMsg{MsgUnchoke, nil})
+	return err
+}
+
+// 移除尚未发送的请求，返回请求是否仍在队列中
+func (u *uploader) cancel(req blockRequest) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, r := range u.queue {
+		if r == req {
+			u.queue = append(u.queue[:i], u.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 取出下一个请求
+func (u *uploader) next() (blockRequest, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.queue) == 0 {
+		return blockRequest{}, false
+	}
+	req := u.queue[0]
+	u.queue = u.queue[1:]
+	return req, true
+}
+
+// 依次从存储读取并发送请求的块，直到 done 被关闭
+func (u *uploader) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-u.signal:
+		}
+		for req, ok := u.next(); ok; req, ok = u.next() {
+			if !u.canServe(req.Index) {
+				u.reject(req)
+				continue
+			}
+			data := getBuf()[:req.Length] // 请求的长度不超过 BLOCK_SIZE
+			if err := u.task.readBlock(req, data); err != nil {
+				putBuf(data)
+				u.reject(req)
+				continue
+			}
+			_, err := u.conn.Send(PieceMsg{req.Index, req.Begin, data})
+			putBuf(data) // Send 已将数据复制到消息的缓冲区
+			if err != nil {
+				return
+			}
+			u.conn.uploaded.Add(uint64(req.Length))
+		}
+	}
+}
+
+// 请求的块是否位于 Piece 之内且长度不超过 BLOCK_SIZE
+func (t *TorrentTask) validRequest(index, begin, length int) bool {
+	if index < 0 || index >= len(t.PieceSHA) || begin < 0 || length <= 0 || length > BLOCK_SIZE {
+		return false
+	}
+	pieceBegin, pieceEnd := t.GetPieceBounds(index)
+	return begin+length <= pieceEnd-pieceBegin
+}
+
+// 从存储中读取请求的块到 data（长度为请求的长度）
+func (t *TorrentTask) readBlock(req blockRequest, data []byte) error {
+	if t.Storage == nil {
+		return io.ErrUnexpectedEOF
+	}
+	pieceBegin, _ := t.GetPieceBounds(req.Index)
+	_, err := t.Storage.ReadAt(data, int64(pieceBegin+req.Begin))
+	return err
+}
+
+// 本地是否拥有该 Piece
+func (t *TorrentTask) HasPiece(index int) bool {
+	t.rwm.RLock()
+	defer t.rwm.RUnlock()
+	return t.have.HasPiece(index)
+}
+
+// 本地 Bitfield 的副本
+func (t *TorrentTask) Bitfield() Bitfield {
+	t.rwm.Lock()
+	defer t.rwm.Unlock()
+	t.initHave()
+	return append(Bitfield(nil), t.have...)
+}
+
+// 按需初始化本地 Bitfield（调用者需持有写锁）
+func (t *TorrentTask) initHave() {
+	if t.have == nil {
+		t.have = make(Bitfield, (len(t.PieceSHA)+7)/8)
+	}
+}
+
+// 校验存储中已有的数据，返回校验通过的 Piece 数量（用于续传或做种）
+func (t *TorrentTask) CheckStorage() (int, error) {
+	num := 0
+	if t.Storage == nil {
+		return num, nil
+	}
+	for index, sha := range t.PieceSHA {
+		begin, end := t.GetPieceBounds(index)
+		data := make([]byte, end-begin)
+		if _, err := t.Storage.ReadAt(data, int64(begin)); err != nil && err != io.EOF {
+			return num, err
+		}
+		if sha1.Sum(data) != sha {
+			continue
+		}
+		t.rwm.Lock()
+		t.initHave()
+		t.have.SetPiece(index)
+		t.rwm.Unlock()
+		num++
+	}
+	return num, nil
+}
+
+// 将校验通过的 Piece 写入存储，并向所有连接广播 Have
+func (t *TorrentTask) completePiece(res *PieceResult) error {
+	if t.Storage == nil { // 没有存储时无法上传
+		return nil
+	}
+	begin, _ := t.GetPieceBounds(res.Index)
+	if _, err := t.Storage.WriteAt(res.Data, int64(begin)); err != nil {
+		return err
+	}
+	t.rwm.Lock()
+	t.initHave()
+	t.have.SetPiece(res.Index)
+	conns := make([]*PeerConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.rwm.Unlock()
+	for _, c := range conns {
+		c.WriteMsg(NewHaveMsg(res.Index))
+	}
+	return nil
+}
+
+// 记录活动的连接（用于广播 Have 与阻塞算法）并释放连接占用的 pending 名额，按需启动阻塞算法
+func (t *TorrentTask) addConn(conn *PeerConn, u *uploader) {
+	conn.lastPiece.Store(time.Now().UnixNano())
+	t.rwm.Lock()
+	if t.conns == nil {
+		t.conns = make(map[*PeerConn]*uploader)
+	}
+	t.conns[conn] = u
+	t.pending-- // 占用的名额转为连接
+	t.rwm.Unlock()
+	t.startChoker()
+}
+
+// 移除断开的连接
+func (t *TorrentTask) removeConn(conn *PeerConn) {
+	t.rwm.Lock()
+	defer t.rwm.Unlock()
+	delete(t.conns, conn)
+}
@@ -0,0 +1,169 @@
+package torrent_test
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+type memStorage struct { // 内存中的存储
+	mu   sync.Mutex
+	data []byte
+}
+
+func (s *memStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *memStorage) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copy(s.data[off:], p), nil
+}
+
+// 生成随机内容及对应的任务（PieceLen 不是 BLOCK_SIZE 的整数倍，最后一个 Piece 较短）
+func newTestTask(t *testing.T, name string, size, pieceLen int) (*torrent.TorrentTask, []byte) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	task := &torrent.TorrentTask{
+		FileName: name,
+		FileLen:  size,
+		InfoSHA:  sha1.Sum([]byte(name)),
+		PieceLen: pieceLen,
+		Manager:  &torrent.ConnManager{}, // 违反协议的测试不影响其他测试
+	}
+	for begin := 0; begin < size; begin += pieceLen {
+		end := min(begin+pieceLen, size)
+		task.PieceSHA = append(task.PieceSHA, sha1.Sum(data[begin:end]))
+	}
+	return task, data
+}
+
+// 启动做种的任务，返回其监听地址
+func startSeeder(t *testing.T, task *torrent.TorrentTask, data []byte) torrent.PeerInfo {
+	task.PeerId = [torrent.PEER_ID_LEN]byte{'s', 'e', 'e', 'd'}
+	task.Storage = &memStorage{data: data}
+	num, err := task.CheckStorage()
+	require.NoError(t, err)
+	require.Equal(t, len(task.PieceSHA), num)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := torrent.NewListener(ln)
+	listener.Register(task)
+	go listener.Serve()
+	t.Cleanup(func() { listener.Close() })
+
+	ctx := task.Download()
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("seeder should be complete")
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	return torrent.PeerInfo{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+func TestSeeding(t *testing.T) {
+	seeder, data := newTestTask(t, "seed", 100000, 40000)
+	peer := startSeeder(t, seeder, data)
+
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l', 'e', 'e', 'c', 'h'},
+		PeerList: []torrent.PeerInfo{peer},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+	}
+	ctx := leecher.Download()
+	for range ctx.GetResult() {
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("download timeout")
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+	for i := range leecher.PieceSHA {
+		require.True(t, leecher.HasPiece(i))
+	}
+}
+
+func TestStorageWithoutResults(t *testing.T) {
+	seeder, data := newTestTask(t, "results", 100000, 20000)
+	peer := startSeeder(t, seeder, data)
+
+	// 设置存储后不读取 GetResult 下载也能完成，结果不携带数据
+	leecher := &torrent.TorrentTask{
+		FileName: seeder.FileName,
+		FileLen:  seeder.FileLen,
+		InfoSHA:  seeder.InfoSHA,
+		PieceLen: seeder.PieceLen,
+		PieceSHA: seeder.PieceSHA,
+		PeerId:   [torrent.PEER_ID_LEN]byte{'l'},
+		PeerList: []torrent.PeerInfo{peer},
+		Storage:  &memStorage{data: make([]byte, len(data))},
+	}
+	ctx := leecher.Download()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("download timeout")
+	}
+	require.Equal(t, data, leecher.Storage.(*memStorage).data)
+	results := 0
+	for res := range ctx.GetResult() {
+		require.Nil(t, res.Data)
+		results++
+	}
+	require.Equal(t, len(leecher.PieceSHA), results)
+}
+
+func TestSeedingInvalidRequest(t *testing.T) {
+	seeder, data := newTestTask(t, "invalid", 100000, 40000)
+	peer := startSeeder(t, seeder, data)
+
+	conn, _, err := dialHandshake(t, &net.TCPAddr{IP: peer.IP, Port: int(peer.Port)}, seeder.InfoSHA, [torrent.PEER_ID_LEN]byte{'r'})
+	require.NoError(t, err)
+	pc := &torrent.PeerConn{Conn: conn}
+	msg, err := pc.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, torrent.MsgHaveAll, msg.Id) // 对端支持 Fast 扩展
+
+	_, err = pc.WriteMsg(&torrent.PeerMsg{Id: torrent.MsgInterested})
+	require.NoError(t, err)
+	readUntil(t, pc, torrent.MsgUnchoke)
+
+	// 合法的请求：最后一个 Piece 的最后一个块
+	_, err = pc.WriteMsg(torrent.NewRequestMsg(2, 16384, 20000-16384))
+	require.NoError(t, err)
+	msg, err = pc.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, torrent.MsgPiece, msg.Id)
+	require.Equal(t, uint32(2), binary.BigEndian.Uint32(msg.Payload[0:4]))
+	require.Equal(t, data[80000+16384:], msg.Payload[8:])
+
+	// 超出 Piece 长度的请求会导致连接被关闭
+	_, err = pc.WriteMsg(torrent.NewRequestMsg(2, 16384, torrent.BLOCK_SIZE))
+	require.NoError(t, err)
+	_, err = pc.ReadMsg()
+	require.Error(t, err)
+}
@@ -0,0 +1,288 @@
+package torrent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+type Message interface { // 类型化的 Peer 消息
+	Type() MsgId                   // 消息类型
+	AppendPayload(b []byte) []byte // 将 Payload 追加到 b
+}
+
+type EmptyMsg MsgId // 没有 Payload 的消息（Choke、Unchoke、Interested、NotInterested、HaveAll、HaveNone）
+
+type HaveMsg struct { // 拥有 Piece
+	Index int
+}
+
+type BitfieldMsg struct { // 拥有的 Piece 的位图
+	Field Bitfield
+}
+
+type RequestMsg struct { // 请求块
+	Index, Begin, Length int
+}
+
+type PieceMsg struct { // 块的数据
+	Index, Begin int
+	Data         []byte
+}
+
+type CancelMsg struct { // 取消请求
+	Index, Begin, Length int
+}
+
+type PortMsg struct { // DHT 端口（BEP 5）
+	Port uint16
+}
+
+type SuggestMsg struct { // 建议下载（BEP 6）
+	Index int
+}
+
+type RejectMsg struct { // 拒绝请求（BEP 6）
+	Index, Begin, Length int
+}
+
+type AllowedFastMsg struct { // 允许在阻塞时请求的 Piece（BEP 6）
+	Index int
+}
+
+type ExtendedMsg struct { // 扩展消息（BEP 10）
+	ExtId   uint8  // 扩展消息 ID（0 为扩展握手）
+	Payload []byte // B 编码的内容
+}
+
+func (m *PeerMsg) Type() MsgId {
+	return m.Id
+}
+
+func (m *PeerMsg) AppendPayload(b []byte) []byte {
+	return append(b, m.Payload...)
+}
+
+func (m EmptyMsg) Type() MsgId {
+	return MsgId(m)
+}
+
+func (m EmptyMsg) AppendPayload(b []byte) []byte {
+	return b
+}
+
+func (m HaveMsg) Type() MsgId {
+	return MsgHave
+}
+
+func (m HaveMsg) AppendPayload(b []byte) []byte {
+	return appendUint32(b, m.Index)
+}
+
+func (m BitfieldMsg) Type() MsgId {
+	return MsgBitfield
+}
+
+func (m BitfieldMsg) AppendPayload(b []byte) []byte {
+	return append(b, m.Field...)
+}
+
+func (m RequestMsg) Type() MsgId {
+	return MsgRequest
+}
+
+func (m RequestMsg) AppendPayload(b []byte) []byte {
+	return appendUint32(appendUint32(appendUint32(b, m.Index), m.Begin), m.Length)
+}
+
+func (m PieceMsg) Type() MsgId {
+	return MsgPiece
+}
+
+func (m PieceMsg) AppendPayload(b []byte) []byte {
+	return append(appendUint32(appendUint32(b, m.Index), m.Begin), m.Data...)
+}
+
+func (m CancelMsg) Type() MsgId {
+	return MsgCancel
+}
+
+func (m CancelMsg) AppendPayload(b []byte) []byte {
+	return RequestMsg(m).AppendPayload(b)
+}
+
+func (m PortMsg) Type() MsgId {
+	return MsgPort
+}
+
+func (m PortMsg) AppendPayload(b []byte) []byte {
+	return binary.BigEndian.AppendUint16(b, m.Port)
+}
+
+func (m SuggestMsg) Type() MsgId {
+	return MsgSuggest
+}
+
+func (m SuggestMsg) AppendPayload(b []byte) []byte {
+	return appendUint32(b, m.Index)
+}
+
+func (m RejectMsg) Type() MsgId {
+	return MsgReject
+}
+
+func (m RejectMsg) AppendPayload(b []byte) []byte {
+	return RequestMsg(m).AppendPayload(b)
+}
+
+func (m AllowedFastMsg) Type() MsgId {
+	return MsgAllowedFast
+}
+
+func (m AllowedFastMsg) AppendPayload(b []byte) []byte {
+	return appendUint32(b, m.Index)
+}
+
+func (m ExtendedMsg) Type() MsgId {
+	return MsgExtended
+}
+
+func (m ExtendedMsg) AppendPayload(b []byte) []byte {
+	return append(append(b, m.ExtId), m.Payload...)
+}
+
+func appendUint32(b []byte, v int) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(v))
+}
+
+// 将类型化的消息编码为 PeerMsg
+func Encode(m Message) *PeerMsg {
+	return &PeerMsg{m.Type(), m.AppendPayload(nil)}
+}
+
+// 将消息编码为连接上的格式（消息头、类型与 Payload）并追加到 b
+func appendFrame(b []byte, m Message) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0, byte(m.Type()))
+	b = m.AppendPayload(b)
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-int(PEER_MSG_HEAD_LEN)))
+	return b
+}
+
+// 各类消息 Payload 的最大长度，pieces 为种子的 Piece 数量（未知时为 0）
+func maxPayloadLen(id MsgId, pieces int) int {
+	switch id {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterest, MsgHaveAll, MsgHaveNone:
+		return 0
+	case MsgHave, MsgSuggest, MsgAllowedFast:
+		return 4
+	case MsgRequest, MsgCancel, MsgReject:
+		return 12
+	case MsgPiece:
+		return 8 + BLOCK_SIZE
+	case MsgPort:
+		return 2
+	case MsgBitfield:
+		if pieces > 0 {
+			return (pieces + 7) / 8
+		}
+		return MAX_BITFIELD_LEN
+	}
+	return MAX_EXTENDED_LEN // 扩展消息与未知的消息
+}
+
+// 解码并校验 PeerMsg（保活消息返回 nil），PieceMsg、BitfieldMsg 与 ExtendedMsg 引用 msg 的 Payload
+func Decode(msg *PeerMsg) (Message, error) {
+	if msg == nil {
+		return nil, nil
+	}
+	p := msg.Payload
+	if limit := maxPayloadLen(msg.Id, 0); len(p) > limit {
+		return nil, fmt.Errorf("%w: id %d, length %d > %d", ErrMsgTooLong, msg.Id, len(p), limit)
+	}
+	fixed := func(n int) error {
+		if len(p) != n {
+			return fmt.Errorf("%w: id %d, expected %d, got %d", ErrMsgLength, msg.Id, n, len(p))
+		}
+		return nil
+	}
+	u32 := func(i int) int {
+		return int(binary.BigEndian.Uint32(p[i : i+4]))
+	}
+	switch msg.Id {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterest, MsgHaveAll, MsgHaveNone:
+		if err := fixed(0); err != nil {
+			return nil, err
+		}
+		return EmptyMsg(msg.Id), nil
+	case MsgHave, MsgSuggest, MsgAllowedFast:
+		if err := fixed(4); err != nil {
+			return nil, err
+		}
+		switch msg.Id {
+		case MsgHave:
+			return HaveMsg{u32(0)}, nil
+		case MsgSuggest:
+			return SuggestMsg{u32(0)}, nil
+		}
+		return AllowedFastMsg{u32(0)}, nil
+	case MsgRequest, MsgCancel, MsgReject:
+		if err := fixed(12); err != nil {
+			return nil, err
+		}
+		req := RequestMsg{u32(0), u32(4), u32(8)}
+		switch msg.Id {
+		case MsgCancel:
+			return CancelMsg(req), nil
+		case MsgReject:
+			return RejectMsg(req), nil
+		}
+		return req, nil
+	case MsgPiece:
+		if len(p) < 8 {
+			return nil, fmt.Errorf("%w: id %d, length %d < 8", ErrMsgLength, msg.Id, len(p))
+		}
+		return PieceMsg{u32(0), u32(4), p[8:]}, nil
+	case MsgBitfield:
+		return BitfieldMsg{Bitfield(p)}, nil
+	case MsgPort:
+		if err := fixed(2); err != nil {
+			return nil, err
+		}
+		return PortMsg{binary.BigEndian.Uint16(p)}, nil
+	case MsgExtended:
+		if len(p) == 0 {
+			return nil, ErrMalformedExtMsg
+		}
+		return ExtendedMsg{p[0], p[1:]}, nil
+	}
+	return nil, fmt.Errorf("%w: id %d", ErrUnknownMsg, msg.Id)
+}
+
+var bufPool = sync.Pool{ // 块大小的缓冲区（用于 Piece 消息的收发）
+	New: func() any {
+		b := make([]byte, MSG_BUF_LEN)
+		return &b
+	},
+}
+
+// 从缓冲池取出长度为 0、容量为 MSG_BUF_LEN 的缓冲区
+func getBuf() []byte {
+	return (*bufPool.Get().(*[]byte))[:0]
+}
+
+// 归还缓冲区（不是从缓冲池取出的缓冲区会被忽略），归还后不能再使用
+func putBuf(b []byte) {
+	if cap(b) != MSG_BUF_LEN {
+		return
+	}
+	b = b[:0]
+	bufPool.Put(&b)
+}
+
+// 归还 Piece 消息的缓冲区（其他消息的 Payload 不是从缓冲池取出的），丢弃消息时调用
+func releaseMsg(msg *PeerMsg) {
+	if msg != nil && msg.Id == MsgPiece {
+		putBuf(msg.Payload)
+	}
+}
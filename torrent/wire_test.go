@@ -0,0 +1,70 @@
+package torrent_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireCodec(t *testing.T) {
+	msgs := []torrent.Message{
+		torrent.EmptyMsg(torrent.MsgChoke),
+		torrent.EmptyMsg(torrent.MsgHaveAll),
+		torrent.HaveMsg{Index: 7},
+		torrent.BitfieldMsg{Field: torrent.Bitfield{0xf0, 0x01}},
+		torrent.RequestMsg{Index: 1, Begin: torrent.BLOCK_SIZE, Length: torrent.BLOCK_SIZE},
+		torrent.PieceMsg{Index: 2, Begin: 0, Data: []byte("block")},
+		torrent.CancelMsg{Index: 3, Begin: 16, Length: 32},
+		torrent.PortMsg{Port: 6881},
+		torrent.SuggestMsg{Index: 4},
+		torrent.RejectMsg{Index: 5, Begin: 0, Length: 100},
+		torrent.AllowedFastMsg{Index: 6},
+		torrent.ExtendedMsg{ExtId: 1, Payload: []byte("d1:ai1ee")},
+	}
+	for _, m := range msgs {
+		decoded, err := torrent.Decode(torrent.Encode(m))
+		require.NoError(t, err)
+		require.Equal(t, m, decoded)
+	}
+	require.Equal(t, torrent.NewRequestMsg(1, 2, 3), torrent.Encode(torrent.RequestMsg{Index: 1, Begin: 2, Length: 3}))
+
+	// 长度不符、过长、未知类型与空的扩展消息
+	_, err := torrent.Decode(&torrent.PeerMsg{Id: torrent.MsgHave, Payload: make([]byte, 3)})
+	require.ErrorIs(t, err, torrent.ErrMsgLength)
+	_, err = torrent.Decode(&torrent.PeerMsg{Id: torrent.MsgUnchoke, Payload: []byte{0}})
+	require.ErrorIs(t, err, torrent.ErrMsgTooLong)
+	_, err = torrent.Decode(&torrent.PeerMsg{Id: torrent.MsgPiece, Payload: make([]byte, 9+torrent.BLOCK_SIZE)})
+	require.ErrorIs(t, err, torrent.ErrMsgTooLong)
+	_, err = torrent.Decode(&torrent.PeerMsg{Id: 99})
+	require.ErrorIs(t, err, torrent.ErrUnknownMsg)
+	_, err = torrent.Decode(&torrent.PeerMsg{Id: torrent.MsgExtended})
+	require.ErrorIs(t, err, torrent.ErrMalformedExtMsg)
+}
+
+func TestReadOversizedMsg(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// 在读取 Payload 前拒绝声明的长度超过上限的消息
+	go func() {
+		head := binary.BigEndian.AppendUint32(nil, 0xffffffff)
+		remote.Write(append(head, byte(torrent.MsgPiece)))
+	}()
+	_, err := (&torrent.PeerConn{Conn: local}).ReadMsg()
+	require.ErrorIs(t, err, torrent.ErrMsgTooLong)
+
+	// 上限内的消息正常读取
+	go func() {
+		remote.Write(binary.BigEndian.AppendUint32(nil, 5))
+		remote.Write([]byte{byte(torrent.MsgHave), 0, 0, 0, 9})
+	}()
+	msg, err := (&torrent.PeerConn{Conn: local}).ReadMsg()
+	require.NoError(t, err)
+	decoded, err := torrent.Decode(msg)
+	require.NoError(t, err)
+	require.Equal(t, torrent.HaveMsg{Index: 9}, decoded)
+}
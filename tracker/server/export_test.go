@@ -0,0 +1,8 @@
+package server
+
+import "time"
+
+// 替换注册表的时钟
+func (r *Registry) ExportSetNow(now func() time.Time) {
+	r.now = now
+}
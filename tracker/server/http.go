@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Akimio521/torrent-go/bencode"
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type Config struct { // Tracker 配置
+	Interval  time.Duration     // 客户端宣告间隔（为 0 时使用 DEFAULT_INTERVAL；设置了 Registry 时以注册表为准，只能为 0 或与其一致）
+	AllowList [][sha1.Size]byte // info_hash 白名单（为空时不限制；设置了 Registry 时以注册表为准，只能为空）
+	Registry  *Registry         // 共享的 Swarm 注册表（为 nil 时按 Interval 与 AllowList 新建）
+	UDPRate   float64           // UDP Tracker 每个来源地址每秒允许的请求数（为 0 时使用 DEFAULT_UDP_RATE）
+	UDPBurst  int               // UDP Tracker 每个来源地址允许的突发请求数（为 0 时使用 DEFAULT_UDP_BURST）
+}
+
+type Tracker struct { // HTTP Tracker，提供 /announce 与 /scrape
+	Registry *Registry     // Swarm 注册表
+	interval time.Duration // 客户端宣告间隔
+	mux      *http.ServeMux
+}
+
+// 返回 Tracker 使用的注册表：Registry 为 nil 时新建；共享的注册表已有自己的间隔与白名单，Config 中与其不一致的设置会返回错误而不是被忽略
+func (cfg *Config) registry() (*Registry, error) {
+	if cfg.Registry == nil {
+		return NewRegistry(cfg.Interval, cfg.AllowList), nil
+	}
+	if cfg.Interval != 0 && ClampInterval(cfg.Interval) != cfg.Registry.Interval() {
+		return nil, fmt.Errorf("%w: interval %s, registry interval %s", ErrRegistryConfig, cfg.Interval, cfg.Registry.Interval())
+	}
+	if len(cfg.AllowList) > 0 {
+		return nil, fmt.Errorf("%w: allow list must be set on the registry", ErrRegistryConfig)
+	}
+	return cfg.Registry, nil
+}
+
+// 新建 HTTP Tracker
+func NewTracker(cfg Config) (*Tracker, error) {
+	r, err := cfg.registry()
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracker{
+		Registry: r,
+		interval: r.Interval(),
+		mux:      http.NewServeMux(),
+	}
+	t.mux.HandleFunc("/announce", t.handleAnnounce)
+	t.mux.HandleFunc("/scrape", t.handleScrape)
+	return t, nil
+}
+
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mux.ServeHTTP(w, r)
+}
+
+// 处理宣告请求
+func (t *Tracker) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &AnnounceRequest{Event: parseEvent(q.Get("event"))}
+
+	infoHash := q.Get("info_hash")
+	if len(infoHash) != sha1.Size {
+		writeFailure(w, ErrInvalidInfoHash.Error())
+		return
+	}
+	copy(req.InfoHash[:], infoHash)
+	peerId := q.Get("peer_id")
+	if len(peerId) != torrent.PEER_ID_LEN {
+		writeFailure(w, ErrInvalidPeerId.Error())
+		return
+	}
+	copy(req.PeerId[:], peerId)
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil {
+		writeFailure(w, ErrInvalidPort.Error())
+		return
+	}
+	req.Port = uint16(port)
+	req.Uploaded, _ = strconv.Atoi(q.Get("uploaded"))
+	req.Downloaded, _ = strconv.Atoi(q.Get("downloaded"))
+	req.Left, _ = strconv.Atoi(q.Get("left"))
+	req.NumWant, _ = strconv.Atoi(q.Get("numwant"))
+	if req.IP = remoteIP(r); req.IP == nil {
+		writeFailure(w, "can not determine client ip")
+		return
+	}
+
+	peers, stats, err := t.Registry.Announce(req)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if q.Get("compact") == "0" { // 非紧凑格式：Peers 为字典列表
+		noPeerId := q.Get("no_peer_id") == "1"
+		list := make([]*bencode.BObject, 0, len(peers))
+		for _, pe := range peers {
+			dict := map[string]*bencode.BObject{
+				"ip":   bencode.GetBObject(pe.IP.String()),
+				"port": bencode.GetBObject(int(pe.Port)),
+			}
+			if !noPeerId {
+				dict["peer id"] = bencode.GetBObject(string(pe.PeerId[:]))
+			}
+			list = append(list, bencode.GetBObject(dict))
+		}
+		resp := bencode.GetBObject(map[string]*bencode.BObject{
+			"complete":   bencode.GetBObject(stats.Complete),
+			"incomplete": bencode.GetBObject(stats.Incomplete),
+			"interval":   bencode.GetBObject(int(t.interval.Seconds())),
+			"peers":      bencode.GetBObject(list),
+		})
+		if _, err = resp.Bencode(buf); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		peerInfos := make([]torrent.PeerInfo, 0, len(peers))
+		for _, pe := range peers {
+			peerInfos = append(peerInfos, pe.PeerInfo())
+		}
+		resp := &torrent.TrackerResponse{
+			Complete:   stats.Complete,
+			Incomplete: stats.Incomplete,
+			Interval:   int(t.interval.Seconds()),
+		}
+		resp.Peers, resp.Peers6 = torrent.EncodePeerInfos(peerInfos)
+		if _, err = bencode.Marshal(buf, resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(buf.Bytes())
+}
+
+// 处理 Scrape 请求（不支持全量 Scrape，未指定 info_hash 时返回空结果）
+func (t *Tracker) handleScrape(w http.ResponseWriter, r *http.Request) {
+	files := make(map[string]*bencode.BObject)
+	for _, h := range r.URL.Query()["info_hash"] {
+		if len(h) != sha1.Size {
+			writeFailure(w, ErrInvalidInfoHash.Error())
+			return
+		}
+		var infoHash [sha1.Size]byte
+		copy(infoHash[:], h)
+		stats, ok := t.Registry.Scrape(infoHash)
+		if !ok {
+			continue
+		}
+		files[h] = bencode.GetBObject(map[string]*bencode.BObject{
+			"complete":   bencode.GetBObject(stats.Complete),
+			"downloaded": bencode.GetBObject(stats.Downloaded),
+			"incomplete": bencode.GetBObject(stats.Incomplete),
+		})
+	}
+	resp := bencode.GetBObject(map[string]*bencode.BObject{
+		"files": bencode.GetBObject(files),
+	})
+	buf := new(bytes.Buffer)
+	if _, err := resp.Bencode(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(buf.Bytes())
+}
+
+// 返回失败原因（HTTP 状态码仍为 200，客户端通过 failure reason 判断）
+func writeFailure(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "text/plain")
+	bencode.GetBObject(map[string]*bencode.BObject{
+		"failure reason": bencode.GetBObject(reason),
+	}).Bencode(w)
+}
+
+// 获取请求方的 IP 地址
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
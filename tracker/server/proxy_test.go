@@ -0,0 +1,53 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/proxy"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/Akimio521/torrent-go/tracker/server"
+	"github.com/stretchr/testify/require"
+)
+
+// 启动进程内的代理
+func startProxy(t *testing.T) (*proxy.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &proxy.Server{}
+	go s.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	return s, ln.Addr().String()
+}
+
+func TestAnnounceThroughProxy(t *testing.T) {
+	registry := server.NewRegistry(time.Minute, nil)
+	ps, addr := startProxy(t)
+
+	// UDP Tracker 通过 SOCKS5 UDP ASSOCIATE
+	conn := startUDPServer(t, server.Config{Registry: registry})
+	tf := newTorrentFile(t, "udp://"+conn.LocalAddr().String()+"/announce")
+	tf.Manager = &torrent.ConnManager{Proxy: &proxy.SOCKS5{Addr: addr}, ProxyOnly: true}
+	_, err := tf.FindPeers(newPeerId('a'), 6881)
+	require.NoError(t, err)
+	require.Contains(t, ps.Requests(), proxy.Request{Network: "udp", Addr: conn.LocalAddr().String()})
+
+	// HTTP Tracker 通过 HTTP CONNECT
+	ts := startTracker(t, server.Config{Registry: registry})
+	tf = newTorrentFile(t, ts.URL+"/announce")
+	tf.Manager = &torrent.ConnManager{Proxy: &proxy.HTTPConnect{Addr: addr}, ProxyOnly: true}
+	peers, err := tf.FindPeers(newPeerId('b'), 6882)
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Contains(t, ps.Requests(), proxy.Request{Network: "tcp", Addr: ts.Listener.Addr().String()})
+
+	// HTTP CONNECT 不支持 UDP，只通过代理连接时不会直接连接
+	tf = newTorrentFile(t, "udp://"+conn.LocalAddr().String()+"/announce")
+	tf.Manager = &torrent.ConnManager{Proxy: &proxy.HTTPConnect{Addr: addr}, ProxyOnly: true}
+	_, err = tf.FindPeers(newPeerId('c'), 6883)
+	require.ErrorContains(t, err, proxy.ErrUDPNotSupported.Error())
+	tf.Manager = &torrent.ConnManager{ProxyOnly: true}
+	_, err = tf.FindPeers(newPeerId('c'), 6883)
+	require.ErrorContains(t, err, torrent.ErrProxyRequired.Error())
+}
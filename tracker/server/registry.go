@@ -0,0 +1,225 @@
+package server
+
+import (
+	"crypto/sha1"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type AnnounceRequest struct { // 宣告请求（HTTP 与 UDP 共用）
+	InfoHash   [sha1.Size]byte           // 种子的 info 的 SHA-1 哈希
+	PeerId     [torrent.PEER_ID_LEN]byte // 客户端的 Peer ID
+	IP         net.IP                    // 客户端 IP
+	Port       uint16                    // 客户端监听端口
+	Uploaded   int                       // 已上传字节数
+	Downloaded int                       // 已下载字节数
+	Left       int                       // 剩余字节数
+	Event      Event                     // 宣告事件
+	NumWant    int                       // 希望返回的 Peer 数量（<= 0 表示使用默认值）
+}
+
+type ScrapeStats struct { // 单个种子的统计信息
+	Complete   int // 做种者数量
+	Downloaded int // 已完成下载的次数
+	Incomplete int // 下载者数量
+}
+
+type PeerEntry struct { // Swarm 中的一个 Peer
+	PeerId   [torrent.PEER_ID_LEN]byte // Peer ID
+	IP       net.IP                    // IP 地址
+	Port     uint16                    // 端口号
+	Left     int                       // 剩余字节数（为 0 表示做种者）
+	LastSeen time.Time                 // 最近一次宣告的时间
+}
+
+// 转换为 torrent 包中的 PeerInfo
+func (pe *PeerEntry) PeerInfo() torrent.PeerInfo {
+	return torrent.PeerInfo{IP: pe.IP, Port: pe.Port}
+}
+
+type swarm struct { // 同一个种子的所有 Peer
+	peers      map[[torrent.PEER_ID_LEN]byte]*PeerEntry // 以 Peer ID 为键
+	downloaded int                                      // 已完成下载的次数
+}
+
+// 统计做种者与下载者数量
+func (s *swarm) stats() ScrapeStats {
+	st := ScrapeStats{Downloaded: s.downloaded}
+	for _, pe := range s.peers {
+		if pe.Left == 0 {
+			st.Complete++
+		} else {
+			st.Incomplete++
+		}
+	}
+	return st
+}
+
+// 移除在 deadline 之前没有再次宣告的 Peer
+func (s *swarm) expire(deadline time.Time) {
+	for id, pe := range s.peers {
+		if pe.LastSeen.Before(deadline) {
+			delete(s.peers, id)
+		}
+	}
+}
+
+type Registry struct { // 内存中的 Swarm 注册表，以 info_hash 为键
+	rwm      sync.RWMutex
+	swarms   map[[sha1.Size]byte]*swarm
+	allow    map[[sha1.Size]byte]struct{} // info_hash 白名单（为 nil 时不限制）
+	interval time.Duration                // 告知客户端的宣告间隔
+	ttl      time.Duration                // Peer 的存活时间
+	now      func() time.Time             // 当前时间（便于测试）
+}
+
+// 告知客户端的宣告间隔：不小于 MIN_INTERVAL，<= 0 时使用 DEFAULT_INTERVAL
+func ClampInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return DEFAULT_INTERVAL
+	}
+	return max(interval, MIN_INTERVAL)
+}
+
+// 新建注册表，Peer 在 interval*EXPIRE_INTERVALS 内未宣告即过期（interval 与告知客户端的间隔一样经过 ClampInterval）；allowList 为空时接受任意 info_hash
+func NewRegistry(interval time.Duration, allowList [][sha1.Size]byte) *Registry {
+	interval = ClampInterval(interval)
+	r := &Registry{
+		swarms:   make(map[[sha1.Size]byte]*swarm),
+		interval: interval,
+		ttl:      interval * EXPIRE_INTERVALS,
+		now:      time.Now,
+	}
+	if len(allowList) > 0 {
+		r.allow = make(map[[sha1.Size]byte]struct{}, len(allowList))
+		for _, h := range allowList {
+			r.allow[h] = struct{}{}
+		}
+	}
+	return r
+}
+
+// 告知客户端的宣告间隔
+func (r *Registry) Interval() time.Duration {
+	return r.interval
+}
+
+// 检查 info_hash 是否允许被追踪
+func (r *Registry) Allowed(infoHash [sha1.Size]byte) bool {
+	if r.allow == nil {
+		return true
+	}
+	_, ok := r.allow[infoHash]
+	return ok
+}
+
+// 处理一次宣告，返回随机挑选的其他 Peer（不包含请求者）以及该种子的统计信息
+func (r *Registry) Announce(req *AnnounceRequest) ([]PeerEntry, ScrapeStats, error) {
+	if !r.Allowed(req.InfoHash) {
+		return nil, ScrapeStats{}, ErrNotAllowed
+	}
+	if req.Port == 0 {
+		return nil, ScrapeStats{}, ErrInvalidPort
+	}
+	numWant := req.NumWant
+	if numWant <= 0 {
+		numWant = DEFAULT_NUMWANT
+	} else if numWant > MAX_NUMWANT {
+		numWant = MAX_NUMWANT
+	}
+
+	r.rwm.Lock()
+	defer r.rwm.Unlock()
+
+	now := r.now()
+	s, ok := r.swarms[req.InfoHash]
+	if !ok {
+		if req.Event == EventStopped {
+			return nil, ScrapeStats{}, nil
+		}
+		s = &swarm{peers: make(map[[torrent.PEER_ID_LEN]byte]*PeerEntry)}
+		r.swarms[req.InfoHash] = s
+	}
+	s.expire(now.Add(-r.ttl))
+
+	switch req.Event {
+	case EventStopped:
+		delete(s.peers, req.PeerId)
+	default:
+		pe, exist := s.peers[req.PeerId]
+		if !exist {
+			pe = &PeerEntry{PeerId: req.PeerId}
+			s.peers[req.PeerId] = pe
+		}
+		if req.Event == EventCompleted && (!exist || pe.Left != 0) { // 新的条目（如 Tracker 重启后）也计入
+			s.downloaded++
+		}
+		pe.IP = req.IP
+		pe.Port = req.Port
+		pe.Left = req.Left
+		pe.LastSeen = now
+	}
+	stats := s.stats()
+	if len(s.peers) == 0 && s.downloaded == 0 {
+		delete(r.swarms, req.InfoHash)
+		return nil, stats, nil
+	}
+	if req.Event == EventStopped || len(s.peers) == 0 {
+		return nil, stats, nil
+	}
+
+	peers := make([]PeerEntry, 0, len(s.peers))
+	for id, pe := range s.peers {
+		if id == req.PeerId {
+			continue
+		}
+		if req.Left == 0 && pe.Left == 0 { // 做种者之间无需互相连接
+			continue
+		}
+		peers = append(peers, *pe)
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > numWant {
+		peers = peers[:numWant]
+	}
+	return peers, stats, nil
+}
+
+// 查询种子的统计信息，不存在或不允许的种子返回 false
+func (r *Registry) Scrape(infoHash [sha1.Size]byte) (ScrapeStats, bool) {
+	if !r.Allowed(infoHash) {
+		return ScrapeStats{}, false
+	}
+	r.rwm.Lock()
+	defer r.rwm.Unlock()
+	s, ok := r.swarms[infoHash]
+	if !ok {
+		return ScrapeStats{}, false
+	}
+	s.expire(r.now().Add(-r.ttl))
+	return s.stats(), true
+}
+
+// 清理所有过期的 Peer 和空的 Swarm
+func (r *Registry) Expire() {
+	r.rwm.Lock()
+	defer r.rwm.Unlock()
+	deadline := r.now().Add(-r.ttl)
+	for h, s := range r.swarms {
+		s.expire(deadline)
+		if len(s.peers) == 0 && s.downloaded == 0 {
+			delete(r.swarms, h)
+		}
+	}
+}
+
+// 当前追踪的种子数量
+func (r *Registry) Len() int {
+	r.rwm.RLock()
+	defer r.rwm.RUnlock()
+	return len(r.swarms)
+}
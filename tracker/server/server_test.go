@@ -0,0 +1,205 @@
+package server_test
+
+import (
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/bencode"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/Akimio521/torrent-go/tracker/server"
+	"github.com/stretchr/testify/require"
+)
+
+var testInfoHash = sha1.Sum([]byte("torrent-go"))
+
+func newPeerId(b byte) [torrent.PEER_ID_LEN]byte {
+	var id [torrent.PEER_ID_LEN]byte
+	for i := range id {
+		id[i] = b
+	}
+	return id
+}
+
+// 启动一个 HTTP Tracker
+func startTracker(t *testing.T, cfg server.Config) *httptest.Server {
+	tracker, err := server.NewTracker(cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(tracker)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// 发送一次 HTTP 宣告，返回解析后的响应
+func announce(t *testing.T, base string, peerId [torrent.PEER_ID_LEN]byte, port int, extra url.Values) *bencode.BObject {
+	params := url.Values{
+		"info_hash": []string{string(testInfoHash[:])},
+		"peer_id":   []string{string(peerId[:])},
+		"port":      []string{strconv.Itoa(port)},
+		"left":      []string{"100"},
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	resp, err := http.Get(base + "/announce?" + params.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	obj, err := bencode.Parse(resp.Body)
+	require.NoError(t, err)
+	return obj
+}
+
+func TestAnnounceCompact(t *testing.T) {
+	ts := startTracker(t, server.Config{Interval: time.Minute})
+
+	obj := announce(t, ts.URL, newPeerId('a'), 6881, nil)
+	tr := new(torrent.TrackerResponse)
+	require.NoError(t, bencode.UnmarshalBObject(obj, tr))
+	require.Equal(t, 60, tr.Interval)
+	require.Equal(t, 1, tr.Incomplete)
+	peers, err := tr.ParsePeerInfos()
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	obj = announce(t, ts.URL, newPeerId('b'), 6882, nil)
+	tr = new(torrent.TrackerResponse)
+	require.NoError(t, bencode.UnmarshalBObject(obj, tr))
+	require.Equal(t, 2, tr.Incomplete)
+	peers, err = tr.ParsePeerInfos()
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, "127.0.0.1:6881", peers[0].GetConnAddr())
+}
+
+func TestAnnounceNonCompact(t *testing.T) {
+	ts := startTracker(t, server.Config{})
+
+	announce(t, ts.URL, newPeerId('a'), 6881, nil)
+	obj := announce(t, ts.URL, newPeerId('b'), 6882, url.Values{"compact": []string{"0"}})
+
+	var dict map[string]*bencode.BObject
+	require.NoError(t, bencode.GetValue(obj, &dict))
+	var list []*bencode.BObject
+	require.NoError(t, bencode.GetValue(dict["peers"], &list))
+	require.Len(t, list, 1)
+
+	var peer map[string]*bencode.BObject
+	require.NoError(t, bencode.GetValue(list[0], &peer))
+	var ip, peerId string
+	var port int
+	require.NoError(t, bencode.GetValue(peer["ip"], &ip))
+	require.NoError(t, bencode.GetValue(peer["peer id"], &peerId))
+	require.NoError(t, bencode.GetValue(peer["port"], &port))
+	require.Equal(t, "127.0.0.1", ip)
+	id := newPeerId('a')
+	require.Equal(t, string(id[:]), peerId)
+	require.Equal(t, 6881, port)
+}
+
+func TestAnnounceStoppedAndScrape(t *testing.T) {
+	ts := startTracker(t, server.Config{})
+
+	announce(t, ts.URL, newPeerId('a'), 6881, nil)
+	announce(t, ts.URL, newPeerId('b'), 6882, url.Values{"left": []string{"0"}})
+	announce(t, ts.URL, newPeerId('a'), 6881, url.Values{"left": []string{"0"}, "event": []string{"completed"}})
+	announce(t, ts.URL, newPeerId('b'), 6882, url.Values{"event": []string{"stopped"}})
+
+	resp, err := http.Get(ts.URL + "/scrape?" + url.Values{"info_hash": []string{string(testInfoHash[:])}}.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	obj, err := bencode.Parse(resp.Body)
+	require.NoError(t, err)
+
+	var dict, files, stats map[string]*bencode.BObject
+	require.NoError(t, bencode.GetValue(obj, &dict))
+	require.NoError(t, bencode.GetValue(dict["files"], &files))
+	require.NoError(t, bencode.GetValue(files[string(testInfoHash[:])], &stats))
+	var complete, downloaded, incomplete int
+	require.NoError(t, bencode.GetValue(stats["complete"], &complete))
+	require.NoError(t, bencode.GetValue(stats["downloaded"], &downloaded))
+	require.NoError(t, bencode.GetValue(stats["incomplete"], &incomplete))
+	require.Equal(t, 1, complete)
+	require.Equal(t, 1, downloaded)
+	require.Equal(t, 0, incomplete)
+}
+
+func TestAllowList(t *testing.T) {
+	other := sha1.Sum([]byte("other"))
+	ts := startTracker(t, server.Config{AllowList: [][sha1.Size]byte{other}})
+
+	obj := announce(t, ts.URL, newPeerId('a'), 6881, nil)
+	var dict map[string]*bencode.BObject
+	require.NoError(t, bencode.GetValue(obj, &dict))
+	var reason string
+	require.NoError(t, bencode.GetValue(dict["failure reason"], &reason))
+	require.Equal(t, server.ErrNotAllowed.Error(), reason)
+}
+
+func TestSharedRegistryConfig(t *testing.T) {
+	registry := server.NewRegistry(time.Minute, nil)
+
+	// 共享注册表时，间隔与白名单以注册表为准，冲突的设置返回错误
+	_, err := server.NewTracker(server.Config{Registry: registry, Interval: time.Hour})
+	require.ErrorIs(t, err, server.ErrRegistryConfig)
+	_, err = server.NewUDPServer(nil, server.Config{Registry: registry, AllowList: [][sha1.Size]byte{testInfoHash}})
+	require.ErrorIs(t, err, server.ErrRegistryConfig)
+
+	// 未设置或一致的间隔使用注册表的间隔
+	for _, interval := range []time.Duration{0, time.Minute} {
+		ts := startTracker(t, server.Config{Registry: registry, Interval: interval})
+		tr := new(torrent.TrackerResponse)
+		require.NoError(t, bencode.UnmarshalBObject(announce(t, ts.URL, newPeerId('a'), 6881, nil), tr))
+		require.Equal(t, 60, tr.Interval)
+	}
+}
+
+func TestRegistryExpire(t *testing.T) {
+	now := time.Now()
+	r := server.NewRegistry(time.Minute, nil)
+	r.ExportSetNow(func() time.Time { return now })
+
+	req := &server.AnnounceRequest{InfoHash: testInfoHash, PeerId: newPeerId('a'), Port: 6881, Left: 1}
+	_, _, err := r.Announce(req)
+	require.NoError(t, err)
+	require.Equal(t, 1, r.Len())
+
+	now = now.Add(time.Minute * server.EXPIRE_INTERVALS / 2)
+	r.Expire()
+	require.Equal(t, 1, r.Len())
+
+	now = now.Add(time.Minute * server.EXPIRE_INTERVALS)
+	r.Expire()
+	require.Equal(t, 0, r.Len())
+}
+
+func TestRegistryIntervalClamp(t *testing.T) {
+	require.Equal(t, server.MIN_INTERVAL, server.ClampInterval(3*time.Second))
+	require.Equal(t, server.DEFAULT_INTERVAL, server.ClampInterval(0))
+
+	// 过期时间与告知客户端的间隔（MIN_INTERVAL）一致
+	now := time.Now()
+	r := server.NewRegistry(3*time.Second, nil)
+	r.ExportSetNow(func() time.Time { return now })
+	_, _, err := r.Announce(&server.AnnounceRequest{InfoHash: testInfoHash, PeerId: newPeerId('a'), Port: 6881, Left: 1})
+	require.NoError(t, err)
+	now = now.Add(server.MIN_INTERVAL)
+	r.Expire()
+	require.Equal(t, 1, r.Len())
+}
+
+func TestRegistryScrapeAndCompleted(t *testing.T) {
+	r := server.NewRegistry(time.Minute, nil)
+	_, ok := r.Scrape(testInfoHash)
+	require.False(t, ok)
+
+	// 首次出现的 Peer 宣告 completed（如 Tracker 重启后）也计入下载次数
+	_, _, err := r.Announce(&server.AnnounceRequest{InfoHash: testInfoHash, PeerId: newPeerId('a'), Port: 6881, Event: server.EventCompleted})
+	require.NoError(t, err)
+	stats, ok := r.Scrape(testInfoHash)
+	require.True(t, ok)
+	require.Equal(t, server.ScrapeStats{Complete: 1, Downloaded: 1}, stats)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	DEFAULT_INTERVAL = 30 * time.Minute // 默认的客户端宣告间隔
+	MIN_INTERVAL     = 10 * time.Second // 最小宣告间隔
+	EXPIRE_INTERVALS = 2                // 连续错过多少个宣告间隔后将 Peer 移出 Swarm
+	DEFAULT_NUMWANT  = 50               // 客户端未指定时返回的 Peer 数量
+	MAX_NUMWANT      = 200              // 单次最多返回的 Peer 数量
+
+	UDP_MIN_REQUEST   = 16              // UDP 请求的最小长度
+	CONNECTION_ID_TTL = 2 * time.Minute // UDP connection id 的有效期
+	DEFAULT_UDP_RATE  = 5.0             // 每个来源地址每秒允许的 UDP 请求数
+	DEFAULT_UDP_BURST = 20              // 每个来源地址允许的突发 UDP 请求数
+)
+
+type Event uint8 // 宣告事件（取值与 BEP 15 UDP Tracker 协议一致）
+
+const (
+	EventNone      Event = iota // 常规宣告
+	EventCompleted              // 下载完成
+	EventStarted                // 开始下载
+	EventStopped                // 停止下载
+)
+
+var (
+	ErrInvalidInfoHash = errors.New("invalid info_hash")                              // info_hash 非法
+	ErrInvalidPeerId   = errors.New("invalid peer_id")                                // peer_id 非法
+	ErrInvalidPort     = errors.New("invalid port")                                   // 端口非法
+	ErrNotAllowed      = errors.New("info_hash not allowed")                          // info_hash 不在白名单中
+	ErrRegistryConfig  = errors.New("conflicting tracker config for shared registry") // 设置了共享的注册表时 Config 中的间隔或白名单与其冲突
+
+	ErrInvalidConnectionId = errors.New("invalid connection id") // UDP connection id 非法或已过期
+	ErrInvalidAction       = errors.New("invalid action")        // UDP 请求的 action 非法
+	ErrMalformedRequest    = errors.New("malformed request")     // UDP 请求格式错误
+)
+
+// 将 HTTP 宣告中的 event 参数转换为 Event
+func parseEvent(s string) Event {
+	switch s {
+	case "completed":
+		return EventCompleted
+	case "started":
+		return EventStarted
+	case "stopped":
+		return EventStopped
+	default:
+		return EventNone
+	}
+}
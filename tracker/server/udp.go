@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Akimio521/torrent-go/torrent"
+)
+
+type UDPServer struct { // UDP Tracker（BEP 15）
+	Registry *Registry // Swarm 注册表（可与 HTTP Tracker 共享）
+	conn     net.PacketConn
+	interval time.Duration
+	secret   [32]byte     // 签发 connection id 的密钥
+	limiter  *addrLimiter // 按来源地址限速
+	now      func() time.Time
+}
+
+// 在 conn 上新建 UDP Tracker，cfg.Registry 不为空时与其他 Tracker 共享注册表
+func NewUDPServer(conn net.PacketConn, cfg Config) (*UDPServer, error) {
+	r, err := cfg.registry()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UDPRate <= 0 {
+		cfg.UDPRate = DEFAULT_UDP_RATE
+	}
+	if cfg.UDPBurst <= 0 {
+		cfg.UDPBurst = DEFAULT_UDP_BURST
+	}
+	s := &UDPServer{
+		Registry: r,
+		conn:     conn,
+		interval: r.Interval(),
+		limiter:  newAddrLimiter(cfg.UDPRate, cfg.UDPBurst),
+		now:      time.Now,
+	}
+	rand.Read(s.secret[:])
+	return s, nil
+}
+
+// 处理请求直到 conn 被关闭
+func (s *UDPServer) Serve() error {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < UDP_MIN_REQUEST {
+			continue
+		}
+		if !s.limiter.allow(udpAddr.IP, s.now()) { // 超出速率限制的请求直接丢弃
+			continue
+		}
+		if resp := s.handle(buf[:n], udpAddr); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// 关闭底层连接
+func (s *UDPServer) Close() error {
+	return s.conn.Close()
+}
+
+// 处理单个请求，返回响应（为 nil 时不响应）
+func (s *UDPServer) handle(req []byte, addr *net.UDPAddr) []byte {
+	connID := binary.BigEndian.Uint64(req[0:8])
+	action := binary.BigEndian.Uint32(req[8:12])
+	txID := binary.BigEndian.Uint32(req[12:16])
+
+	if action == torrent.UDP_ACTION_CONNECT {
+		if connID != torrent.UDP_PROTOCOL_ID {
+			return nil
+		}
+		resp := make([]byte, torrent.UDP_CONNECT_LEN)
+		binary.BigEndian.PutUint32(resp[0:4], torrent.UDP_ACTION_CONNECT)
+		binary.BigEndian.PutUint32(resp[4:8], txID)
+		binary.BigEndian.PutUint64(resp[8:16], s.connectionID(addr, s.now()))
+		return resp
+	}
+	if !s.validConnectionID(connID, addr) {
+		return errorResponse(txID, ErrInvalidConnectionId.Error())
+	}
+	switch action {
+	case torrent.UDP_ACTION_ANNOUNCE:
+		return s.handleAnnounce(req, addr, txID)
+	case torrent.UDP_ACTION_SCRAPE:
+		return s.handleScrape(req, txID)
+	default:
+		return errorResponse(txID, ErrInvalidAction.Error())
+	}
+}
+
+// 处理宣告请求
+func (s *UDPServer) handleAnnounce(req []byte, addr *net.UDPAddr, txID uint32) []byte {
+	if len(req) < torrent.UDP_ANNOUNCE_LEN {
+		return errorResponse(txID, ErrMalformedRequest.Error())
+	}
+	ar := &AnnounceRequest{
+		Downloaded: int(binary.BigEndian.Uint64(req[56:64])),
+		Left:       int(binary.BigEndian.Uint64(req[64:72])),
+		Uploaded:   int(binary.BigEndian.Uint64(req[72:80])),
+		Event:      Event(binary.BigEndian.Uint32(req[80:84])),
+		NumWant:    int(int32(binary.BigEndian.Uint32(req[92:96]))),
+		Port:       binary.BigEndian.Uint16(req[96:98]),
+	}
+	copy(ar.InfoHash[:], req[16:36])
+	copy(ar.PeerId[:], req[36:56])
+	ip4 := addr.IP.To4()
+	if ip4 != nil {
+		ar.IP = ip4
+	} else {
+		ar.IP = addr.IP
+	}
+
+	peers, stats, err := s.Registry.Announce(ar)
+	if err != nil {
+		return errorResponse(txID, err.Error())
+	}
+
+	// 仅返回与请求者地址族相同的 Peer
+	peerInfos := make([]torrent.PeerInfo, 0, len(peers))
+	for _, pe := range peers {
+		if (pe.IP.To4() != nil) == (ip4 != nil) {
+			peerInfos = append(peerInfos, pe.PeerInfo())
+		}
+	}
+	peers4, peers6 := torrent.EncodePeerInfos(peerInfos)
+
+	resp := make([]byte, torrent.UDP_ANNOUNCE_HEAD, torrent.UDP_ANNOUNCE_HEAD+len(peers4)+len(peers6))
+	binary.BigEndian.PutUint32(resp[0:4], torrent.UDP_ACTION_ANNOUNCE)
+	binary.BigEndian.PutUint32(resp[4:8], txID)
+	binary.BigEndian.PutUint32(resp[8:12], uint32(s.interval.Seconds()))
+	binary.BigEndian.PutUint32(resp[12:16], uint32(stats.Incomplete))
+	binary.BigEndian.PutUint32(resp[16:20], uint32(stats.Complete))
+	resp = append(resp, peers4...)
+	resp = append(resp, peers6...)
+	return resp
+}
+
+// 处理 Scrape 请求
+func (s *UDPServer) handleScrape(req []byte, txID uint32) []byte {
+	hashes := req[torrent.UDP_SCRAPE_HEAD:]
+	if len(hashes)%sha1.Size != 0 || len(hashes) == 0 {
+		return errorResponse(txID, ErrMalformedRequest.Error())
+	}
+	num := len(hashes) / sha1.Size
+	if num > torrent.UDP_MAX_SCRAPE {
+		num = torrent.UDP_MAX_SCRAPE
+	}
+	resp := make([]byte, 8, 8+num*12)
+	binary.BigEndian.PutUint32(resp[0:4], torrent.UDP_ACTION_SCRAPE)
+	binary.BigEndian.PutUint32(resp[4:8], txID)
+	for i := 0; i < num; i++ {
+		var infoHash [sha1.Size]byte
+		copy(infoHash[:], hashes[i*sha1.Size:(i+1)*sha1.Size])
+		stats, _ := s.Registry.Scrape(infoHash)
+		resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Complete))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Downloaded))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(stats.Incomplete))
+	}
+	return resp
+}
+
+// 为来源地址签发 connection id：HMAC(密钥, IP+端口+时间窗口) 的前 8 字节，无需在服务端保存状态
+func (s *UDPServer) connectionID(addr *net.UDPAddr, now time.Time) uint64 {
+	window := now.Unix() / int64(CONNECTION_ID_TTL.Seconds())
+	mac := hmac.New(sha256.New, s.secret[:])
+	mac.Write(addr.IP.To16())
+	binary.Write(mac, binary.BigEndian, uint16(addr.Port))
+	binary.Write(mac, binary.BigEndian, window)
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// 校验 connection id，接受当前及上一个时间窗口签发的值
+func (s *UDPServer) validConnectionID(connID uint64, addr *net.UDPAddr) bool {
+	now := s.now()
+	return connID == s.connectionID(addr, now) || connID == s.connectionID(addr, now.Add(-CONNECTION_ID_TTL))
+}
+
+// 构造错误响应
+func errorResponse(txID uint32, msg string) []byte {
+	resp := make([]byte, 8, 8+len(msg))
+	binary.BigEndian.PutUint32(resp[0:4], torrent.UDP_ACTION_ERROR)
+	binary.BigEndian.PutUint32(resp[4:8], txID)
+	return append(resp, msg...)
+}
+
+type addrLimiter struct { // 按来源 IP 的令牌桶限速器
+	mu        sync.Mutex
+	rate      float64 // 每秒补充的令牌数
+	burst     float64 // 令牌桶容量
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newAddrLimiter(rate float64, burst int) *addrLimiter {
+	return &addrLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// 消耗一个令牌，令牌不足时返回 false
+func (l *addrLimiter) allow(ip net.IP, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute { // 定期清理已经回满的令牌桶
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := string(ip.To16())
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
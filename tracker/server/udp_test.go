@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/bencode"
+	"github.com/Akimio521/torrent-go/torrent"
+	"github.com/Akimio521/torrent-go/tracker/server"
+	"github.com/stretchr/testify/require"
+)
+
+// 启动一个监听在回环地址上的 UDP Tracker
+func startUDPServer(t *testing.T, cfg server.Config) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	s, err := server.NewUDPServer(conn, cfg)
+	require.NoError(t, err)
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return conn
+}
+
+// 生成一个 Announce 指向 announce 的种子文件
+func newTorrentFile(t *testing.T, announce string) *torrent.TorrentFile {
+	buf := new(bytes.Buffer)
+	_, err := bencode.Marshal(buf, &torrent.TorrentFile{
+		Announce: announce,
+		Info: torrent.RawInfo{
+			Name:       "test",
+			Length:     1024,
+			PiceLength: 1024,
+			Pieces:     string(make([]byte, 20)),
+		},
+	})
+	require.NoError(t, err)
+	tf, err := torrent.ParseFile(buf)
+	require.NoError(t, err)
+	return tf
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	registry := server.NewRegistry(time.Minute, nil)
+	conn := startUDPServer(t, server.Config{Registry: registry})
+	tf := newTorrentFile(t, "udp://"+conn.LocalAddr().String()+"/announce")
+
+	peers, err := tf.FindPeers(newPeerId('a'), 6881)
+	require.NoError(t, err)
+	require.Empty(t, peers)
+
+	peers, err = tf.FindPeers(newPeerId('b'), 6882)
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, "127.0.0.1:6881", peers[0].GetConnAddr())
+
+	// 注册表与 HTTP Tracker 共享
+	stats, ok := registry.Scrape(tf.GetInfoSHA1())
+	require.True(t, ok)
+	require.Equal(t, 2, stats.Incomplete)
+}
+
+func TestUDPInvalidConnectionID(t *testing.T) {
+	conn := startUDPServer(t, server.Config{})
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer client.Close()
+
+	req := make([]byte, torrent.UDP_ANNOUNCE_LEN)
+	binary.BigEndian.PutUint64(req[0:8], 12345)
+	binary.BigEndian.PutUint32(req[8:12], torrent.UDP_ACTION_ANNOUNCE)
+	binary.BigEndian.PutUint32(req[12:16], 42)
+	_, err = client.Write(req)
+	require.NoError(t, err)
+
+	buf := make([]byte, 256)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, torrent.UDP_ACTION_ERROR, binary.BigEndian.Uint32(buf[0:4]))
+	require.Equal(t, uint32(42), binary.BigEndian.Uint32(buf[4:8]))
+	require.Equal(t, server.ErrInvalidConnectionId.Error(), string(buf[8:n]))
+}
+
+func TestUDPRateLimit(t *testing.T) {
+	conn := startUDPServer(t, server.Config{UDPRate: 0.001, UDPBurst: 2})
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer client.Close()
+
+	req := make([]byte, torrent.UDP_CONNECT_LEN)
+	binary.BigEndian.PutUint64(req[0:8], torrent.UDP_PROTOCOL_ID)
+	binary.BigEndian.PutUint32(req[8:12], torrent.UDP_ACTION_CONNECT)
+	buf := make([]byte, 256)
+	for i := 0; i < 3; i++ {
+		_, err = client.Write(req)
+		require.NoError(t, err)
+		client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = client.Read(buf)
+		if i < 2 {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err) // 超出突发限制的请求被丢弃
+		}
+	}
+}
+
+func TestUDPAnnounceIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skip("ipv6 loopback not available")
+	}
+	s, err := server.NewUDPServer(conn, server.Config{})
+	require.NoError(t, err)
+	go s.Serve()
+	defer s.Close()
+	tf := newTorrentFile(t, "udp://"+conn.LocalAddr().String()+"/announce")
+
+	_, err = tf.FindPeers(newPeerId('a'), 6881)
+	require.NoError(t, err)
+	peers, err := tf.FindPeers(newPeerId('b'), 6882)
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, "[::1]:6881", peers[0].GetConnAddr())
+}
@@ -0,0 +1,513 @@
+package utp
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type connState uint8 // 连接状态
+
+const (
+	stateSynSent   connState = iota // 已发送 SYN，等待确认
+	stateConnected                  // 已建立连接
+	stateClosed                     // 连接已结束
+)
+
+type outPacket struct { // 已发送但尚未被确认的包
+	typ           packetType
+	seq           uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+}
+
+type Conn struct { // uTP 连接（net.Conn），使用 LEDBAT 拥塞控制
+	sock   *Socket
+	raddr  net.Addr
+	recvID uint16 // 本地接收的包携带的连接 ID
+	sendID uint16 // 本地发送的包携带的连接 ID
+
+	mu         sync.Mutex
+	state      connState
+	seqNr      uint16            // 下一个发送的序号
+	ackNr      uint16            // 已按序收到的最后一个序号
+	initSeq    uint16            // 连入的连接发送的第一个序号（重复的 SYN 使用同样的确认）
+	inflight   []*outPacket      // 尚未被确认的包（按序号排列）
+	curWindow  int               // 正在传输的字节数
+	maxWindow  float64           // 拥塞窗口
+	peerWindow int               // 对端的接收窗口
+	replyMicro uint32            // 最近测得的对端到本地的单向延迟（发送给对端）
+	rtt        time.Duration     // 平滑的往返时间
+	rttVar     time.Duration     // 往返时间的偏差
+	rto        time.Duration     // 重传超时
+	dupAcks    int               // 连续的重复 ACK 数量
+	lastAck    uint16            // 最近一次收到的 ack_nr
+	recovering bool              // 正在从丢包中恢复
+	recoverSeq uint16            // 开始恢复时已发送的最后一个序号，确认到该序号前每次部分确认都立即重传下一个包
+	baseDelay  [2]uint32         // 最近两个统计周期内的最小延迟（微秒）
+	delayStart time.Time         // 当前统计周期的开始时间
+	readBuf    []byte            // 已按序收到但尚未读取的数据
+	reorder    map[uint16][]byte // 乱序到达的数据
+	reorderLen int               // 乱序缓存的字节数（与 readBuf 一起计入接收窗口）
+	gotFin     bool              // 收到了对端的 FIN
+	finSeq     uint16            // 对端 FIN 的序号
+	eof        bool              // 对端的数据已全部收到
+	closing    bool              // 本地已关闭（已发送 FIN）
+	closeAt    time.Time         // 本地关闭的时间
+	err        error             // 连接出错的原因
+	lastRecv   time.Time         // 最近一次收到包的时间
+	lastSend   time.Time         // 最近一次发送包的时间
+	rDeadline  time.Time         // 读取超时
+	wDeadline  time.Time         // 写入超时
+	connected  chan struct{}     // 连接建立时关闭
+	done       chan struct{}     // 连接结束时关闭
+	readCh     chan struct{}     // 有数据可读时通知
+	writeCh    chan struct{}     // 窗口有空闲时通知
+}
+
+func newConn(sock *Socket, raddr net.Addr, recvID, sendID uint16) *Conn {
+	now := time.Now()
+	return &Conn{
+		sock:       sock,
+		raddr:      raddr,
+		recvID:     recvID,
+		sendID:     sendID,
+		seqNr:      1,
+		maxWindow:  MIN_WINDOW,
+		peerWindow: MAX_PAYLOAD,
+		rto:        INITIAL_RTO,
+		baseDelay:  [2]uint32{^uint32(0), ^uint32(0)},
+		delayStart: now,
+		reorder:    make(map[uint16][]byte),
+		lastRecv:   now,
+		lastSend:   now,
+		connected:  make(chan struct{}),
+		done:       make(chan struct{}),
+		readCh:     make(chan struct{}, 1),
+		writeCh:    make(chan struct{}, 1),
+	}
+}
+
+// 通知等待中的读写
+func (c *Conn) notify() {
+	for _, ch := range []chan struct{}{c.readCh, c.writeCh} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 发起连接：发送 SYN（SYN 携带本地接收的连接 ID）
+func (c *Conn) connect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendPacket(stSyn, nil)
+}
+
+// 本地的接收窗口
+func (c *Conn) recvWindow() uint32 {
+	return uint32(max(RECV_WINDOW-len(c.readBuf)-c.reorderLen, 0))
+}
+
+// 编码并发送包（调用者需持有锁）
+func (c *Conn) transmit(typ packetType, seq uint16, payload []byte) {
+	h := &header{
+		Type:          typ,
+		ConnID:        c.sendID,
+		Timestamp:     nowMicro(),
+		TimestampDiff: c.replyMicro,
+		WndSize:       c.recvWindow(),
+		SeqNr:         seq,
+		AckNr:         c.ackNr,
+	}
+	if typ == stSyn {
+		h.ConnID = c.recvID
+	}
+	c.lastSend = time.Now()
+	c.sock.write(h.encode(payload), c.raddr)
+}
+
+// 发送占用序号的包（DATA/FIN/SYN），并等待对端确认（调用者需持有锁）
+func (c *Conn) sendPacket(typ packetType, payload []byte) {
+	p := &outPacket{typ: typ, seq: c.seqNr, payload: payload, sentAt: time.Now(), transmissions: 1}
+	c.seqNr++
+	c.inflight = append(c.inflight, p)
+	c.curWindow += HEADER_LEN + len(payload)
+	c.transmit(typ, p.seq, payload)
+}
+
+// 重新发送未被确认的包（调用者需持有锁）
+func (c *Conn) resend(p *outPacket) {
+	p.sentAt = time.Now()
+	p.transmissions++
+	c.transmit(p.typ, p.seq, p.payload)
+}
+
+// 发送确认（调用者需持有锁）
+func (c *Conn) sendState() {
+	c.transmit(stState, c.seqNr, nil)
+}
+
+// 处理收到的包
+func (c *Conn) handle(h *header, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == stateClosed {
+		return
+	}
+	c.lastRecv = time.Now()
+	c.replyMicro = nowMicro() - h.Timestamp
+	c.peerWindow = int(h.WndSize)
+	if h.Type == stReset {
+		c.finish(ErrConnReset)
+		return
+	}
+
+	switch c.state {
+	case stateSynSent:
+		if h.Type != stState {
+			return
+		}
+		c.ackNr = h.SeqNr - 1
+		c.state = stateConnected
+		close(c.connected)
+	case stateConnected:
+		if h.Type == stSyn { // 连入的连接：收到 SYN（或重复的 SYN）
+			select {
+			case <-c.connected:
+			default:
+				c.ackNr = h.SeqNr
+				c.seqNr = uint16(rand.Intn(1 << 16))
+				c.initSeq = c.seqNr
+				close(c.connected)
+			}
+			c.transmit(stState, c.initSeq, nil)
+			return
+		}
+	}
+	c.processAck(h)
+
+	switch h.Type {
+	case stData:
+		c.receive(h.SeqNr, payload)
+		c.sendState()
+	case stFin:
+		if !c.gotFin {
+			c.gotFin, c.finSeq = true, h.SeqNr
+		}
+		c.receive(h.SeqNr, nil)
+		c.sendState()
+	}
+	c.checkDone()
+}
+
+// 处理对端的确认，并按 LEDBAT 调整拥塞窗口（调用者需持有锁）
+func (c *Conn) processAck(h *header) {
+	now := time.Now()
+	acked := 0
+	for len(c.inflight) > 0 && !seqLess(h.AckNr, c.inflight[0].seq) {
+		p := c.inflight[0]
+		c.inflight = c.inflight[1:]
+		size := HEADER_LEN + len(p.payload)
+		c.curWindow -= size
+		acked += size
+		if p.seq == h.AckNr && p.transmissions == 1 { // 只用该确认直接对应且没有重传过的包估计 RTT
+			c.updateRTT(now.Sub(p.sentAt))
+		}
+	}
+	if acked == 0 {
+		if h.Type == stState && h.AckNr == c.lastAck && len(c.inflight) > 0 {
+			c.dupAcks++
+			if c.dupAcks == DUP_ACK_THRESHOLD { // 快速重传
+				c.resend(c.inflight[0])
+				c.maxWindow = max(c.maxWindow/2, MIN_WINDOW)
+				c.startRecovery()
+			}
+		}
+		c.lastAck = h.AckNr
+		return
+	}
+	c.lastAck = h.AckNr
+	c.dupAcks = 0
+	c.rto = max(c.rtt+4*c.rttVar, MIN_RTO)
+	if c.recovering {
+		if len(c.inflight) > 0 && seqLess(h.AckNr, c.recoverSeq) { // 部分确认：下一个包也已丢失
+			c.resend(c.inflight[0])
+		} else {
+			c.recovering = false
+		}
+	}
+
+	if h.TimestampDiff != 0 {
+		if now.Sub(c.delayStart) > BASE_DELAY_PERIOD {
+			c.baseDelay = [2]uint32{^uint32(0), c.baseDelay[0]}
+			c.delayStart = now
+		}
+		c.baseDelay[0] = min(c.baseDelay[0], h.TimestampDiff)
+		base := min(c.baseDelay[0], c.baseDelay[1])
+		ourDelay := time.Duration(h.TimestampDiff-base) * time.Microsecond
+		offTarget := float64(CCONTROL_TARGET-ourDelay) / float64(CCONTROL_TARGET)
+		windowFactor := float64(acked) / max(c.maxWindow, float64(acked))
+		c.maxWindow += MAX_CWND_INCREASE * offTarget * windowFactor
+		c.maxWindow = min(max(c.maxWindow, MIN_WINDOW), MAX_WINDOW)
+	}
+	c.notify()
+}
+
+// 开始丢包恢复（调用者需持有锁）
+func (c *Conn) startRecovery() {
+	c.recovering = true
+	c.recoverSeq = c.seqNr - 1
+}
+
+// 更新 RTT 估计（RFC 6298）
+func (c *Conn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt, c.rttVar = sample, sample/2
+		return
+	}
+	delta := c.rtt - sample
+	if delta < 0 {
+		delta = -delta
+	}
+	c.rttVar += (delta - c.rttVar) / 4
+	c.rtt += (sample - c.rtt) / 8
+}
+
+// 按序交付收到的数据（调用者需持有锁）
+func (c *Conn) receive(seq uint16, payload []byte) {
+	if !seqLess(c.ackNr, seq) || int(seq-c.ackNr) > MAX_REORDER { // 重复或超出范围的包
+		return
+	}
+	if _, ok := c.reorder[seq]; ok || len(payload) > MAX_RECV_PAYLOAD {
+		return
+	}
+	if len(c.readBuf)+c.reorderLen+len(payload) > RECV_WINDOW { // 对端没有遵守接收窗口：丢弃且不确认，等待重传
+		return
+	}
+	c.reorder[seq] = payload
+	c.reorderLen += len(payload)
+	for {
+		next := c.ackNr + 1
+		if c.gotFin && next == c.finSeq {
+			c.ackNr = next
+			c.eof = true
+			delete(c.reorder, next)
+			break
+		}
+		data, ok := c.reorder[next]
+		if !ok {
+			break
+		}
+		delete(c.reorder, next)
+		c.reorderLen -= len(data)
+		c.ackNr = next
+		c.readBuf = append(c.readBuf, data...)
+	}
+	c.notify()
+}
+
+// 双方的数据都已确认时结束连接（调用者需持有锁）
+func (c *Conn) checkDone() {
+	if c.closing && len(c.inflight) == 0 && (c.eof || time.Since(c.closeAt) > LINGER_TIME) {
+		c.finish(net.ErrClosed)
+	}
+}
+
+// 结束连接（调用者需持有锁）
+func (c *Conn) finish(err error) {
+	if c.state == stateClosed {
+		return
+	}
+	c.state = stateClosed
+	if c.err == nil {
+		c.err = err
+	}
+	close(c.done)
+	c.notify()
+	go c.sock.remove(c)
+}
+
+// 因错误结束连接
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finish(err)
+}
+
+// 连接出错的原因
+func (c *Conn) error() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// 定时检查重传、保活与超时，直到连接结束
+func (c *Conn) run() {
+	ticker := time.NewTicker(TICK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.tick(time.Now())
+	}
+}
+
+func (c *Conn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == stateClosed {
+		return
+	}
+	if now.Sub(c.lastRecv) > CONN_TIMEOUT {
+		c.finish(ErrTimeout)
+		return
+	}
+	if len(c.inflight) > 0 && now.Sub(c.inflight[0].sentAt) > c.rto {
+		p := c.inflight[0]
+		if p.transmissions >= MAX_RETRANSMITS || (p.typ == stSyn && p.transmissions >= MAX_SYN_RETRIES) {
+			c.finish(ErrTimeout)
+			return
+		}
+		c.resend(p)
+		c.maxWindow = MIN_WINDOW
+		c.rto = min(c.rto*2, MAX_RTO)
+		c.startRecovery()
+	}
+	if c.state == stateConnected && now.Sub(c.lastSend) > KEEPALIVE {
+		c.sendState()
+	}
+	c.checkDone()
+}
+
+// 等待通知、超时或连接结束
+func (c *Conn) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+	case <-c.done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.readBuf) > 0 {
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.closing || c.state == stateClosed {
+			err := c.err
+			if c.closing || err == nil {
+				err = net.ErrClosed
+			}
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.rDeadline
+		c.mu.Unlock()
+		if err := c.wait(c.readCh, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		c.mu.Lock()
+		if c.closing || c.state == stateClosed {
+			err := c.err
+			if c.closing || err == nil {
+				err = net.ErrClosed
+			}
+			c.mu.Unlock()
+			return written, err
+		}
+		window := min(int(c.maxWindow), c.peerWindow)
+		n := min(len(b)-written, MAX_PAYLOAD)
+		if c.curWindow == 0 || c.curWindow+HEADER_LEN+n <= window { // 窗口为空时总允许发送一个包
+			c.sendPacket(stData, append([]byte(nil), b[written:written+n]...))
+			written += n
+			c.mu.Unlock()
+			continue
+		}
+		deadline := c.wDeadline
+		c.mu.Unlock()
+		if err := c.wait(c.writeCh, deadline); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// 关闭连接：发送 FIN，在后台等待对端确认
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing || c.state == stateClosed {
+		return nil
+	}
+	c.closing = true
+	c.closeAt = time.Now()
+	c.sendPacket(stFin, nil)
+	c.notify()
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.sock.Addr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rDeadline = t
+	c.mu.Unlock()
+	c.notify()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wDeadline = t
+	c.mu.Unlock()
+	c.notify()
+	return nil
+}
+
+var _ net.Conn = (*Conn)(nil)
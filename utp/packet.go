@@ -0,0 +1,73 @@
+package utp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+type header struct { // uTP 包头（BEP 29）
+	Type          packetType // 包类型
+	Extension     uint8      // 第一个扩展的类型
+	ConnID        uint16     // 连接 ID
+	Timestamp     uint32     // 发送时间（微秒）
+	TimestampDiff uint32     // 发送方最近一次测得的单向延迟（微秒）
+	WndSize       uint32     // 发送方的接收窗口
+	SeqNr         uint16     // 序号
+	AckNr         uint16     // 已按序收到的最后一个序号
+}
+
+// 将包头与负载编码为 UDP 包（不携带扩展）
+func (h *header) encode(payload []byte) []byte {
+	b := make([]byte, HEADER_LEN+len(payload))
+	b[0] = byte(h.Type)<<4 | VERSION
+	b[1] = 0
+	binary.BigEndian.PutUint16(b[2:4], h.ConnID)
+	binary.BigEndian.PutUint32(b[4:8], h.Timestamp)
+	binary.BigEndian.PutUint32(b[8:12], h.TimestampDiff)
+	binary.BigEndian.PutUint32(b[12:16], h.WndSize)
+	binary.BigEndian.PutUint16(b[16:18], h.SeqNr)
+	binary.BigEndian.PutUint16(b[18:20], h.AckNr)
+	copy(b[HEADER_LEN:], payload)
+	return b
+}
+
+// 解析 UDP 包，跳过所有扩展（如选择性确认）
+func parsePacket(b []byte) (*header, []byte, error) {
+	if !isPacket(b) {
+		return nil, nil, ErrMalformedPacket
+	}
+	h := &header{
+		Type:          packetType(b[0] >> 4),
+		Extension:     b[1],
+		ConnID:        binary.BigEndian.Uint16(b[2:4]),
+		Timestamp:     binary.BigEndian.Uint32(b[4:8]),
+		TimestampDiff: binary.BigEndian.Uint32(b[8:12]),
+		WndSize:       binary.BigEndian.Uint32(b[12:16]),
+		SeqNr:         binary.BigEndian.Uint16(b[16:18]),
+		AckNr:         binary.BigEndian.Uint16(b[18:20]),
+	}
+	off := HEADER_LEN
+	for ext := h.Extension; ext != 0; {
+		if len(b) < off+2 || len(b) < off+2+int(b[off+1]) {
+			return nil, nil, ErrMalformedPacket
+		}
+		ext = b[off]
+		off += 2 + int(b[off+1])
+	}
+	return h, b[off:], nil
+}
+
+// 是否为 uTP 包（用于与共享 UDP 套接字的 DHT 等协议区分）
+func isPacket(b []byte) bool {
+	return len(b) >= HEADER_LEN && b[0]&0x0F == VERSION && packetType(b[0]>>4) <= stSyn
+}
+
+// 当前时间（微秒，截断为 32 位）
+func nowMicro() uint32 {
+	return uint32(time.Now().UnixMicro())
+}
+
+// 序号 a 是否在 b 之前（考虑回绕）
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
@@ -0,0 +1,289 @@
+package utp
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connKey struct { // 区分连接：对端地址与本地接收的连接 ID
+	addr string
+	id   uint16
+}
+
+type packet struct { // 非 uTP 的 UDP 包
+	data []byte
+	addr net.Addr
+}
+
+type Socket struct { // 在一个 UDP 套接字上复用的 uTP 连接（非 uTP 的包可通过 PacketConn 读取，如 DHT）
+	conn     net.PacketConn
+	mu       sync.Mutex
+	conns    map[connKey]*Conn
+	acceptCh chan *Conn
+	packets  chan packet
+	closed   chan struct{}
+	once     sync.Once
+	reject   atomic.Bool // 拒绝连入的连接
+}
+
+// 在 conn 上新建 uTP 套接字，需要调用 Serve 开始接收
+func NewSocket(conn net.PacketConn) *Socket {
+	return &Socket{
+		conn:     conn,
+		conns:    make(map[connKey]*Conn),
+		acceptCh: make(chan *Conn, ACCEPT_BACKLOG),
+		packets:  make(chan packet, PACKET_BACKLOG),
+		closed:   make(chan struct{}),
+	}
+}
+
+// 在 UDP 地址上监听
+func Listen(network, addr string) (*Socket, error) {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocket(conn), nil
+}
+
+// 接收 UDP 包并分发给对应的连接，直到套接字被关闭
+func (s *Socket) Serve() error {
+	buf := make([]byte, MAX_PACKET_SIZE)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			s.Close()
+			return err
+		}
+		b := append([]byte(nil), buf[:n]...)
+		if !isPacket(b) {
+			select {
+			case s.packets <- packet{b, addr}:
+			default: // 无人读取时丢弃
+			}
+			continue
+		}
+		if h, payload, err := parsePacket(b); err == nil {
+			s.dispatch(addr, h, payload)
+		}
+	}
+}
+
+// 将 uTP 包交给对应的连接，SYN 会建立新的连接
+func (s *Socket) dispatch(addr net.Addr, h *header, payload []byte) {
+	s.mu.Lock()
+	c, ok := s.conns[connKey{addr.String(), h.ConnID}]
+	if !ok && h.Type == stSyn {
+		key := connKey{addr.String(), h.ConnID + 1}
+		if c, ok = s.conns[key]; !ok { // 新的连接（重复的 SYN 交给已有的连接）
+			if s.reject.Load() {
+				s.mu.Unlock()
+				s.write((&header{Type: stReset, ConnID: h.ConnID, Timestamp: nowMicro(), AckNr: h.SeqNr}).encode(nil), addr)
+				return
+			}
+			if len(s.acceptCh) == cap(s.acceptCh) { // 等待 Accept 的连接过多
+				s.mu.Unlock()
+				return
+			}
+			c = newConn(s, addr, h.ConnID+1, h.ConnID)
+			c.state = stateConnected
+			c.handle(h, payload)
+			s.conns[key] = c
+			s.acceptCh <- c
+			s.mu.Unlock()
+			go c.run()
+			return
+		}
+	}
+	s.mu.Unlock()
+	if c != nil {
+		c.handle(h, payload)
+		return
+	}
+	if h.Type == stData || h.Type == stFin { // 未知的连接
+		s.write((&header{Type: stReset, ConnID: h.ConnID, Timestamp: nowMicro(), AckNr: h.SeqNr}).encode(nil), addr)
+	}
+}
+
+// 发送 UDP 包
+func (s *Socket) write(b []byte, addr net.Addr) error {
+	_, err := s.conn.WriteTo(b, addr)
+	return err
+}
+
+// 移除已经结束的连接
+func (s *Socket) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := connKey{c.raddr.String(), c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+// 连接到 addr，超时时间为 DIAL_TIMEOUT
+func (s *Socket) Dial(addr string) (net.Conn, error) {
+	return s.DialTimeout(addr, DIAL_TIMEOUT)
+}
+
+// 在 timeout 内连接到 addr
+func (s *Socket) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	var c *Conn
+	for {
+		id := uint16(rand.Intn(1 << 16))
+		key := connKey{raddr.String(), id}
+		if _, ok := s.conns[key]; ok {
+			continue
+		}
+		if _, ok := s.conns[connKey{raddr.String(), id + 1}]; ok {
+			continue
+		}
+		c = newConn(s, raddr, id, id+1)
+		s.conns[key] = c
+		break
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.closed:
+		s.remove(c)
+		return nil, net.ErrClosed
+	default:
+	}
+	go c.run()
+	c.connect()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.connected:
+		return c, nil
+	case <-c.done:
+		return nil, c.error()
+	case <-timer.C:
+		c.fail(os.ErrDeadlineExceeded)
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// 等待连入的连接
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.acceptCh:
+		return c, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// 以 RESET 回应之后收到的 SYN，不再建立连入的连接（用于不调用 Accept 的套接字，如只复用端口运行 DHT 时）
+func (s *Socket) RejectIncoming() {
+	s.reject.Store(true)
+}
+
+// 本地地址
+func (s *Socket) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// 关闭套接字及其上的所有连接
+func (s *Socket) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+		s.mu.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+		for _, c := range conns {
+			c.fail(net.ErrClosed)
+		}
+	})
+	return err
+}
+
+// 与 uTP 共享套接字的其他协议（如 DHT）使用的 PacketConn，只能读到非 uTP 的包
+func (s *Socket) PacketConn() net.PacketConn {
+	return &packetConn{s: s, closed: make(chan struct{})}
+}
+
+type packetConn struct { // Socket 上的非 uTP 包
+	s        *Socket
+	mu       sync.Mutex
+	deadline time.Time
+	closed   chan struct{}
+	once     sync.Once
+	reject   atomic.Bool // 拒绝连入的连接
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.mu.Lock()
+	deadline := pc.deadline
+	pc.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-pc.s.packets:
+		return copy(b, p.data), p.addr, nil
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-pc.closed:
+		return 0, nil, net.ErrClosed
+	case <-pc.s.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return pc.s.conn.WriteTo(b, addr)
+}
+
+// 只关闭该视图，底层套接字由 Socket 关闭
+func (pc *packetConn) Close() error {
+	pc.once.Do(func() { close(pc.closed) })
+	return nil
+}
+
+func (pc *packetConn) LocalAddr() net.Addr {
+	return pc.s.conn.LocalAddr()
+}
+
+func (pc *packetConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *packetConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.deadline = t
+	return nil
+}
+
+func (pc *packetConn) SetWriteDeadline(t time.Time) error {
+	return pc.s.conn.SetWriteDeadline(t)
+}
+
+var _ net.Listener = (*Socket)(nil)
@@ -0,0 +1,50 @@
+package utp
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	HEADER_LEN        = 20                     // 包头长度
+	VERSION           = 1                      // 协议版本
+	MAX_PAYLOAD       = 1200                   // 每个包的最大负载（避免 IP 分片）
+	MAX_RECV_PAYLOAD  = 1500                   // 接受的包的最大负载（其他实现按路径 MTU 发包，可能比 MAX_PAYLOAD 大）
+	MAX_PACKET_SIZE   = 64 * 1024              // 读取 UDP 包的缓冲区大小
+	RECV_WINDOW       = 1024 * 1024            // 本地接收窗口
+	MIN_WINDOW        = 2 * MAX_PAYLOAD        // 最小拥塞窗口
+	MAX_WINDOW        = RECV_WINDOW            // 最大拥塞窗口
+	CCONTROL_TARGET   = 100 * time.Millisecond // LEDBAT 的目标排队延迟
+	MAX_CWND_INCREASE = 3000                   // 每个 RTT 拥塞窗口最多增加的字节数
+	BASE_DELAY_PERIOD = 1 * time.Minute        // 基础延迟的统计周期（保留最近两个周期的最小值）
+	INITIAL_RTO       = 1 * time.Second        // 初始重传超时
+	MIN_RTO           = 500 * time.Millisecond // 最小重传超时
+	MAX_RTO           = 16 * time.Second       // 最大重传超时
+	MAX_RETRANSMITS   = 8                      // 同一个包的最大发送次数
+	MAX_SYN_RETRIES   = 3                      // SYN 的最大发送次数
+	DUP_ACK_THRESHOLD = 3                      // 触发快速重传的重复 ACK 数量
+	MAX_REORDER       = 1024                   // 最多缓存的乱序包数量
+	TICK_INTERVAL     = 50 * time.Millisecond  // 检查超时的间隔
+	KEEPALIVE         = 29 * time.Second       // 没有发送任何包时发送保活包的间隔
+	CONN_TIMEOUT      = 60 * time.Second       // 没有收到任何包时断开连接的超时时间
+	LINGER_TIME       = 10 * time.Second       // 关闭后等待 FIN 被确认的最长时间
+	DIAL_TIMEOUT      = 5 * time.Second        // 建立连接的默认超时时间
+	ACCEPT_BACKLOG    = 32                     // 等待 Accept 的连接数量
+	PACKET_BACKLOG    = 64                     // 等待读取的非 uTP 包数量
+)
+
+type packetType uint8 // 包类型
+
+const (
+	stData  packetType = iota // 数据
+	stFin                     // 结束
+	stState                   // 确认（不占用序号）
+	stReset                   // 重置连接
+	stSyn                     // 建立连接
+)
+
+var (
+	ErrMalformedPacket = errors.New("malformed utp packet")   // 包格式错误
+	ErrConnReset       = errors.New("utp connection reset")   // 对端重置了连接
+	ErrTimeout         = errors.New("utp connection timeout") // 连接超时
+)
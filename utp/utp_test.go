@@ -0,0 +1,223 @@
+package utp_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Akimio521/torrent-go/utp"
+	"github.com/stretchr/testify/require"
+)
+
+type lossyConn struct { // 随机丢弃发送的包
+	net.PacketConn
+	percent *atomic.Int64
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if n, _ := rand.Int(rand.Reader, big.NewInt(100)); n.Int64() < c.percent.Load() {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// 在本地回环地址上新建 uTP 套接字，丢包率为 loss%
+func newSocket(t *testing.T, loss *atomic.Int64) *utp.Socket {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := utp.NewSocket(&lossyConn{conn, loss})
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// 建立一对 uTP 连接，连接建立后丢包率为 loss%
+func connPair(t *testing.T, loss int64) (net.Conn, net.Conn) {
+	percent := new(atomic.Int64)
+	a, b := newSocket(t, percent), newSocket(t, percent)
+	defer percent.Store(loss)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := b.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	ca, err := a.Dial(b.Addr().String())
+	require.NoError(t, err)
+	select {
+	case cb := <-accepted:
+		return ca, cb
+	case <-time.After(3 * time.Second):
+		t.Fatal("accept timeout")
+	}
+	return nil, nil
+}
+
+// 双向传输随机数据并校验
+func transfer(t *testing.T, a, b net.Conn, size int) {
+	dataA, dataB := make([]byte, size), make([]byte, size/2)
+	rand.Read(dataA)
+	rand.Read(dataB)
+	errs := make(chan error, 2)
+	go func() { _, err := a.Write(dataA); errs <- err }()
+	go func() { _, err := b.Write(dataB); errs <- err }()
+
+	a.SetReadDeadline(time.Now().Add(20 * time.Second))
+	b.SetReadDeadline(time.Now().Add(20 * time.Second))
+	gotA, gotB := make([]byte, len(dataA)), make([]byte, len(dataB))
+	_, err := io.ReadFull(b, gotA)
+	require.NoError(t, err)
+	_, err = io.ReadFull(a, gotB)
+	require.NoError(t, err)
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.True(t, bytes.Equal(dataA, gotA))
+	require.True(t, bytes.Equal(dataB, gotB))
+}
+
+func TestTransfer(t *testing.T) {
+	a, b := connPair(t, 0)
+	transfer(t, a, b, 2*1024*1024)
+
+	// 关闭后对端读到 EOF
+	require.NoError(t, a.Close())
+	_, err := b.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, b.Close())
+}
+
+func TestLossyTransfer(t *testing.T) {
+	a, b := connPair(t, 10)
+	transfer(t, a, b, 256*1024)
+}
+
+func TestDialTimeout(t *testing.T) {
+	s := newSocket(t, new(atomic.Int64))
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0") // 不回应的对端
+	require.NoError(t, err)
+	defer silent.Close()
+	_, err = s.DialTimeout(silent.LocalAddr().String(), 200*time.Millisecond)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestReadDeadline(t *testing.T) {
+	a, _ := connPair(t, 0)
+	a.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := a.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestPacketConn(t *testing.T) {
+	s := newSocket(t, new(atomic.Int64))
+	pc := s.PacketConn()
+	defer pc.Close()
+	raw, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer raw.Close()
+
+	// 非 uTP 的包（如 DHT 的 B 编码消息）交给 PacketConn
+	_, err = raw.WriteTo([]byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"), s.Addr())
+	require.NoError(t, err)
+	pc.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1500)
+	n, addr, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, raw.LocalAddr().String(), addr.String())
+	require.Equal(t, byte('d'), buf[0])
+	require.Equal(t, 56, n)
+
+	_, err = pc.WriteTo([]byte("pong"), raw.LocalAddr())
+	require.NoError(t, err)
+	raw.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, _, err = raw.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf[:n]))
+}
+
+// 编码 uTP 包（typ 为 BEP 29 中的包类型）
+func rawPacket(typ byte, connID, seq uint16, payload []byte) []byte {
+	b := make([]byte, utp.HEADER_LEN, utp.HEADER_LEN+len(payload))
+	b[0] = typ<<4 | utp.VERSION
+	binary.BigEndian.PutUint16(b[2:4], connID)
+	binary.BigEndian.PutUint16(b[16:18], seq)
+	return append(b, payload...)
+}
+
+func TestRecvWindow(t *testing.T) {
+	s := newSocket(t, new(atomic.Int64))
+	raw, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// 不遵守接收窗口的对端：本地不读取数据时，超出窗口的数据不会被确认
+	const connID, synSeq, size = 100, 1000, 1000
+	buf := make([]byte, 1500)
+	_, err = raw.WriteTo(rawPacket(4, connID, synSeq, nil), s.Addr()) // SYN
+	require.NoError(t, err)
+	_, _, err = raw.ReadFrom(buf)
+	require.NoError(t, err)
+	var ack uint16
+	for i := 1; i <= utp.RECV_WINDOW/size+10; i++ {
+		_, err = raw.WriteTo(rawPacket(0, connID+1, uint16(synSeq+i), make([]byte, size)), s.Addr()) // DATA
+		require.NoError(t, err)
+		n, _, err := raw.ReadFrom(buf)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, n, utp.HEADER_LEN)
+		ack = binary.BigEndian.Uint16(buf[18:20])
+	}
+	require.Equal(t, uint16(synSeq+utp.RECV_WINDOW/size), ack)
+}
+
+func TestReorderWindow(t *testing.T) {
+	s := newSocket(t, new(atomic.Int64))
+	raw, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(10 * time.Second))
+
+	const connID, synSeq, size = 100, 1000, 1400
+	buf := make([]byte, 1500)
+	_, err = raw.WriteTo(rawPacket(4, connID, synSeq, nil), s.Addr()) // SYN
+	require.NoError(t, err)
+	_, _, err = raw.ReadFrom(buf)
+	require.NoError(t, err)
+	send := func(seq uint16, payload []byte) (ack uint16) {
+		_, err := raw.WriteTo(rawPacket(0, connID+1, seq, payload), s.Addr()) // DATA
+		require.NoError(t, err)
+		n, _, err := raw.ReadFrom(buf)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, n, utp.HEADER_LEN)
+		return binary.BigEndian.Uint16(buf[18:20])
+	}
+
+	// 乱序到达的数据同样计入接收窗口，超出部分不会被缓存
+	stored := utp.RECV_WINDOW / size
+	for i := 2; i <= stored+10; i++ {
+		require.Equal(t, uint16(synSeq), send(uint16(synSeq+i), make([]byte, size)))
+	}
+	// 超过最大负载的包被丢弃
+	require.Equal(t, uint16(synSeq), send(synSeq+1, make([]byte, utp.MAX_RECV_PAYLOAD+1)))
+	// 补上缺失的包后只交付窗口内缓存的数据
+	require.Equal(t, uint16(synSeq+1+stored), send(synSeq+1, make([]byte, utp.RECV_WINDOW-stored*size)))
+}
+
+func TestRejectIncoming(t *testing.T) {
+	a := newSocket(t, new(atomic.Int64))
+	b := newSocket(t, new(atomic.Int64))
+	b.RejectIncoming()
+
+	// 拒绝连入的套接字立即重置连接，而不是建立无人 Accept 的连接
+	start := time.Now()
+	_, err := a.DialTimeout(b.Addr().String(), 3*time.Second)
+	require.ErrorIs(t, err, utp.ErrConnReset)
+	require.Less(t, time.Since(start), time.Second)
+}